@@ -6,6 +6,8 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
 )
 
 // BrowseResponse contains folders and images at a given path.
@@ -29,11 +31,6 @@ type ImageEntry struct {
 	Indexed bool   `json:"indexed"`
 }
 
-var imageExts = map[string]bool{
-	".jpg": true, ".jpeg": true, ".png": true,
-	".gif": true, ".webp": true, ".bmp": true,
-}
-
 func browse(path string, indexedPaths map[string]bool) (BrowseResponse, error) {
 	if path == "" {
 		return listDrives()
@@ -68,8 +65,7 @@ func browse(path string, indexedPaths map[string]bool) (BrowseResponse, error) {
 			continue
 		}
 
-		ext := strings.ToLower(filepath.Ext(e.Name()))
-		if imageExts[ext] {
+		if image.IsSupported(filepath.Ext(e.Name())) {
 			imgPath := filepath.Join(path, e.Name())
 			resp.Images = append(resp.Images, ImageEntry{
 				Name:    e.Name(),
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -13,11 +14,17 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/ramon-reichert/locallens/internal/platform/logger"
 	"github.com/ramon-reichert/locallens/internal/service"
+	"github.com/ramon-reichert/locallens/internal/service/embedding"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+	"github.com/ramon-reichert/locallens/internal/service/index"
+	"github.com/ramon-reichert/locallens/internal/service/search"
+	"github.com/ramon-reichert/locallens/internal/service/thumbnail"
 )
 
 // SetupStatusInfo holds the current setup state returned to the UI.
@@ -29,6 +36,9 @@ type SetupStatusInfo struct {
 	// ActiveProcessor is the backend currently loaded into this process.
 	// It can only change by restarting the application.
 	ActiveProcessor string `json:"activeProcessor"`
+	// ConfigError reports a config file that couldn't be used as written,
+	// such as a prompt that isn't a valid template. Empty when it loaded.
+	ConfigError string `json:"configError,omitempty"`
 }
 
 // SetupProgress reports a setup step to the caller.
@@ -75,9 +85,16 @@ func New(cfg Config) *Handlers {
 func (h *Handlers) Register(mux *http.ServeMux, staticFS fs.FS) {
 	mux.HandleFunc("POST /api/index", h.handleIndex)
 	mux.HandleFunc("GET /api/search", h.handleSearch)
+	mux.HandleFunc("GET /api/similar", h.handleSimilar)
+	mux.HandleFunc("POST /api/search/by-image", h.handleSearchByImage)
 	mux.HandleFunc("GET /api/browse", h.handleBrowse)
 	mux.HandleFunc("GET /api/images", h.handleImage)
+	mux.HandleFunc("GET /api/thumbnails", h.handleThumbnail)
 	mux.HandleFunc("GET /api/index-info", h.handleIndexInfo)
+	mux.HandleFunc("GET /api/failures", h.handleFailures)
+	mux.HandleFunc("POST /api/failures/retry", h.handleRetryFailures)
+	mux.HandleFunc("GET /api/folder-context", h.handleFolderContext)
+	mux.HandleFunc("PUT /api/folder-context", h.handleSetFolderContext)
 	mux.HandleFunc("POST /api/open", h.handleOpen)
 	mux.HandleFunc("GET /api/setup/status", h.handleSetupStatus)
 	mux.HandleFunc("POST /api/setup/run", h.handleSetupRun)
@@ -187,6 +204,7 @@ func (h *Handlers) handleIndex(w http.ResponseWriter, r *http.Request) {
 			"added":        result.Added,
 			"failed":       result.Failed,
 			"total":        result.Total,
+			"skipped":      result.Skipped,
 		}
 	}
 
@@ -224,16 +242,203 @@ func (h *Handlers) handleSearch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// minScore filters on the raw score; minRelevance ("weak" or "strong")
+	// on the calibrated label. confident=true is shorthand for
+	// minRelevance=weak, i.e. drop everything labeled "none".
+	var opts service.SearchOptions
+	if ms := r.URL.Query().Get("minScore"); ms != "" {
+		v, err := strconv.ParseFloat(ms, 32)
+		if err != nil {
+			http.Error(w, "invalid minScore", http.StatusBadRequest)
+			return
+		}
+		opts.MinScore = float32(v)
+	}
+	// minQuality drops images whose description was flagged by the quality
+	// checks, e.g. truncated or in another language (score below 1).
+	if mq := r.URL.Query().Get("minQuality"); mq != "" {
+		v, err := strconv.ParseFloat(mq, 32)
+		if err != nil || v < 0 || v > 1 {
+			http.Error(w, "minQuality must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		opts.MinQuality = float32(v)
+	}
+	switch mr := search.Relevance(r.URL.Query().Get("minRelevance")); mr {
+	case "", search.RelevanceNone, search.RelevanceWeak, search.RelevanceStrong:
+		opts.MinRelevance = mr
+	default:
+		http.Error(w, "minRelevance must be weak or strong", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("confident") == "true" && !opts.MinRelevance.AtLeast(search.RelevanceWeak) {
+		opts.MinRelevance = search.RelevanceWeak
+	}
+
+	if off := r.URL.Query().Get("offset"); off != "" {
+		v, err := strconv.Atoi(off)
+		if err != nil || v < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		opts.Offset = v
+	}
+
+	// exact=true bypasses the ANN graph of large folders, e.g. to verify
+	// approximate results.
+	opts.Exact = r.URL.Query().Get("exact") == "true"
+
+	sortOrder, ok := service.ParseSortOrder(r.URL.Query().Get("sort"))
+	if !ok {
+		http.Error(w, "sort must be score, fileDate, captureDate, name or size", http.StatusBadRequest)
+		return
+	}
+	opts.Sort = sortOrder
+
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
 	defer cancel()
 
-	results, err := svc.Search(ctx, folder, query, k)
-	if err != nil {
+	page, err := svc.SearchPage(ctx, folder, query, k, opts)
+	switch {
+	case errors.Is(err, embedding.ErrDimensionMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		h.log(r.Context(), "search error", "query", query, "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Paging metadata travels in headers so the body stays a plain result
+	// array. X-Next-Offset is omitted on the last page.
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if next := page.Offset + len(page.Results); next < page.Total {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(next))
+	}
+
+	writeJSON(w, http.StatusOK, page.Results)
+}
+
+func (h *Handlers) handleSimilar(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	folder := r.URL.Query().Get("folder")
+
+	if path == "" || folder == "" {
+		http.Error(w, "path and folder are required", http.StatusBadRequest)
+		return
+	}
+
+	k := 20
+	if ks := r.URL.Query().Get("k"); ks != "" {
+		if v, err := strconv.Atoi(ks); err == nil && v > 0 {
+			k = v
+		}
+	}
+
+	// Near duplicates are excluded unless the caller explicitly asks for them.
+	excludeDuplicates := r.URL.Query().Get("duplicates") != "true"
+
+	results, err := svc.SimilarTo(r.Context(), folder, path, k, excludeDuplicates)
+	switch {
+	case errors.Is(err, service.ErrNotIndexed):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		h.log(r.Context(), "similar error", "path", path, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// maxQueryImageBytes caps the size of an uploaded query image.
+const maxQueryImageBytes = 64 << 20
+
+// handleSearchByImage accepts either a multipart form with an "image" file
+// field, or a JSON body with a local "path". Both forms take one or more
+// folders ("folder" form values or the "folders" JSON array) and an optional k.
+func (h *Handlers) handleSearchByImage(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	var req struct {
+		Path    string   `json:"path"`
+		Folders []string `json:"folders"`
+		K       int      `json:"k"`
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxQueryImageBytes)
+		if err := r.ParseMultipartForm(maxQueryImageBytes); err != nil {
+			http.Error(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			http.Error(w, "image is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// The vision pipeline reads from disk, so the upload is spooled to a
+		// temp file that keeps its extension for decoder selection.
+		tmp, err := os.CreateTemp("", "locallens-query-*"+filepath.Ext(header.Filename))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = io.Copy(tmp, file)
+		tmp.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		req.Path = tmp.Name()
+		req.Folders = r.MultipartForm.Value["folder"]
+		if ks := r.FormValue("k"); ks != "" {
+			req.K, _ = strconv.Atoi(ks)
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" || len(req.Folders) == 0 {
+		http.Error(w, "image (or path) and folder are required", http.StatusBadRequest)
+		return
+	}
+
+	k := 20
+	if req.K > 0 {
+		k = req.K
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	results, err := svc.SearchByImage(ctx, req.Folders, req.Path, k)
+	switch {
+	case errors.Is(err, embedding.ErrDimensionMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		h.log(r.Context(), "search by image error", "path", req.Path, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	writeJSON(w, http.StatusOK, results)
 }
 
@@ -269,6 +474,54 @@ func (h *Handlers) handleImage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, path)
 }
 
+func (h *Handlers) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	size := thumbnail.DefaultSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "size must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+
+	thumb, err := svc.Thumbnail(path, size)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, image.ErrUnsupportedFormat):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, image.ErrTooLarge), errors.Is(err, image.ErrMalformed):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, image.ErrBusy), errors.Is(err, image.ErrDecodeTimeout):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		h.log(r.Context(), "thumbnail error", "path", path, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The URL names the image, not its content: revalidate every time, so
+	// an edited image shows its new thumbnail.
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, thumb)
+}
+
 func (h *Handlers) handleIndexInfo(w http.ResponseWriter, r *http.Request) {
 	svc := h.requireService(w)
 	if svc == nil {
@@ -281,8 +534,127 @@ func (h *Handlers) handleIndexInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	count := svc.IndexInfo(folder)
-	writeJSON(w, http.StatusOK, map[string]int{"count": count})
+	// describeRungs counts images per describe ladder rung, to audit how
+	// often descriptions needed degraded settings.
+	writeJSON(w, http.StatusOK, map[string]any{
+		"count":         svc.IndexInfo(folder),
+		"describeRungs": svc.DescribeRungs(folder),
+	})
+}
+
+// handleFailures lists the images of a folder that failed to index, with
+// their error class, attempts and retry state.
+func (h *Handlers) handleFailures(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if folder == "" {
+		http.Error(w, "folder is required", http.StatusBadRequest)
+		return
+	}
+
+	failures, err := svc.Failures(folder)
+	if err != nil {
+		h.log(r.Context(), "failures error", "folder", folder, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, failures)
+}
+
+// handleRetryFailures forgets the recorded failures of some images of a
+// folder, or of all of them when no paths are given, so the next indexing
+// run tries them again, skipped ones included.
+func (h *Handlers) handleRetryFailures(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	var req struct {
+		Folder string   `json:"folder"`
+		Paths  []string `json:"paths"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Folder == "" {
+		http.Error(w, "folder is required", http.StatusBadRequest)
+		return
+	}
+
+	cleared, err := svc.RetryFailures(req.Folder, req.Paths)
+	switch {
+	case errors.Is(err, index.ErrLocked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		h.log(r.Context(), "retry failures error", "folder", req.Folder, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"cleared": cleared})
+}
+
+// handleFolderContext returns the context set for the images of a folder.
+func (h *Handlers) handleFolderContext(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	folder := r.URL.Query().Get("folder")
+	if folder == "" {
+		http.Error(w, "folder is required", http.StatusBadRequest)
+		return
+	}
+
+	folderContext, err := svc.FolderContext(folder)
+	if err != nil {
+		h.log(r.Context(), "folder context error", "folder", folder, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"context": folderContext})
+}
+
+// handleSetFolderContext sets the context the images of a folder are
+// described with from the next indexing run on. An empty context removes it.
+func (h *Handlers) handleSetFolderContext(w http.ResponseWriter, r *http.Request) {
+	svc := h.requireService(w)
+	if svc == nil {
+		return
+	}
+
+	var req struct {
+		Folder  string `json:"folder"`
+		Context string `json:"context"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Folder == "" {
+		http.Error(w, "folder is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := svc.SetFolderContext(req.Folder, req.Context); err != nil {
+		h.log(r.Context(), "set folder context error", "folder", req.Folder, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handlers) handleOpen(w http.ResponseWriter, r *http.Request) {
@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -43,6 +44,7 @@ func run() error {
 	if err != nil {
 		log(ctx, "config warning, using defaults", "error", err)
 	}
+	cfg = serviceConfig(cfg, err)
 
 	// Initialize the Kronk SDK runtime, then try to create the service.
 	// If models aren't downloaded yet, this fails gracefully and the
@@ -92,14 +94,28 @@ func run() error {
 // setupStatus returns the current setup state for the UI.
 // Re-reads config each call to reflect any changes from a completed setup.
 func setupStatus() app.SetupStatusInfo {
-	c, _ := config.Load()
-	return app.SetupStatusInfo{
+	c, err := config.Load()
+	info := app.SetupStatusInfo{
 		BasePath:          c.BasePath,
 		DefaultPath:       config.DefaultBasePath(),
 		Processor:         c.Processor,
 		DetectedProcessor: kronk.DetectedProcessor(),
 		ActiveProcessor:   kronk.ActiveProcessor(),
 	}
+	if err != nil {
+		info.ConfigError = err.Error()
+	}
+	return info
+}
+
+// serviceConfig returns the config to run the service with, given what
+// config.Load returned. Invalid prompts are replaced with the default ones
+// for this run only; the config file keeps the user's until they fix them.
+func serviceConfig(cfg config.Config, loadErr error) config.Config {
+	if errors.Is(loadErr, config.ErrInvalidPrompt) {
+		return cfg.WithDefaultPrompts()
+	}
+	return cfg
 }
 
 // setupRunner orchestrates the full setup flow: install llama.cpp libraries,
@@ -113,7 +129,14 @@ func setupStatus() app.SetupStatusInfo {
 // next launch. In that case it returns (nil, nil) after emitting a
 // "restart_required" progress event.
 func setupRunner(ctx context.Context, log logger.Logger, req app.SetupRequest, progress app.SetupProgress) (*service.Service, error) {
-	cfg, _ := config.Load()
+	// A config file that failed to parse was only partly applied, so saving
+	// it back would lose the rest; invalid prompts are kept as read.
+	cfg, loadErr := config.Load()
+	if loadErr != nil && !errors.Is(loadErr, config.ErrInvalidPrompt) {
+		log(ctx, "setup: load config failed", "error", loadErr)
+		progress("config", "error: "+loadErr.Error())
+		return nil, loadErr
+	}
 
 	// Remember what was persisted so we can decide whether the new
 	// request changes the processor field in config.json.
@@ -166,7 +189,7 @@ func setupRunner(ctx context.Context, log logger.Logger, req app.SetupRequest, p
 	}
 
 	progress("service", "initializing")
-	svc, err := initService(ctx, log, cfg)
+	svc, err := initService(ctx, log, serviceConfig(cfg, loadErr))
 	if err != nil {
 		log(ctx, "setup: service init failed", "error", err)
 		progress("service", "error: "+err.Error())
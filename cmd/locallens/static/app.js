@@ -12,6 +12,9 @@ const state = {
     indexAbort: null,
 };
 
+// Longest side, in pixels, of the grid card thumbnails.
+const THUMBNAIL_SIZE = 256;
+
 // Main UI elements
 const searchInput = document.getElementById("search-input");
 const searchBtn = document.getElementById("search-btn");
@@ -38,6 +41,7 @@ const setupProgressText = document.getElementById("setup-progress-text");
 const setupBrowseBtn = document.getElementById("setup-browse-btn");
 const setupProcessor = document.getElementById("setup-processor");
 const setupVersion = document.getElementById("setup-version");
+const configWarning = document.getElementById("config-warning");
 const folderPicker = document.getElementById("folder-picker");
 const folderPickerTree = document.getElementById("folder-picker-tree");
 const folderPickerSelect = document.getElementById("folder-picker-select");
@@ -96,6 +100,13 @@ async function checkSetupStatus() {
         // only change by restarting the binary.
         state.activeProcessor = data.activeProcessor || "";
 
+        // A config file that couldn't be used as written, e.g. a broken
+        // prompt template. The app runs with defaults until it's fixed.
+        configWarning.textContent = data.configError
+            ? `\u26A0 ${data.configError}. Running with the defaults until config.json is fixed.`
+            : "";
+        configWarning.hidden = !data.configError;
+
         updateSetupBadge();
         updateSetupActionButton();
 
@@ -739,12 +750,12 @@ function renderSearchResults(results) {
 
     for (const r of results) {
         const name = fileName(r.Path);
-        const card = createImageCard(r.Path, name, true, r.Score);
+        const card = createImageCard(r.Path, name, true, r.Score, r.Region);
         resultsGrid.appendChild(card);
     }
 }
 
-function createImageCard(path, name, indexed, score) {
+function createImageCard(path, name, indexed, score, region = null) {
     const card = document.createElement("div");
     card.className = "result-card";
     card.dataset.path = path;
@@ -755,7 +766,12 @@ function createImageCard(path, name, indexed, score) {
     card.title = name;
 
     const img = document.createElement("img");
-    img.src = `/api/images?path=${encodeURIComponent(path)}`;
+    img.src = `/api/thumbnails?path=${encodeURIComponent(path)}&size=${THUMBNAIL_SIZE}`;
+    // Fall back to the original, e.g. before setup or for files the
+    // thumbnailer can't decode.
+    img.addEventListener("error", () => {
+        img.src = `/api/images?path=${encodeURIComponent(path)}`;
+    }, { once: true });
     img.alt = name;
     img.loading = "lazy";
 
@@ -780,13 +796,48 @@ function createImageCard(path, name, indexed, score) {
         scoreEl.className = "score";
         scoreEl.textContent = `${(score * 100).toFixed(1)}%`;
         info.appendChild(scoreEl);
+
+        const similarBtn = document.createElement("button");
+        similarBtn.className = "similar-btn";
+        similarBtn.textContent = "\u2248";
+        similarBtn.title = "More like this";
+        similarBtn.addEventListener("click", (e) => {
+            e.stopPropagation();
+            findSimilar(path);
+        });
+        info.appendChild(similarBtn);
     }
 
     card.appendChild(img);
+    if (region) card.appendChild(createRegionMark(img, region));
     card.appendChild(info);
     return card;
 }
 
+// createRegionMark outlines the part of a tiled image where the best match
+// was found. Region is in fractions of the image; the image is letterboxed
+// by object-fit: contain, so the mark is placed once its size is known.
+function createRegionMark(img, region) {
+    const mark = document.createElement("div");
+    mark.className = "region-mark";
+    mark.hidden = true;
+
+    img.addEventListener("load", () => {
+        const scale = Math.min(img.clientWidth / img.naturalWidth, img.clientHeight / img.naturalHeight);
+        const w = img.naturalWidth * scale;
+        const h = img.naturalHeight * scale;
+        const left = img.offsetLeft + (img.clientWidth - w) / 2;
+        const top = img.offsetTop + (img.clientHeight - h) / 2;
+
+        mark.style.left = `${left + region.X * w}px`;
+        mark.style.top = `${top + region.Y * h}px`;
+        mark.style.width = `${region.W * w}px`;
+        mark.style.height = `${region.H * h}px`;
+        mark.hidden = false;
+    });
+    return mark;
+}
+
 function fileName(path) {
     const parts = path.replace(/\\/g, "/").split("/");
     return parts[parts.length - 1];
@@ -945,8 +996,12 @@ function formatIndexSummary(event) {
     const count = event.count ?? event.indexedTotal ?? 0;
     const added = event.added ?? 0;
     const failed = event.failed ?? 0;
-    if (failed > 0) {
-        return `${count} images indexed (${added} new, ${failed} failed)`;
+    const skipped = event.skipped ?? 0;
+    const notes = [];
+    if (failed > 0) notes.push(`${added} new, ${failed} failed`);
+    if (skipped > 0) notes.push(`${skipped} skipped after earlier failures`);
+    if (notes.length > 0) {
+        return `${count} images indexed (${notes.join("; ")})`;
     }
     return `${count} images indexed`;
 }
@@ -1046,3 +1101,43 @@ async function doSearch() {
         searchBtn.disabled = false;
     }
 }
+
+async function findSimilar(path) {
+    if (requireSetup()) return;
+    if (!state.selectedPath) return;
+
+    const k = parseInt(topkInput.value) || 10;
+
+    resultsStatus.textContent = "Searching...";
+    resultsGrid.innerHTML = "";
+    fileList.innerHTML = "";
+
+    try {
+        const res = await fetch(
+            `/api/similar?path=${encodeURIComponent(path)}&folder=${encodeURIComponent(state.selectedPath)}&k=${k}`
+        );
+
+        if (res.status === 503) {
+            openSetup(true);
+            return;
+        }
+        if (!res.ok) {
+            resultsStatus.textContent = "Search failed";
+            return;
+        }
+
+        const results = await res.json();
+
+        if (!results || results.length === 0) {
+            resultsStatus.textContent = "No similar images found";
+            return;
+        }
+
+        resultsStatus.textContent = `${results.length} images like "${fileName(path)}"`;
+        state.selectedImage = "";
+        renderSearchFileList(results);
+        renderSearchResults(results);
+    } catch {
+        resultsStatus.textContent = "Search failed";
+    }
+}
@@ -2,9 +2,16 @@ module github.com/ramon-reichert/locallens
 
 go 1.26.0
 
-require github.com/ardanlabs/kronk v1.28.6
+require (
+	github.com/ardanlabs/kronk v1.28.6
+	github.com/ncruces/go-sqlite3 v0.35.0
+)
 
-require github.com/ardanlabs/jinja v1.4.0 // indirect
+require (
+	github.com/ardanlabs/jinja v1.4.0 // indirect
+	github.com/ncruces/go-sqlite3-wasm/v3 v3.1.35302 // indirect
+	github.com/ncruces/julianday v1.0.0 // indirect
+)
 
 require (
 	cel.dev/expr v0.25.2 // indirect
@@ -66,6 +66,10 @@ type EmbedModelConfig struct {
 	CacheTypeK     string `json:"cacheTypeK"`
 	CacheTypeV     string `json:"cacheTypeV"`
 	FlashAttention bool   `json:"flashAttention"`
+	// Dimensions truncates embeddings to their first n components, which
+	// Matryoshka-trained models such as embeddinggemma support at 512, 256 or
+	// 128 with modest quality loss. 0 keeps the model's full output.
+	Dimensions int `json:"dimensions"`
 }
 
 // CategorizeModelConfig holds Kronk configuration for the categorization model.
@@ -80,7 +84,8 @@ type CategorizeModelConfig struct {
 // =========================================================================
 // Vision prompt and image config
 
-// VisionPrompt holds prompt configuration for image description.
+// VisionPrompt holds prompt configuration for image description. The prompt
+// texts are Go text/templates over PromptVars.
 type VisionPrompt struct {
 	SystemPrompt     string  `json:"systemPrompt"`
 	UserPrompt       string  `json:"userPrompt"`
@@ -93,9 +98,51 @@ type VisionPrompt struct {
 	RepeatLastN      int     `json:"repeatLastN"`
 	FrequencyPenalty float64 `json:"frequencyPenalty"`
 	PresencePenalty  float64 `json:"presencePenalty"`
+
+	// ContextPrompt introduces the context of the image folder, when one
+	// is set. It goes ahead of UserPrompt, followed by the context. Leave it
+	// empty when UserPrompt places {{.Context}} itself.
+	ContextPrompt string `json:"contextPrompt"`
+	// TilePrompt goes ahead of UserPrompt when describing one tile of a
+	// tiled image. See TilingConfig.
+	TilePrompt string `json:"tilePrompt"`
+	// SheetPrompt goes ahead of UserPrompt when describing the contact sheet
+	// of an animation. See AnimationConfig.
+	SheetPrompt string `json:"sheetPrompt"`
+}
+
+// DescribeFallback holds the degraded settings the indexing retry ladder
+// steps through when describing an image fails, loops or gets truncated.
+// Each rung keeps the degradations of the rungs before it; a zero value
+// skips its rung.
+type DescribeFallback struct {
+	// MaxSide is the smaller size images are downscaled to, which keeps
+	// large images from filling the vision model's context.
+	MaxSide int `json:"maxSide"`
+	// SystemPrompt and UserPrompt are a simpler, less demanding prompt.
+	SystemPrompt string `json:"systemPrompt"`
+	UserPrompt   string `json:"userPrompt"`
+	// DryMultiplier and RepeatPenalty are stronger anti-repetition settings.
+	DryMultiplier float64 `json:"dryMultiplier"`
+	RepeatPenalty float64 `json:"repeatPenalty"`
+}
+
+// QualityConfig holds the description quality checks.
+type QualityConfig struct {
+	// MinTokens is the shortest description not flagged as too short.
+	MinTokens int `json:"minTokens"`
+	// Language is the ISO 639-1 code descriptions are expected in, such as
+	// "en". Empty disables the language check.
+	Language string `json:"language"`
+	// RetryBelow is the quality score under which a description counts as
+	// unusable and is retried down the describe fallback ladder, like an
+	// empty, refused or looping one. Scores run from 0 to 1; a single minor
+	// issue, such as a truncated description, scores 0.5 or more.
+	RetryBelow float64 `json:"retryBelow"`
 }
 
 // CategorizePrompt holds prompt configuration for description categorization.
+// The prompt texts are Go text/templates over PromptVars.
 type CategorizePrompt struct {
 	SystemPrompt     string  `json:"systemPrompt"`
 	UserPrompt       string  `json:"userPrompt"`
@@ -108,11 +155,166 @@ type CategorizePrompt struct {
 	RepeatLastN      int     `json:"repeatLastN"`
 	FrequencyPenalty float64 `json:"frequencyPenalty"`
 	PresencePenalty  float64 `json:"presencePenalty"`
+
+	// ContextPrompt introduces the context of the image folder, when one
+	// is set. It goes ahead of UserPrompt, followed by the context. Leave it
+	// empty when UserPrompt places {{.Context}} itself.
+	ContextPrompt string `json:"contextPrompt"`
+}
+
+// Place names a location for the .Place prompt variable. Images whose EXIF
+// position is within RadiusKm of it get its name.
+type Place struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	RadiusKm  float64 `json:"radiusKm"`
 }
 
 // ImageConfig holds image preprocessing settings.
 type ImageConfig struct {
 	MaxSide int `json:"maxSide"`
+	// Background is the "#rrggbb" color transparent images are flattened
+	// onto before they are sent to the vision model.
+	Background string `json:"background"`
+	// JPEGQuality is the quality, from 1 to 100, images are re-encoded with
+	// for the vision model.
+	JPEGQuality int `json:"jpegQuality"`
+	// ExternalDecoders maps file extensions LocalLens can't decode itself,
+	// such as ".heic", to a command converting a file to PNG. "{in}" and
+	// "{out}" in the command stand for the source and PNG paths, e.g.
+	// ["heif-convert", "{in}", "{out}"].
+	ExternalDecoders map[string][]string `json:"externalDecoders,omitempty"`
+	// MaxMegapixels caps the pixels of an image, read from its header
+	// before it is decoded, and of the frames of an animation together.
+	// Larger images are recorded as failures instead of exhausting memory.
+	// 0 means no limit.
+	MaxMegapixels int `json:"maxMegapixels"`
+	// MaxFileMB caps the size of an image file. 0 means no limit.
+	MaxFileMB int `json:"maxFileMB"`
+	// DecodeTimeoutSeconds caps the time indexing waits for an image to
+	// decode, after which the image is recorded as malformed. It doesn't
+	// stop the decode, which keeps running in the background. 0 means no
+	// limit.
+	DecodeTimeoutSeconds int `json:"decodeTimeoutSeconds"`
+	// MaxConcurrentDecodes caps the images decoded at once, counting
+	// decodes still running past their timeout, so those can't pile up
+	// and exhaust memory. 0 means no limit.
+	MaxConcurrentDecodes int `json:"maxConcurrentDecodes"`
+}
+
+// ThumbnailConfig holds the settings of the thumbnails the UI shows.
+type ThumbnailConfig struct {
+	// Dir is where generated thumbnails are cached.
+	Dir string `json:"dir"`
+	// Quality is the JPEG quality, from 1 to 100, of thumbnails.
+	Quality int `json:"quality"`
+	// PregenerateSize is the size thumbnails are generated at while
+	// indexing, so browsing an indexed folder doesn't wait for them. 0
+	// disables it.
+	PregenerateSize int `json:"pregenerateSize"`
+}
+
+// TilingConfig controls the tiling of images too elongated or too large to
+// be read at ImageConfig.MaxSide, such as panoramas and document scans. Such
+// images are described whole, as an overview, and in overlapping tiles, each
+// at MaxSide.
+type TilingConfig struct {
+	Enabled bool `json:"enabled"`
+	// MinAspect is the ratio of the longer to the shorter side from which an
+	// image is tiled.
+	MinAspect float64 `json:"minAspect"`
+	// MinSide is the longer side, in pixels, from which an image is tiled
+	// whatever its aspect ratio.
+	MinSide int `json:"minSide"`
+	// TileSide is the side, in pixels of the original image, tiles aim for.
+	// Tiles grow past it to stay within MaxTiles.
+	TileSide int `json:"tileSide"`
+	// Overlap is the share of a tile it overlaps its neighbors by, so
+	// things on a tile border are seen whole in one of them.
+	Overlap float64 `json:"overlap"`
+	// MaxTiles caps the tiles of an image, not counting the overview.
+	MaxTiles int `json:"maxTiles"`
+	// TileExpressions caps the search expressions kept from each tile, so
+	// tiled images don't outrank others on the sheer number of them. 0
+	// keeps them all.
+	TileExpressions int `json:"tileExpressions"`
+}
+
+// AnimationConfig controls how animated GIF and WebP images are described.
+type AnimationConfig struct {
+	// Frames is how many frames are sampled from an animation, the first
+	// one included. Below 2, animations are described by their first frame
+	// like still images.
+	Frames int `json:"frames"`
+	// Sampling picks the frames at "scene" changes, or "even"ly spaced.
+	Sampling string `json:"sampling"`
+	// Mode is "sheet" to describe the frames together, laid out on one
+	// contact sheet image, or "frames" to describe each of them on its own
+	// and merge their expressions.
+	Mode string `json:"mode"`
+	// FrameExpressions caps the search expressions kept from each frame
+	// after the first in "frames" mode. 0 keeps them all.
+	FrameExpressions int `json:"frameExpressions"`
+}
+
+// =========================================================================
+// Search config
+
+// SearchConfig holds result calibration settings. Relevance thresholds are
+// z-scores of an image score over the query's background similarity to the
+// index, and depend on the embedding model.
+type SearchConfig struct {
+	StrongRelevance  float64 `json:"strongRelevance"`
+	WeakRelevance    float64 `json:"weakRelevance"`
+	BackgroundSample int     `json:"backgroundSample"`
+	// ANNMinImages is the folder size from which searches use an approximate
+	// nearest neighbor graph instead of scoring every image. 0 disables ANN.
+	ANNMinImages int `json:"annMinImages"`
+}
+
+// =========================================================================
+// Index config
+
+// IndexConfig holds folder index storage settings.
+type IndexConfig struct {
+	// Quantization is the precision new indexes store vectors at: "float32"
+	// (or empty), "float16" or "int8". Existing indexes keep the precision
+	// recorded in their file.
+	Quantization string `json:"quantization"`
+	// Store is the backend index files are kept in: "gob" (or empty), one
+	// file rewritten on every save, or "sqlite", a database updated in place
+	// that other tools can query. Switching to "sqlite" copies existing gob
+	// indexes on first use and leaves the gob files in place.
+	Store string `json:"store"`
+	// Location is where index files are kept: "folder" (or empty) inside
+	// each image folder, or "central" under Dir, keyed by folder ID, which
+	// leaves read-only media and shared folders untouched. On macOS folder
+	// IDs follow device numbers, which depend on mount order, so removable
+	// media are best given a Mapping there.
+	Location string `json:"location"`
+	// Dir is the root of the central index location.
+	Dir string `json:"dir"`
+	// Mapping assigns index directories to image folders (and their
+	// subfolders) explicitly, whatever the Location.
+	Mapping map[string]string `json:"mapping,omitempty"`
+	// CacheMaxMB caps the memory of the folder indexes kept loaded between
+	// searches. The least recently used ones are evicted past it. 0 removes
+	// the cap.
+	CacheMaxMB int `json:"cacheMaxMB"`
+}
+
+// =========================================================================
+// Retry config
+
+// RetryConfig controls how later indexing runs retry images that failed.
+type RetryConfig struct {
+	// MaxAttempts is how many times an image is tried before it is skipped
+	// permanently. 0 keeps retrying.
+	MaxAttempts int `json:"maxAttempts"`
+	// BackoffMinutes is the wait before the first retry. It doubles after
+	// every further failure.
+	BackoffMinutes int `json:"backoffMinutes"`
 }
 
 // =========================================================================
@@ -128,8 +330,17 @@ type Config struct {
 	Embed            EmbedModelConfig      `json:"embedModel"`
 	Categorize       CategorizeModelConfig `json:"categorizeModel"`
 	DescribePrompt   VisionPrompt          `json:"prompt"`
+	DescribeFallback DescribeFallback      `json:"describeFallback"`
+	Quality          QualityConfig         `json:"quality"`
 	CategorizePrompt CategorizePrompt      `json:"categorizePrompt"`
+	Places           []Place               `json:"places,omitempty"`
 	Image            ImageConfig           `json:"image"`
+	Thumbnails       ThumbnailConfig       `json:"thumbnails"`
+	Tiling           TilingConfig          `json:"tiling"`
+	Animation        AnimationConfig       `json:"animation"`
+	Search           SearchConfig          `json:"search"`
+	Index            IndexConfig           `json:"index"`
+	Retry            RetryConfig           `json:"retry"`
 }
 
 // ModelFilePaths holds resolved file system paths for a single model.
@@ -148,6 +359,24 @@ func DefaultBasePath() string {
 	return filepath.Join(home, appDir, "kronk")
 }
 
+// DefaultIndexDir returns the default root of the central index location.
+func DefaultIndexDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, appDir, "indexes")
+}
+
+// DefaultThumbnailDir returns the default thumbnail cache directory.
+func DefaultThumbnailDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, appDir, "thumbs")
+}
+
 // Defaults returns the default configuration with sensible values.
 func Defaults() Config {
 	return Config{
@@ -185,6 +414,9 @@ func Defaults() Config {
 		DescribePrompt: VisionPrompt{
 			SystemPrompt:     "You extract image keywords for semantic search.",
 			UserPrompt:       "Describe this image in detail. Include: objects, people, background, colors, actions, visible text and overall context.",
+			ContextPrompt:    "This image belongs to a set of images that share a context. Use the vocabulary of that context and focus on what sets this image apart from the others. Context:",
+			TilePrompt:       "This is one part of a larger image. Describe only what this part shows, reading any text in it.",
+			SheetPrompt:      "These are frames sampled from an animation, in reading order. Describe what the animation shows and how it changes.",
 			MaxTokens:        300,
 			Temperature:      0.1,
 			DryMultiplier:    3.0,
@@ -194,6 +426,18 @@ func Defaults() Config {
 			RepeatLastN:      64,
 			FrequencyPenalty: 0.5,
 		},
+		DescribeFallback: DescribeFallback{
+			MaxSide:       384,
+			SystemPrompt:  "You describe images briefly.",
+			UserPrompt:    "Describe this image in a few sentences.",
+			DryMultiplier: 5.0,
+			RepeatPenalty: 1.3,
+		},
+		Quality: QualityConfig{
+			MinTokens:  15,
+			Language:   "en",
+			RetryBelow: 0.5,
+		},
 		CategorizePrompt: CategorizePrompt{ // TODO: check if this prompt can be cached
 			SystemPrompt: "You turn an image description into compact semantic search expressions. " +
 				"Reply with a JSON object with one key, \"expressions\", whose value is an array of strings.\n" +
@@ -205,6 +449,7 @@ func Defaults() Config {
 				"Cover different aspects of the image: main subjects, secondary objects, actions, setting, colors, materials, style, mood, text, and context.\n" +
 				"Drop filler words.\n",
 			UserPrompt:       "Extract semantic search expressions from this image description:",
+			ContextPrompt:    "The image belongs to a set of images that share a context. Prefer the vocabulary of that context. Context:",
 			MaxTokens:        300,
 			Temperature:      0.3,
 			DryMultiplier:    1.05,
@@ -213,14 +458,60 @@ func Defaults() Config {
 			FrequencyPenalty: 0.5,
 		},
 		Image: ImageConfig{
-			MaxSide: 512,
+			MaxSide:              512,
+			Background:           "#ffffff",
+			JPEGQuality:          100,
+			MaxMegapixels:        100,
+			MaxFileMB:            512,
+			DecodeTimeoutSeconds: 60,
+			MaxConcurrentDecodes: 4,
+		},
+		Thumbnails: ThumbnailConfig{
+			Dir:             DefaultThumbnailDir(),
+			Quality:         80,
+			PregenerateSize: 256,
+		},
+		Tiling: TilingConfig{
+			MinAspect:       2.5,
+			MinSide:         4000,
+			TileSide:        1536,
+			Overlap:         0.15,
+			MaxTiles:        6,
+			TileExpressions: 5,
+		},
+		Animation: AnimationConfig{
+			Frames:           4,
+			Sampling:         "scene",
+			Mode:             "sheet",
+			FrameExpressions: 5,
+		},
+		Search: SearchConfig{
+			// Tuned for embeddinggemma: an unrelated image's aggregate
+			// lands around 1.5σ above the background, below both thresholds.
+			StrongRelevance:  4.0,
+			WeakRelevance:    2.5,
+			BackgroundSample: 1024,
+			ANNMinImages:     5000,
+		},
+		Index: IndexConfig{
+			Location:   "folder",
+			Store:      "gob",
+			Dir:        DefaultIndexDir(),
+			CacheMaxMB: 1024,
+		},
+		Retry: RetryConfig{
+			MaxAttempts:    3,
+			BackoffMinutes: 30,
 		},
 	}
 }
 
 // Load reads the config from disk. Starts with defaults, then applies any
 // JSON overrides found in the config file. Returns an error if the config
-// file exists but contains invalid JSON.
+// file exists but contains invalid JSON, or prompts that aren't valid
+// templates (see PromptVars). In the latter case the config is returned as
+// read, with an error wrapping ErrInvalidPrompt; it is still safe to Save,
+// but must run with WithDefaultPrompts.
 func Load() (Config, error) {
 	cfg := Defaults()
 
@@ -233,9 +524,23 @@ func Load() (Config, error) {
 		return cfg, fmt.Errorf("parse config %s: %w", configPath(), err)
 	}
 
+	if err := validatePrompts(cfg); err != nil {
+		return cfg, fmt.Errorf("config %s: %w", configPath(), err)
+	}
+
 	return cfg, nil
 }
 
+// WithDefaultPrompts returns a copy of cfg with the default prompts, to run
+// with when its own prompts are invalid: a broken template would fail every
+// image. The copy is meant for running only; saving it would discard the
+// prompts the user wrote.
+func (c Config) WithDefaultPrompts() Config {
+	d := Defaults()
+	c.DescribePrompt, c.DescribeFallback, c.CategorizePrompt = d.DescribePrompt, d.DescribeFallback, d.CategorizePrompt
+	return c
+}
+
 // Save writes the config to disk.
 func Save(cfg Config) error {
 	dir := filepath.Dir(configPath())
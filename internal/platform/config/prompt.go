@@ -0,0 +1,144 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrInvalidPrompt is returned by Load when a prompt of the config file is
+// not a valid template.
+var ErrInvalidPrompt = errors.New("invalid prompt template")
+
+// PromptVars are the per-image values prompts can use as Go text/template
+// fields, e.g. "{{if .Place}}Photo taken in {{.Place}}. {{end}}". Values
+// that are unknown for an image are zero.
+type PromptVars struct {
+	FileName string    // base name of the image file
+	Folder   string    // name of the folder holding the image
+	Folders  []string  // names of the folders holding the image, nearest first
+	Date     time.Time // EXIF capture date
+	Camera   string    // EXIF camera make and model
+	GPS      string    // EXIF position as "latitude, longitude"
+	Place    string    // name of the configured place nearest to GPS
+	Context  string    // context set for the image folder
+}
+
+// promptFuncs are the functions prompt templates can call besides the
+// text/template builtins.
+var promptFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	// month formats a date as "August 2024", or "" for the zero date.
+	"month": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("January 2006")
+	},
+}
+
+// prompts holds the parsed prompt templates by text. Prompts come from the
+// config, so there are only ever a few of them; Load parses them all.
+var prompts sync.Map // text → *template.Template
+
+// RenderPrompt executes the prompt template text with vars. Text without
+// template actions is returned as is. Each text is parsed once.
+func RenderPrompt(text string, vars PromptVars) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := parsePrompt(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("render prompt: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// RenderPrompts renders the system and user prompt templates for an image,
+// putting the folder context, introduced by the rendered contextIntro, ahead
+// of the user prompt. Without an intro the user prompt is left as is, to
+// place the context itself.
+func RenderPrompts(system, user, contextIntro string, vars PromptVars) (string, string, error) {
+	system, err := RenderPrompt(system, vars)
+	if err != nil {
+		return "", "", fmt.Errorf("system prompt: %w", err)
+	}
+	if user, err = RenderPrompt(user, vars); err != nil {
+		return "", "", fmt.Errorf("user prompt: %w", err)
+	}
+	intro, err := RenderPrompt(contextIntro, vars)
+	if err != nil {
+		return "", "", fmt.Errorf("context prompt: %w", err)
+	}
+
+	folderContext := strings.TrimSpace(vars.Context)
+	if folderContext == "" || intro == "" {
+		return system, user, nil
+	}
+	return system, strings.TrimSpace(intro+" "+folderContext) + "\n\n" + user, nil
+}
+
+// parsePrompt returns the parsed template of text, parsing it on first use.
+func parsePrompt(text string) (*template.Template, error) {
+	if tmpl, ok := prompts.Load(text); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := template.New("prompt").Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse prompt: %w", err)
+	}
+	prompts.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// samplePromptVars has every field set, so validating a template reaches all
+// of its branches that depend on a value being known.
+var samplePromptVars = PromptVars{
+	FileName: "IMG_0001.jpg",
+	Folder:   "Lisbon",
+	Folders:  []string{"Lisbon", "2024", "Photos"},
+	Date:     time.Date(2024, time.August, 15, 18, 30, 0, 0, time.Local),
+	Camera:   "Canon EOS R6",
+	GPS:      "38.71, -9.14",
+	Place:    "Lisbon",
+	Context:  "summer holidays",
+}
+
+// validatePrompts checks that every prompt of cfg is a valid template using
+// only known variables. The errors it returns wrap ErrInvalidPrompt.
+func validatePrompts(cfg Config) error {
+	prompts := []struct {
+		name, text string
+	}{
+		{"prompt.systemPrompt", cfg.DescribePrompt.SystemPrompt},
+		{"prompt.userPrompt", cfg.DescribePrompt.UserPrompt},
+		{"prompt.contextPrompt", cfg.DescribePrompt.ContextPrompt},
+		{"prompt.tilePrompt", cfg.DescribePrompt.TilePrompt},
+		{"prompt.sheetPrompt", cfg.DescribePrompt.SheetPrompt},
+		{"describeFallback.systemPrompt", cfg.DescribeFallback.SystemPrompt},
+		{"describeFallback.userPrompt", cfg.DescribeFallback.UserPrompt},
+		{"categorizePrompt.systemPrompt", cfg.CategorizePrompt.SystemPrompt},
+		{"categorizePrompt.userPrompt", cfg.CategorizePrompt.UserPrompt},
+		{"categorizePrompt.contextPrompt", cfg.CategorizePrompt.ContextPrompt},
+	}
+
+	for _, p := range prompts {
+		for _, vars := range []PromptVars{samplePromptVars, {}} {
+			if _, err := RenderPrompt(p.text, vars); err != nil {
+				return fmt.Errorf("%w: %s: %w (available fields: .FileName, .Folder, .Folders, .Date, .Camera, .GPS, .Place, .Context)", ErrInvalidPrompt, p.name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	text := "{{if .Place}}Photo taken in {{.Place}}, {{month .Date}}. {{end}}Describe {{.FileName}}."
+
+	got, err := config.RenderPrompt(text, config.PromptVars{
+		FileName: "IMG_0001.jpg",
+		Place:    "Lisbon",
+		Date:     time.Date(2024, time.August, 15, 0, 0, 0, 0, time.Local),
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if want := "Photo taken in Lisbon, August 2024. Describe IMG_0001.jpg."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, err = config.RenderPrompt(text, config.PromptVars{FileName: "a.jpg"})
+	if err != nil {
+		t.Fatalf("render without place: %v", err)
+	}
+	if want := "Describe a.jpg."; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderPrompt_PlainText(t *testing.T) {
+	// Braces outside template actions are kept as is.
+	text := "Reply with a JSON object {\"expressions\": [...]}."
+	got, err := config.RenderPrompt(text, config.PromptVars{})
+	if err != nil || got != text {
+		t.Errorf("expected text unchanged, got %q, %v", got, err)
+	}
+}
+
+func TestRenderPrompts(t *testing.T) {
+	tests := []struct {
+		name    string
+		intro   string
+		context string
+		want    string
+	}{
+		{"no context", "Context:", "  ", "Extract expressions:"},
+		{"no intro", "", "wedding", "Extract expressions:"},
+		{"context", "Context:", "wedding of Ana and João\n", "Context: wedding of Ana and João\n\nExtract expressions:"},
+		{"templated intro", "{{if .Place}}In {{.Place}}.{{end}} Context:", "wedding", "In Lisbon. Context: wedding\n\nExtract expressions:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := config.PromptVars{Place: "Lisbon", Context: tt.context}
+			system, user, err := config.RenderPrompts("You extract expressions.", "Extract expressions:", tt.intro, vars)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if system != "You extract expressions." {
+				t.Errorf("system = %q", system)
+			}
+			if user != tt.want {
+				t.Errorf("user = %q, want %q", user, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := config.RenderPrompts("ok", "{{.Missing", "", config.PromptVars{}); err == nil || !strings.Contains(err.Error(), "user prompt") {
+		t.Errorf("expected a user prompt error, got %v", err)
+	}
+}
+
+func TestLoad_InvalidPromptTemplate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	dir := filepath.Join(home, ".locallens")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"prompt": {"userPrompt": "Describe this photo from {{.Town}}."}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load()
+	if err == nil {
+		t.Fatal("expected an error for an unknown template field")
+	}
+	if !errors.Is(err, config.ErrInvalidPrompt) {
+		t.Errorf("expected ErrInvalidPrompt, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "prompt.userPrompt") || !strings.Contains(err.Error(), "Town") {
+		t.Errorf("expected the error to name the prompt and field, got %v", err)
+	}
+
+	// The prompts written by the user are kept, so saving the config
+	// doesn't lose them; running uses the defaults.
+	if want := "Describe this photo from {{.Town}}."; cfg.DescribePrompt.UserPrompt != want {
+		t.Errorf("expected the prompt as written, got %q", cfg.DescribePrompt.UserPrompt)
+	}
+	if got := cfg.WithDefaultPrompts().DescribePrompt.UserPrompt; got != config.Defaults().DescribePrompt.UserPrompt {
+		t.Errorf("expected the default prompt, got %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+const (
+	annFileName = ".locallens.hnsw"
+
+	// annCandidates is how many distinct images the ANN graph proposes per
+	// query. They are then scored exactly, so ranking within the candidates
+	// matches exact search.
+	annCandidates = 500
+
+	// annMaxTombstones is the share of deleted graph nodes, left by removed
+	// and re-described images, above which the graph is compacted before
+	// it is saved.
+	annMaxTombstones = 0.25
+)
+
+// annListener keeps an HNSW graph in sync with its folder index. The index
+// has updated its Matrix by the time it notifies, so the graph only has to
+// link the entry's rows.
+type annListener struct {
+	graph *search.HNSW
+}
+
+func (l annListener) EntryAdded(e index.Entry) { l.graph.Add(e.Path) }
+func (l annListener) EntryRemoved(path string) { l.graph.Remove(path) }
+
+// annFor returns the ready ANN graph of a folder, or nil when the folder
+// should be searched exactly: ANN is disabled, the folder is smaller than the
+// configured threshold, or the graph is still being built. The first call
+// for a large folder starts building the graph in the background.
+func (s *Service) annFor(key string, idx *index.Index) *search.HNSW {
+	if s.annMinImages <= 0 || idx.Len() < s.annMinImages {
+		return nil
+	}
+
+	s.annMu.Lock()
+	defer s.annMu.Unlock()
+
+	graph, ok := s.anns[key]
+	if !ok {
+		s.anns[key] = nil // building
+		go s.buildANN(key, idx)
+	}
+	return graph
+}
+
+// buildANN loads the persisted graph of a folder, or starts an empty one,
+// attaches it to the index and adds whatever entries it is missing. Loading
+// tombstones entries that left the index or whose vectors changed, so a graph
+// saved before a crash is repaired instead of rebuilt, unless too much of it
+// is tombstoned.
+//
+// The graph scores through the index's Matrix rather than copies of the
+// vectors, so it costs no more than its links and keeps the Matrix's
+// quantization. A cached index keeps the same Matrix for its lifetime.
+func (s *Service) buildANN(key string, idx *index.Index) {
+	ctx := context.Background()
+	start := time.Now()
+
+	annPath, err := s.annPath(key)
+	if err != nil {
+		s.log(ctx, "locate ann graph error", "folder", key, "error", err)
+		s.annMu.Lock()
+		delete(s.anns, key)
+		s.annMu.Unlock()
+		return
+	}
+
+	graph, err := search.LoadHNSW(annPath, idx.Matrix())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.log(ctx, "load ann graph error, rebuilding", "folder", key, "error", err)
+		}
+		graph = search.NewHNSW(search.DefaultHNSWConfig(), idx.Matrix())
+	}
+
+	// Attach before the catch-up scan so entries added meanwhile aren't
+	// missed; an entry seen by both is simply re-added.
+	idx.AddListener(annListener{graph: graph})
+
+	added := 0
+	for _, p := range idx.Paths() {
+		if graph.Has(p) {
+			continue
+		}
+		graph.Add(p)
+		if graph.Has(p) {
+			added++
+		}
+	}
+
+	compacted := compactANN(graph)
+
+	if _, locked := s.lockedByOther(key); (added > 0 || compacted) && !locked {
+		if err := graph.Save(annPath); err != nil {
+			s.log(ctx, "save ann graph error", "folder", key, "error", err)
+		}
+	}
+
+	// The index was reloaded or evicted meanwhile: the graph follows a stale
+	// copy, so let the next search build a new one.
+	current := s.indexes.current(key, idx)
+
+	s.annMu.Lock()
+	if current {
+		s.anns[key] = graph
+	} else {
+		delete(s.anns, key)
+	}
+	s.annMu.Unlock()
+
+	s.log(ctx, "ann graph ready", "folder", key, "images", graph.Len(), "added", added, "elapsed time", time.Since(start))
+}
+
+// saveANN persists the ANN graph of a folder, if it has one and no other
+// process is indexing the folder.
+func (s *Service) saveANN(ctx context.Context, key string) {
+	s.annMu.Lock()
+	graph := s.anns[key]
+	s.annMu.Unlock()
+
+	if graph == nil {
+		return
+	}
+	if _, locked := s.lockedByOther(key); locked {
+		return
+	}
+	compactANN(graph)
+	annPath, err := s.annPath(key)
+	if err == nil {
+		err = graph.Save(annPath)
+	}
+	if err != nil {
+		s.log(ctx, "save ann graph error", "folder", key, "error", err)
+	}
+}
+
+// compactANN compacts graph if it is over annMaxTombstones, and reports
+// whether it did.
+func compactANN(graph *search.HNSW) bool {
+	if graph.Tombstones() <= annMaxTombstones {
+		return false
+	}
+	graph.Compact()
+	return true
+}
+
+// annPath returns the ANN graph file path of a folder, next to its index.
+func (s *Service) annPath(folderPath string) (string, error) {
+	indexPath, err := s.indexPath(folderPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(indexPath), annFileName), nil
+}
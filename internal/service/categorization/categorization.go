@@ -148,6 +148,12 @@ var expressionsSchema = model.D{
 
 // Categorize turns a prose description into search-oriented expressions.
 func (c *Categorizer) Categorize(ctx context.Context, description string) (CategorizeResult, error) {
+	return c.CategorizeWith(ctx, description, config.PromptVars{})
+}
+
+// CategorizeWith is Categorize with the values of the described image the
+// prompt templates are rendered with, such as its folder context.
+func (c *Categorizer) CategorizeWith(ctx context.Context, description string, vars config.PromptVars) (CategorizeResult, error) {
 	c.mu.Lock()
 	krn := c.krn
 	c.mu.Unlock()
@@ -162,9 +168,14 @@ func (c *Categorizer) Categorize(ctx context.Context, description string) (Categ
 
 	p := c.prompt
 
+	systemPrompt, userPrompt, err := config.RenderPrompts(p.SystemPrompt, p.UserPrompt, p.ContextPrompt, vars)
+	if err != nil {
+		return CategorizeResult{}, err
+	}
+
 	messages := []model.D{
-		model.TextMessage(model.RoleSystem, p.SystemPrompt),
-		model.TextMessage(model.RoleUser, p.UserPrompt+"\n\n"+description),
+		model.TextMessage(model.RoleSystem, systemPrompt),
+		model.TextMessage(model.RoleUser, userPrompt+"\n\n"+description),
 	}
 
 	data := model.D{
@@ -0,0 +1,154 @@
+package description
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// AnimationMode is how the sampled frames of an animation are described.
+type AnimationMode string
+
+const (
+	// AnimationSheet describes the frames together, laid out on one contact
+	// sheet image, in place of the first frame.
+	AnimationSheet AnimationMode = "sheet"
+	// AnimationFrames describes the first frame like a still image and the
+	// others on their own with DescribeFrames.
+	AnimationFrames AnimationMode = "frames"
+)
+
+// ParseAnimationMode converts a config string to an AnimationMode. Empty
+// means AnimationSheet.
+func ParseAnimationMode(s string) (AnimationMode, error) {
+	switch AnimationMode(s) {
+	case "", AnimationSheet:
+		return AnimationSheet, nil
+	case AnimationFrames:
+		return AnimationFrames, nil
+	}
+	return "", fmt.Errorf("unknown animation mode %q: want %q or %q", s, AnimationSheet, AnimationFrames)
+}
+
+// Animation sets how animated GIF and WebP images are described. Frames
+// below 2 describes them by their first frame, like still images.
+type Animation struct {
+	Frames   int
+	Sampling image.Sampling
+	Mode     AnimationMode
+}
+
+// Frame is the description of one frame of an animation.
+type Frame struct {
+	// Index is the position of the frame in the animation, from 0, and
+	// Total the number of frames it has.
+	Index, Total int
+	DescribeResult
+}
+
+// open decodes the image to describe as the overview of imagePath: the
+// contact sheet of its sampled frames for animations in AnimationSheet mode,
+// the image itself otherwise. It also returns the image itself, the first
+// frame for animations, and reports whether it made a contact sheet.
+func (d *Describer) open(imagePath string) (overview, first *image.Source, sheet bool, err error) {
+	if d.animation.Mode != AnimationSheet || d.animation.Frames < 2 {
+		src, err := image.Open(imagePath)
+		return src, src, false, err
+	}
+
+	frames, _, err := image.SampleFrames(imagePath, d.animation.Frames, d.animation.Sampling)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(frames) == 1 {
+		return frames[0].Source, frames[0].Source, false, nil
+	}
+	return image.ContactSheet(frames, d.background), frames[0].Source, true, nil
+}
+
+// DescribeFrames describes the sampled frames of an animated image after the
+// first one, each on its own like a still image, in AnimationFrames mode.
+// It returns nil for still images and in other modes.
+//
+// Frames whose description fails are left out; only a cancelled ctx fails
+// the call. The first frame is described by DescribeWith.
+func (d *Describer) DescribeFrames(ctx context.Context, imagePath string, settings Settings) ([]Frame, error) {
+	if d.animation.Mode != AnimationFrames || d.animation.Frames < 2 {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	krn := d.krn
+	d.mu.Unlock()
+
+	if krn == nil {
+		return nil, ErrModelNotLoaded
+	}
+
+	sampled, total, err := image.SampleFrames(imagePath, d.animation.Frames, d.animation.Sampling)
+	if err != nil {
+		return nil, imageError("sample frames", err)
+	}
+	if len(sampled) < 2 {
+		return nil, nil
+	}
+
+	systemPrompt, userPrompt, err := config.RenderPrompts(settings.Prompt.SystemPrompt, settings.Prompt.UserPrompt, settings.Prompt.ContextPrompt, settings.Vars)
+	if err != nil {
+		return nil, err
+	}
+
+	d.log(ctx, "describe frames", "frames", total, "sampled", len(sampled))
+
+	var frames []Frame
+	for _, f := range sampled[1:] {
+		imageData, err := f.Encode(d.imageOptions(settings.MaxSide))
+		if err != nil {
+			return nil, fmt.Errorf("resize frame: %w", err)
+		}
+
+		result, err := d.describe(ctx, krn, imageData, systemPrompt, userPrompt, settings.Prompt)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			d.log(ctx, "describe frame error", "frame", f.Index, "error", err)
+			continue
+		}
+		frames = append(frames, Frame{Index: f.Index, Total: total, DescribeResult: result})
+	}
+
+	return frames, nil
+}
+
+// MergeFrames appends the frame descriptions to the description of the
+// first frame of an animation, each labeled with its position, into the one
+// description the image is indexed with.
+func MergeFrames(first string, frames []Frame) string {
+	if len(frames) == 0 {
+		return first
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(first))
+	b.WriteString("\n\nLater frames:")
+	for _, f := range frames {
+		fmt.Fprintf(&b, "\n- frame %d of %d: %s", f.Index+1, f.Total, strings.TrimSpace(f.Description))
+	}
+	return b.String()
+}
+
+// withSheetIntro puts the rendered sheet prompt ahead of the user prompt.
+func withSheetIntro(p config.VisionPrompt, vars config.PromptVars, userPrompt string) (string, error) {
+	intro, err := config.RenderPrompt(p.SheetPrompt, vars)
+	if err != nil {
+		return "", fmt.Errorf("sheet prompt: %w", err)
+	}
+	if intro = strings.TrimSpace(intro); intro == "" {
+		return userPrompt, nil
+	}
+	return intro + "\n\n" + userPrompt, nil
+}
@@ -0,0 +1,39 @@
+package description
+
+import "testing"
+
+func TestParseAnimationMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AnimationMode
+		wantErr bool
+	}{
+		{"", AnimationSheet, false},
+		{"sheet", AnimationSheet, false},
+		{"frames", AnimationFrames, false},
+		{"video", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAnimationMode(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAnimationMode(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestMergeFrames(t *testing.T) {
+	if got := MergeFrames("A cat.", nil); got != "A cat." {
+		t.Errorf("expected the first frame alone, got %q", got)
+	}
+
+	frames := []Frame{
+		{Index: 3, Total: 12, DescribeResult: DescribeResult{Description: " The cat jumps. "}},
+		{Index: 9, Total: 12, DescribeResult: DescribeResult{Description: "The cat lands."}},
+	}
+	got := MergeFrames("A cat.", frames)
+	want := "A cat.\n\nLater frames:\n- frame 4 of 12: The cat jumps.\n- frame 10 of 12: The cat lands."
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image/color"
 	"sync"
 	"time"
 
@@ -18,6 +19,14 @@ import (
 
 var (
 	ErrModelNotLoaded = errors.New("vision model not loaded")
+
+	// ErrUnreadableImage is returned when the image file can't be read or
+	// decoded, so no model settings will get a description out of it.
+	ErrUnreadableImage = errors.New("unreadable image")
+
+	// ErrEmptyDescription is returned when the model answered with no
+	// description at all.
+	ErrEmptyDescription = errors.New("empty description")
 )
 
 // DescribeResult holds the output of a Describe call.
@@ -25,6 +34,23 @@ type DescribeResult struct {
 	Description        string
 	TimeToFirstTokenMS float64
 	TokensPerSecond    float64
+	OutputTokens       int
+	// Truncated is set when generation stopped at the MaxTokens limit
+	// rather than at the end of the description.
+	Truncated bool
+	// Image is the image DescribeWith decoded, turned upright: the first
+	// frame of an animation. Callers can encode it again, for example as a
+	// thumbnail, without decoding the file a second time.
+	Image *image.Source
+}
+
+// Settings are the per-call parameters of Describe: how far the image is
+// downscaled, the prompt and sampling parameters it is described with, and
+// the values of the image the prompt templates are rendered with.
+type Settings struct {
+	MaxSide int
+	Prompt  config.VisionPrompt
+	Vars    config.PromptVars
 }
 
 // Describer manages the vision model for image description.
@@ -35,6 +61,12 @@ type Describer struct {
 	prompt  config.VisionPrompt
 	maxSide int
 
+	background  color.Color
+	jpegQuality int
+
+	tiling    config.TilingConfig
+	animation Animation
+
 	mu  sync.Mutex
 	krn *kronk.Kronk
 }
@@ -46,6 +78,16 @@ type Config struct {
 	Vision  config.VisionModelConfig
 	Prompt  config.VisionPrompt
 	MaxSide int
+
+	// Background and JPEGQuality set how images are flattened and
+	// re-encoded for the model. See image.Options.
+	Background  color.Color
+	JPEGQuality int
+
+	// Tiling sets which images DescribeTiles splits into tiles.
+	Tiling config.TilingConfig
+	// Animation sets how animated images are described.
+	Animation Animation
 }
 
 // New creates a Describer with the given configuration.
@@ -56,6 +98,12 @@ func New(cfg Config) *Describer {
 		vision:  cfg.Vision,
 		prompt:  cfg.Prompt,
 		maxSide: cfg.MaxSide,
+
+		background:  cfg.Background,
+		jpegQuality: cfg.JPEGQuality,
+
+		tiling:    cfg.Tiling,
+		animation: cfg.Animation,
 	}
 }
 
@@ -118,8 +166,19 @@ func (d *Describer) IsLoaded() bool { // TODO: Add an idle timer to the models.
 	return d.krn != nil
 }
 
+// Settings returns the configured settings Describe uses.
+func (d *Describer) Settings() Settings {
+	return Settings{MaxSide: d.maxSide, Prompt: d.prompt}
+}
+
 // Describe generates a text description of the image at the given path.
 func (d *Describer) Describe(ctx context.Context, imagePath string) (DescribeResult, error) {
+	return d.DescribeWith(ctx, imagePath, d.Settings())
+}
+
+// DescribeWith is Describe with settings other than the configured ones, for
+// example degraded ones to retry an image that failed.
+func (d *Describer) DescribeWith(ctx context.Context, imagePath string, settings Settings) (DescribeResult, error) {
 	d.mu.Lock()
 	krn := d.krn
 	d.mu.Unlock()
@@ -128,19 +187,59 @@ func (d *Describer) Describe(ctx context.Context, imagePath string) (DescribeRes
 		return DescribeResult{}, ErrModelNotLoaded
 	}
 
-	p := d.prompt
-	maxSide := d.maxSide
+	d.log(ctx, "describe image", "resize to", settings.MaxSide)
 
-	d.log(ctx, "describe image", "resize to", maxSide)
+	src, first, sheet, err := d.open(imagePath)
+	if err != nil {
+		return DescribeResult{}, imageError("resize image", err)
+	}
+	imageData, err := src.Encode(d.imageOptions(settings.MaxSide))
+	if err != nil {
+		return DescribeResult{}, imageError("resize image", err)
+	}
 
-	imageData, err := image.Resize(imagePath, maxSide)
+	systemPrompt, userPrompt, err := config.RenderPrompts(settings.Prompt.SystemPrompt, settings.Prompt.UserPrompt, settings.Prompt.ContextPrompt, settings.Vars)
 	if err != nil {
-		return DescribeResult{}, fmt.Errorf("resize image: %w", err)
+		return DescribeResult{}, err
+	}
+	if sheet {
+		if userPrompt, err = withSheetIntro(settings.Prompt, settings.Vars, userPrompt); err != nil {
+			return DescribeResult{}, err
+		}
+	}
+
+	result, err := d.describe(ctx, krn, imageData, systemPrompt, userPrompt, settings.Prompt)
+	if err != nil {
+		return DescribeResult{}, err
 	}
+	result.Image = first
+	return result, nil
+}
+
+// imageError wraps an error opening or encoding an image, by op, in
+// ErrUnreadableImage, unless it may read fine on another try: the file
+// failed to read, the decoders were busy or the decode ran past its timeout.
+func imageError(op string, err error) error {
+	if image.IsIOError(err) || errors.Is(err, image.ErrBusy) || errors.Is(err, image.ErrDecodeTimeout) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return fmt.Errorf("%s: %w: %w", op, ErrUnreadableImage, err)
+}
+
+func (d *Describer) imageOptions(maxSide int) image.Options {
+	return image.Options{
+		MaxSide:    maxSide,
+		Background: d.background,
+		Quality:    d.jpegQuality,
+	}
+}
 
+// describe runs the vision model on one JPEG image with rendered prompts and
+// the sampling parameters of p.
+func (d *Describer) describe(ctx context.Context, krn *kronk.Kronk, imageData []byte, systemPrompt, userPrompt string, p config.VisionPrompt) (DescribeResult, error) {
 	messages := []model.D{}
-	messages = append(messages, model.TextMessage(model.RoleSystem, p.SystemPrompt))
-	messages = append(messages, model.ImageMessage(p.UserPrompt, imageData, "jpg")...)
+	messages = append(messages, model.TextMessage(model.RoleSystem, systemPrompt))
+	messages = append(messages, model.ImageMessage(userPrompt, imageData, "jpg")...)
 
 	data := model.D{
 		"messages":    messages,
@@ -187,10 +286,14 @@ func (d *Describer) Describe(ctx context.Context, imagePath string) (DescribeRes
 		return DescribeResult{}, err
 	}
 
+	// The SDK reports "stop" even when generation hits max_tokens, so
+	// truncation is told by the token count.
 	result := DescribeResult{
 		Description:        choice.Message.Content,
 		TimeToFirstTokenMS: resp.Usage.TimeToFirstTokenMS,
 		TokensPerSecond:    resp.Usage.TokensPerSecond,
+		OutputTokens:       resp.Usage.OutputTokens,
+		Truncated:          p.MaxTokens > 0 && resp.Usage.OutputTokens >= p.MaxTokens,
 	}
 
 	d.log(ctx, "describe image", "elapsed time", time.Since(start), "description", result.Description)
@@ -214,9 +317,9 @@ func validateChatResponse(resp model.ChatResponse) (model.Choice, error) {
 		}
 		return model.Choice{}, fmt.Errorf("describe: model error: %s", errMsg)
 	case choice.Message == nil:
-		return model.Choice{}, fmt.Errorf("chat: empty message")
+		return model.Choice{}, fmt.Errorf("chat: %w: no message", ErrEmptyDescription)
 	case choice.Message.Content == "":
-		return model.Choice{}, fmt.Errorf("chat: blank message")
+		return model.Choice{}, fmt.Errorf("chat: %w: blank message", ErrEmptyDescription)
 	case resp.Usage == nil:
 		return model.Choice{}, fmt.Errorf("chat: empty usage")
 	}
@@ -2,6 +2,8 @@ package description_test
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/ramon-reichert/locallens/internal/platform/logger"
@@ -38,3 +40,85 @@ func TestDescriber_UnloadWithoutLoad(t *testing.T) {
 		t.Errorf("unload without load should not error: %v", err)
 	}
 }
+
+func TestRepetitive(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{"empty", "", false},
+		{"short", "a dog", false},
+		{"normal", "A brown dog runs across a green field. Behind the dog, a red barn stands under a cloudy sky, and a wooden fence runs along the field.", false},
+		{"phrase loop", "A sign that reads open. The sign that reads open. The sign that reads open. The sign that reads open. The sign that reads open.", true},
+		{"word loop", strings.Repeat("text text ", 30), true},
+	}
+	for _, tt := range tests {
+		if got := description.Repetitive(tt.text); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	a := description.NewAnalyzer(description.AnalyzerConfig{MinTokens: 10, Language: "en"})
+	good := "A brown dog runs across a green field. Behind the dog, a red barn stands under a cloudy sky."
+
+	tests := []struct {
+		name   string
+		result description.DescribeResult
+		want   []description.Issue
+	}{
+		{"good", description.DescribeResult{Description: good, OutputTokens: 25}, nil},
+		{"truncated", description.DescribeResult{Description: good, OutputTokens: 300, Truncated: true}, []description.Issue{description.IssueTruncated}},
+		{"too short", description.DescribeResult{Description: "A dog.", OutputTokens: 3}, []description.Issue{description.IssueTooShort}},
+		{"refusal", description.DescribeResult{Description: "I'm sorry, but I can't help with identifying people in images.", OutputTokens: 15}, []description.Issue{description.IssueRefusal}},
+		{"refusal after preamble", description.DescribeResult{Description: "The image is dark. I cannot make out any details in it at all.", OutputTokens: 15}, []description.Issue{description.IssueRefusal}},
+		{"other language", description.DescribeResult{Description: "这张图片显示了一只棕色的狗在绿色的田野上奔跑，背景是一座红色的谷仓。", OutputTokens: 30}, []description.Issue{description.IssueLanguage}},
+		{"loop", description.DescribeResult{Description: strings.Repeat("a red sign ", 20), OutputTokens: 60}, []description.Issue{description.IssueRepetition}},
+		{"blank", description.DescribeResult{Description: " \n ", OutputTokens: 2}, []description.Issue{description.IssueEmpty}},
+	}
+	for _, tt := range tests {
+		q := a.Analyze(tt.result)
+		if fmt.Sprint(q.Issues) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: issues = %v, want %v", tt.name, q.Issues, tt.want)
+		}
+		if len(tt.want) == 0 && q.Score != 1 {
+			t.Errorf("%s: score = %v, want 1", tt.name, q.Score)
+		}
+		if len(tt.want) > 0 && q.Score >= 1 {
+			t.Errorf("%s: score = %v, want below 1", tt.name, q.Score)
+		}
+	}
+
+	if q := a.Analyze(tests[5].result); q.Unusable() {
+		t.Error("a description in another language should be flagged, not unusable")
+	}
+	if q := a.Analyze(tests[3].result); !q.Unusable() {
+		t.Error("a refusal should be unusable")
+	}
+	if q := a.Analyze(tests[7].result); !q.Unusable() {
+		t.Error("a blank description should be unusable")
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"A brown dog runs across the field and a red barn stands in the background with a cloudy sky.", "en", true},
+		{"Um cachorro marrom corre pelo campo e um celeiro vermelho está no fundo, com o céu nublado.", "pt", true},
+		{"Un perro marrón corre por el campo y un granero rojo está en el fondo con el cielo nublado.", "es", true},
+		{"一只棕色的狗在田野上奔跑。", "zh", true},
+		{"茶色の犬が野原を走っています。", "ja", true},
+		{"Dog. Field.", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := description.DetectLanguage(tt.text)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%q: got %q, %v; want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package description
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Issue is a problem found in a description.
+type Issue string
+
+const (
+	IssueEmpty      Issue = "empty"      // nothing but whitespace
+	IssueRepetition Issue = "repetition" // the model looped on a phrase
+	IssueRefusal    Issue = "refusal"    // boilerplate instead of a description
+	IssueTruncated  Issue = "truncated"  // generation stopped at MaxTokens
+	IssueTooShort   Issue = "too_short"  // fewer tokens than a description needs
+	IssueLanguage   Issue = "language"   // not in the expected language
+)
+
+// issuePenalty is the factor each issue multiplies the quality score by. A
+// blank, repetitive or refused description describes nothing and scores 0.
+var issuePenalty = map[Issue]float64{
+	IssueEmpty:      0,
+	IssueRepetition: 0,
+	IssueRefusal:    0,
+	IssueTruncated:  0.7,
+	IssueTooShort:   0.5,
+	IssueLanguage:   0.5,
+}
+
+// refusalPatterns are openings small vision models use instead of a
+// description. They are matched at the start of the lowercased text.
+var refusalPatterns = []string{
+	"i'm sorry",
+	"i am sorry",
+	"sorry, i",
+	"i can't",
+	"i cannot",
+	"i can not",
+	"i'm unable",
+	"i am unable",
+	"i'm not able",
+	"i am not able",
+	"as an ai",
+	"unfortunately, i",
+}
+
+// refusalWindow is how much of the start of a description is searched for
+// refusal patterns, so a refusal after a short preamble is still caught.
+const refusalWindow = 80
+
+// Quality is the assessment of a description.
+type Quality struct {
+	// Score goes from 1, no issue found, down to 0, unusable.
+	Score  float64
+	Issues []Issue
+}
+
+// Unusable reports whether the description should not be indexed at all.
+func (q Quality) Unusable() bool {
+	return q.Score == 0
+}
+
+// AnalyzerConfig holds the thresholds of an Analyzer.
+type AnalyzerConfig struct {
+	// MinTokens is the shortest description not flagged as too short.
+	MinTokens int
+	// Language is the ISO 639-1 code descriptions are expected in. Empty
+	// disables the language check.
+	Language string
+}
+
+// Analyzer scores descriptions for the ways small vision models fail:
+// looping, refusing, stopping at the token limit, stopping early, or
+// drifting to another language.
+type Analyzer struct {
+	minTokens int
+	language  string
+}
+
+// NewAnalyzer creates an Analyzer with the given configuration.
+func NewAnalyzer(cfg AnalyzerConfig) *Analyzer {
+	return &Analyzer{minTokens: cfg.MinTokens, language: strings.ToLower(cfg.Language)}
+}
+
+// Analyze returns the quality of a description.
+func (a *Analyzer) Analyze(r DescribeResult) Quality {
+	if strings.TrimSpace(r.Description) == "" {
+		return Quality{Score: issuePenalty[IssueEmpty], Issues: []Issue{IssueEmpty}}
+	}
+
+	var issues []Issue
+
+	if Repetitive(r.Description) {
+		issues = append(issues, IssueRepetition)
+	}
+	if refusal(r.Description) {
+		issues = append(issues, IssueRefusal)
+	}
+	if r.Truncated {
+		issues = append(issues, IssueTruncated)
+	}
+
+	// Word count stands in when the model reported no token usage.
+	tokens := r.OutputTokens
+	if tokens == 0 {
+		tokens = len(strings.Fields(r.Description))
+	}
+	if tokens < a.minTokens {
+		issues = append(issues, IssueTooShort)
+	}
+
+	if a.language != "" {
+		if lang, ok := DetectLanguage(r.Description); ok && lang != a.language {
+			issues = append(issues, IssueLanguage)
+		}
+	}
+
+	score := 1.0
+	for _, issue := range issues {
+		score *= issuePenalty[issue]
+	}
+	return Quality{Score: score, Issues: issues}
+}
+
+// refusal reports whether text opens with a refusal or apology instead of a
+// description.
+func refusal(text string) bool {
+	start := strings.ToLower(strings.TrimSpace(text))
+	if len(start) > refusalWindow {
+		start = start[:refusalWindow]
+	}
+	start = strings.ReplaceAll(start, "’", "'")
+
+	for _, p := range refusalPatterns {
+		if strings.HasPrefix(start, p) {
+			return true
+		}
+		// "This image shows... I'm sorry, I can't identify..."
+		if strings.Contains(start, ". "+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptLanguages maps scripts that are specific enough to name a language
+// on their own.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Thai, "th"},
+}
+
+// stopwords are frequent function words of languages written in the Latin
+// script, which tell them apart.
+var stopwords = map[string]map[string]bool{
+	"en": set("the", "and", "of", "is", "in", "with", "on", "are", "a", "an", "this", "its", "to"),
+	"pt": set("o", "os", "as", "do", "da", "dos", "das", "em", "um", "uma", "com", "é", "e", "que", "no", "na"),
+	"es": set("el", "los", "las", "del", "en", "un", "una", "con", "es", "y", "que", "se", "al"),
+	"fr": set("le", "les", "des", "du", "et", "un", "une", "est", "avec", "dans", "sur", "au"),
+	"de": set("der", "die", "das", "und", "ist", "mit", "ein", "eine", "im", "den", "auf", "von"),
+	"it": set("il", "lo", "gli", "di", "del", "della", "e", "un", "una", "è", "con", "nel", "sono"),
+}
+
+// languageMinHits is how many stopwords a text needs before its language is
+// guessed from them.
+const languageMinHits = 4
+
+// DetectLanguage guesses the ISO 639-1 code of the language text is written
+// in. It only reports languages it is fairly sure of: a dominant non-Latin
+// script, or clearly more stopwords of one Latin-script language than of any
+// other.
+func DetectLanguage(text string) (string, bool) {
+	var letters int
+	scripts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scripts[s.lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return "", false
+	}
+
+	// Japanese mixes kana and Han, so kana anywhere settles it.
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/3 {
+		return "ja", true
+	}
+	for lang, n := range scripts {
+		if n > letters/3 {
+			return lang, true
+		}
+	}
+
+	hits := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for lang, words := range stopwords {
+			if words[w] {
+				hits[lang]++
+			}
+		}
+	}
+
+	best, bestHits, second := "", 0, 0
+	for lang, n := range hits {
+		switch {
+		case n > bestHits:
+			best, bestHits, second = lang, n, bestHits
+		case n > second:
+			second = n
+		}
+	}
+	if bestHits < languageMinHits || float64(bestHits) < 1.5*float64(second) {
+		return "", false
+	}
+	return best, true
+}
+
+func set(words ...string) map[string]bool {
+	m := make(map[string]bool, len(words))
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
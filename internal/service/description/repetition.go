@@ -0,0 +1,40 @@
+package description
+
+import (
+	"strings"
+)
+
+const (
+	// repetitionNGram is the length, in words, of the phrases compared.
+	repetitionNGram = 3
+
+	// repetitionMaxCount is how often one phrase may occur before the text
+	// counts as looping. Natural descriptions hardly repeat a three-word
+	// phrase more than a couple of times.
+	repetitionMaxCount = 5
+
+	// repetitionMinDistinct is the lowest share of distinct phrases in a
+	// text long enough to judge.
+	repetitionMinDistinct = 0.5
+)
+
+// Repetitive reports whether text looks like the output of a model stuck in
+// a loop: one phrase repeated over and over, or few distinct phrases overall.
+func Repetitive(text string) bool {
+	words := strings.Fields(strings.ToLower(text))
+	n := len(words) - repetitionNGram + 1
+	if n <= 0 {
+		return false
+	}
+
+	counts := make(map[string]int, n)
+	for i := range n {
+		phrase := strings.Join(words[i:i+repetitionNGram], " ")
+		counts[phrase]++
+		if counts[phrase] >= repetitionMaxCount {
+			return true
+		}
+	}
+
+	return n >= 4*repetitionMaxCount && float64(len(counts))/float64(n) < repetitionMinDistinct
+}
@@ -0,0 +1,175 @@
+package description
+
+import (
+	"context"
+	"fmt"
+	goimage "image"
+	"math"
+	"strings"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// maxOverlap caps TilingConfig.Overlap: past half a tile, tiles would cover
+// their neighbors entirely.
+const maxOverlap = 0.5
+
+// Tile is the description of one region of a tiled image.
+type Tile struct {
+	// Region is the part of the image described, in pixels of the upright
+	// image, whose size is ImageSize.
+	Region    goimage.Rectangle
+	ImageSize goimage.Point
+	DescribeResult
+}
+
+// Position names where the tile lies in the image, such as "top left" or
+// "center", by the third of each side its center falls in.
+func (t Tile) Position() string {
+	if t.ImageSize.X <= 0 || t.ImageSize.Y <= 0 {
+		return "center"
+	}
+	c := t.Region.Min.Add(t.Region.Max).Div(2)
+	row := [...]string{"top", "", "bottom"}[min(3*c.Y/t.ImageSize.Y, 2)]
+	col := [...]string{"left", "", "right"}[min(3*c.X/t.ImageSize.X, 2)]
+
+	if pos := strings.TrimSpace(row + " " + col); pos != "" {
+		return pos
+	}
+	return "center"
+}
+
+// DescribeTiles describes the overlapping tiles of an image too elongated or
+// too large to be read whole at settings.MaxSide, each at settings.MaxSide,
+// with the tile prompt ahead of the user prompt. It returns nil for images
+// that don't need tiling, and always when tiling is disabled.
+//
+// Tiles whose description fails are left out; only a cancelled ctx fails
+// the call. The overview of the image is still described by DescribeWith.
+func (d *Describer) DescribeTiles(ctx context.Context, imagePath string, settings Settings) ([]Tile, error) {
+	if !d.tiling.Enabled {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	krn := d.krn
+	d.mu.Unlock()
+
+	if krn == nil {
+		return nil, ErrModelNotLoaded
+	}
+
+	src, err := image.Open(imagePath)
+	if err != nil {
+		return nil, imageError("resize image", err)
+	}
+	size := src.Size()
+	regions := planTiles(size, settings.MaxSide, d.tiling)
+	if len(regions) == 0 {
+		return nil, nil
+	}
+
+	systemPrompt, userPrompt, err := config.RenderPrompts(settings.Prompt.SystemPrompt, settings.Prompt.UserPrompt, settings.Prompt.ContextPrompt, settings.Vars)
+	if err != nil {
+		return nil, err
+	}
+	tileIntro, err := config.RenderPrompt(settings.Prompt.TilePrompt, settings.Vars)
+	if err != nil {
+		return nil, fmt.Errorf("tile prompt: %w", err)
+	}
+	if tileIntro = strings.TrimSpace(tileIntro); tileIntro != "" {
+		userPrompt = tileIntro + "\n\n" + userPrompt
+	}
+
+	d.log(ctx, "describe tiles", "image size", size, "tiles", len(regions))
+
+	var tiles []Tile
+	for _, r := range regions {
+		imageData, err := src.EncodeRegion(r, d.imageOptions(settings.MaxSide))
+		if err != nil {
+			return nil, fmt.Errorf("resize tile: %w", err)
+		}
+
+		result, err := d.describe(ctx, krn, imageData, systemPrompt, userPrompt, settings.Prompt)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			d.log(ctx, "describe tile error", "region", r, "error", err)
+			continue
+		}
+		tiles = append(tiles, Tile{Region: r, ImageSize: size, DescribeResult: result})
+	}
+
+	return tiles, nil
+}
+
+// planTiles returns the overlapping regions an image of the given size is
+// described in, or nil if it doesn't need tiling: images that fit in maxSide
+// lose no detail, and others are only tiled from cfg.MinAspect or
+// cfg.MinSide on.
+//
+// Tiles form a grid of cells about cfg.TileSide wide, grown until there are
+// at most cfg.MaxTiles of them, and then extended by half the overlap on
+// every side, within the image.
+func planTiles(size goimage.Point, maxSide int, cfg config.TilingConfig) []goimage.Rectangle {
+	long, short := max(size.X, size.Y), min(size.X, size.Y)
+	if short <= 0 || long <= maxSide || cfg.MaxTiles < 2 {
+		return nil
+	}
+
+	elongated := cfg.MinAspect > 0 && float64(long)/float64(short) >= cfg.MinAspect
+	large := cfg.MinSide > 0 && long >= cfg.MinSide
+	if !elongated && !large {
+		return nil
+	}
+
+	side := cfg.TileSide
+	if side <= 0 || side > short {
+		side = short
+	}
+	cols, rows := ceilDiv(size.X, side), ceilDiv(size.Y, side)
+	for cols*rows > cfg.MaxTiles {
+		side += max(side/10, 1)
+		cols, rows = ceilDiv(size.X, side), ceilDiv(size.Y, side)
+	}
+	if cols*rows < 2 {
+		return nil
+	}
+
+	cellW, cellH := ceilDiv(size.X, cols), ceilDiv(size.Y, rows)
+	overlap := math.Min(math.Max(cfg.Overlap, 0), maxOverlap)
+	padX, padY := int(overlap*float64(cellW)/2), int(overlap*float64(cellH)/2)
+	bounds := goimage.Rectangle{Max: size}
+
+	regions := make([]goimage.Rectangle, 0, cols*rows)
+	for row := range rows {
+		for col := range cols {
+			r := goimage.Rect(col*cellW-padX, row*cellH-padY, (col+1)*cellW+padX, (row+1)*cellH+padY)
+			regions = append(regions, r.Intersect(bounds))
+		}
+	}
+	return regions
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// MergeTiles appends the tile descriptions to the overview description of a
+// tiled image, each labeled with its position, into the one description the
+// image is indexed with.
+func MergeTiles(overview string, tiles []Tile) string {
+	if len(tiles) == 0 {
+		return overview
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(overview))
+	b.WriteString("\n\nDetails:")
+	for _, t := range tiles {
+		fmt.Fprintf(&b, "\n- %s: %s", t.Position(), strings.TrimSpace(t.Description))
+	}
+	return b.String()
+}
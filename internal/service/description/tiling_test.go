@@ -0,0 +1,107 @@
+package description
+
+import (
+	goimage "image"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+)
+
+var testTiling = config.TilingConfig{
+	Enabled:   true,
+	MinAspect: 2.5,
+	MinSide:   4000,
+	TileSide:  1536,
+	Overlap:   0.15,
+	MaxTiles:  6,
+}
+
+func TestPlanTiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       goimage.Point
+		cols, rows int
+	}{
+		{"regular photo", goimage.Pt(4000, 3000), 3, 2},
+		{"small photo", goimage.Pt(1600, 1200), 0, 0},
+		{"fits max side", goimage.Pt(500, 100), 0, 0},
+		{"panorama", goimage.Pt(12000, 3000), 4, 1},
+		{"tall strip", goimage.Pt(800, 6000), 1, 6},
+		{"a4 scan", goimage.Pt(4960, 7016), 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions := planTiles(tt.size, 512, testTiling)
+			if len(regions) != tt.cols*tt.rows {
+				t.Fatalf("expected %d tiles, got %d: %v", tt.cols*tt.rows, len(regions), regions)
+			}
+			if len(regions) == 0 {
+				return
+			}
+
+			// Tiles stay inside the image, overlap their neighbors and
+			// cover it all.
+			bounds := goimage.Rectangle{Max: tt.size}
+			covered := goimage.Rectangle{}
+			for _, r := range regions {
+				if !r.In(bounds) {
+					t.Errorf("tile %v outside image %v", r, bounds)
+				}
+				covered = covered.Union(r)
+			}
+			if covered != bounds {
+				t.Errorf("tiles cover %v, want %v", covered, bounds)
+			}
+			if tt.cols > 1 && regions[0].Intersect(regions[1]).Empty() {
+				t.Errorf("expected neighbors %v and %v to overlap", regions[0], regions[1])
+			}
+		})
+	}
+}
+
+func TestPlanTiles_Disabled(t *testing.T) {
+	cfg := testTiling
+	cfg.MaxTiles = 1
+	if regions := planTiles(goimage.Pt(12000, 3000), 512, cfg); regions != nil {
+		t.Errorf("expected no tiles with MaxTiles 1, got %v", regions)
+	}
+}
+
+func TestTile_Position(t *testing.T) {
+	size := goimage.Pt(900, 900)
+	tests := []struct {
+		region goimage.Rectangle
+		want   string
+	}{
+		{goimage.Rect(0, 0, 300, 300), "top left"},
+		{goimage.Rect(300, 0, 600, 300), "top"},
+		{goimage.Rect(300, 300, 600, 600), "center"},
+		{goimage.Rect(600, 600, 900, 900), "bottom right"},
+		{goimage.Rect(0, 0, 300, 900), "left"},
+	}
+
+	for _, tt := range tests {
+		tile := Tile{Region: tt.region, ImageSize: size}
+		if got := tile.Position(); got != tt.want {
+			t.Errorf("Position of %v = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestMergeTiles(t *testing.T) {
+	if got := MergeTiles("A harbor.", nil); got != "A harbor." {
+		t.Errorf("expected the overview alone, got %q", got)
+	}
+
+	size := goimage.Pt(1200, 300)
+	tiles := []Tile{
+		{Region: goimage.Rect(0, 0, 400, 300), ImageSize: size, DescribeResult: DescribeResult{Description: " Boats at a pier. "}},
+		{Region: goimage.Rect(800, 0, 1200, 300), ImageSize: size, DescribeResult: DescribeResult{Description: "A lighthouse."}},
+	}
+	got := MergeTiles("A harbor.", tiles)
+	want := "A harbor.\n\nDetails:\n- left: Boats at a pier.\n- right: A lighthouse."
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
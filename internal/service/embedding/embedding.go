@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -16,8 +17,9 @@ import (
 )
 
 var (
-	ErrModelNotLoaded = errors.New("embedding model not loaded")
-	ErrEmptyText      = errors.New("empty text")
+	ErrModelNotLoaded    = errors.New("embedding model not loaded")
+	ErrEmptyText         = errors.New("empty text")
+	ErrDimensionMismatch = errors.New("embedding dimension mismatch")
 )
 
 // Kind identifies the retrieval role of text being embedded.
@@ -39,6 +41,9 @@ type Embedder struct {
 
 	mu  sync.Mutex
 	krn *kronk.Kronk
+
+	// queue serializes Embed calls, serving queries first.
+	queue queue
 }
 
 // Config holds configuration for creating an Embedder.
@@ -127,7 +132,26 @@ type EmbedResult struct {
 	Elapsed   time.Duration
 }
 
+// Prefix returns the task prompt prepended to text of kind before embedding.
+func Prefix(kind Kind) string {
+	if kind == Query {
+		return queryPrefix
+	}
+	return documentPrefix
+}
+
+// Stats returns the queueing metrics of Embed calls so far.
+func (e *Embedder) Stats() QueueStats {
+	return e.queue.snapshot()
+}
+
 // Embed converts text into a vector embedding using the prompt prefix for kind.
+// When an output dimension is configured, the embedding is truncated and
+// re-normalized to it.
+//
+// Calls are serialized on the model. A Query waits at most for the call in
+// flight: it is served before any Document already waiting, so searches stay
+// responsive while an indexing job embeds expressions.
 func (e *Embedder) Embed(ctx context.Context, kind Kind, text string) (EmbedResult, error) {
 	e.mu.Lock()
 	krn := e.krn
@@ -141,18 +165,19 @@ func (e *Embedder) Embed(ctx context.Context, kind Kind, text string) (EmbedResu
 		return EmbedResult{}, ErrEmptyText
 	}
 
-	input := documentPrefix + text
-	if kind == Query {
-		input = queryPrefix + text
-	}
-
 	data := model.D{
-		"input":    input,
+		"input":    Prefix(kind) + text,
 		"truncate": true,
 	}
 
+	wait, err := e.queue.acquire(ctx, kind)
+	if err != nil {
+		return EmbedResult{}, err
+	}
+
 	start := time.Now()
 	resp, err := krn.Embeddings(ctx, data)
+	e.queue.release()
 	if err != nil {
 		return EmbedResult{}, fmt.Errorf("embeddings: %w", err)
 	}
@@ -161,11 +186,45 @@ func (e *Embedder) Embed(ctx context.Context, kind Kind, text string) (EmbedResu
 		return EmbedResult{}, errors.New("no embedding data returned")
 	}
 
+	vec := resp.Data[0].Embedding
+	if dim := e.embed.Dimensions; dim > 0 {
+		if vec, err = Truncate(vec, dim); err != nil {
+			return EmbedResult{}, err
+		}
+	}
+
 	elapsed := time.Since(start)
-	e.log(ctx, "embed image", "elapsed time", elapsed)
+	e.log(ctx, "embed "+string(kind), "elapsed time", elapsed, "queue wait", wait)
 
 	return EmbedResult{
-		Embedding: resp.Data[0].Embedding,
+		Embedding: vec,
 		Elapsed:   elapsed,
 	}, nil
 }
+
+// Truncate keeps the first dim components of v and re-normalizes them to unit
+// length, the way Matryoshka embeddings are shortened. v is returned as is
+// when it already has dim components. A v shorter than dim can't be
+// lengthened and yields ErrDimensionMismatch.
+func Truncate(v []float32, dim int) ([]float32, error) {
+	switch {
+	case len(v) == dim:
+		return v, nil
+	case len(v) < dim:
+		return nil, fmt.Errorf("%w: have %d dimensions, want %d", ErrDimensionMismatch, len(v), dim)
+	}
+
+	var sum float64
+	for _, x := range v[:dim] {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, dim)
+	if sum == 0 {
+		return out, nil
+	}
+	norm := float32(math.Sqrt(sum))
+	for i, x := range v[:dim] {
+		out[i] = x / norm
+	}
+	return out, nil
+}
@@ -2,6 +2,8 @@ package embedding_test
 
 import (
 	"context"
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/ramon-reichert/locallens/internal/platform/logger"
@@ -38,3 +40,17 @@ func TestEmbedder_UnloadWithoutLoad(t *testing.T) {
 		t.Errorf("unload without load should not error: %v", err)
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	got, err := embedding.Truncate([]float32{3, 4, 12}, 2)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if len(got) != 2 || math.Abs(float64(got[0]-0.6)) > 1e-6 || math.Abs(float64(got[1]-0.8)) > 1e-6 {
+		t.Errorf("Truncate = %v, want [0.6 0.8]", got)
+	}
+
+	if _, err := embedding.Truncate([]float32{1, 0}, 4); !errors.Is(err, embedding.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch when lengthening, got %v", err)
+	}
+}
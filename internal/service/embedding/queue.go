@@ -0,0 +1,130 @@
+package embedding
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// KindStats summarizes how long Embed calls of one kind waited for the model.
+type KindStats struct {
+	Calls     int64
+	Waited    int64 // calls that found the model busy
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// QueueStats reports the state of the embedder queue.
+type QueueStats struct {
+	Waiting  int // calls currently queued
+	Query    KindStats
+	Document KindStats
+}
+
+// queue serializes access to the model. Waiting queries are served before
+// waiting documents, so an interactive search only waits for the embed in
+// flight, not for the backlog of an indexing job.
+type queue struct {
+	mu        sync.Mutex
+	busy      bool
+	queries   []chan struct{}
+	documents []chan struct{}
+	stats     QueueStats
+}
+
+// acquire blocks until the caller holds the model or ctx is done, and
+// returns how long it waited.
+func (q *queue) acquire(ctx context.Context, kind Kind) (time.Duration, error) {
+	start := time.Now()
+
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.record(kind, 0)
+		q.mu.Unlock()
+		return 0, nil
+	}
+
+	ready := make(chan struct{})
+	if kind == Query {
+		q.queries = append(q.queries, ready)
+	} else {
+		q.documents = append(q.documents, ready)
+	}
+	q.stats.Waiting++
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		wait := time.Since(start)
+		q.mu.Lock()
+		q.record(kind, wait)
+		q.mu.Unlock()
+		return wait, nil
+
+	case <-ctx.Done():
+		q.mu.Lock()
+		dropped := q.drop(ready)
+		if dropped {
+			q.stats.Waiting--
+		}
+		q.mu.Unlock()
+
+		// Handed the model just as ctx ended: pass it on.
+		if !dropped {
+			q.release()
+		}
+		return 0, ctx.Err()
+	}
+}
+
+// release hands the model to the next waiter, queries first.
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next chan struct{}
+	switch {
+	case len(q.queries) > 0:
+		next, q.queries = q.queries[0], q.queries[1:]
+	case len(q.documents) > 0:
+		next, q.documents = q.documents[0], q.documents[1:]
+	default:
+		q.busy = false
+		return
+	}
+	q.stats.Waiting--
+	close(next)
+}
+
+// drop removes ready from the wait lists, reporting whether it was there.
+func (q *queue) drop(ready chan struct{}) bool {
+	for _, list := range []*[]chan struct{}{&q.queries, &q.documents} {
+		for i, c := range *list {
+			if c == ready {
+				*list = append((*list)[:i], (*list)[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *queue) record(kind Kind, wait time.Duration) {
+	ks := &q.stats.Document
+	if kind == Query {
+		ks = &q.stats.Query
+	}
+	ks.Calls++
+	if wait > 0 {
+		ks.Waited++
+		ks.TotalWait += wait
+		ks.MaxWait = max(ks.MaxWait, wait)
+	}
+}
+
+func (q *queue) snapshot() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
@@ -0,0 +1,82 @@
+package embedding
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue_QueriesBeforeDocuments(t *testing.T) {
+	var q queue
+	ctx := context.Background()
+
+	if _, err := q.acquire(ctx, Document); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []Kind
+		wg    sync.WaitGroup
+	)
+	enqueue := func(kind Kind) {
+		wg.Go(func() {
+			if _, err := q.acquire(ctx, kind); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, kind)
+			mu.Unlock()
+			q.release()
+		})
+		// Wait until the call is queued so the arrival order is fixed.
+		for want := q.snapshot().Waiting + 1; q.snapshot().Waiting < want; {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	enqueue(Document)
+	enqueue(Document)
+	enqueue(Query)
+
+	q.release()
+	wg.Wait()
+
+	want := []Kind{Query, Document, Document}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("served in order %v, want %v", order, want)
+		}
+	}
+
+	stats := q.snapshot()
+	if stats.Query.Calls != 1 || stats.Query.Waited != 1 || stats.Document.Calls != 3 || stats.Document.Waited != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Waiting != 0 {
+		t.Errorf("expected an empty queue, %d still waiting", stats.Waiting)
+	}
+}
+
+func TestQueue_CancelledWaiterLeavesQueue(t *testing.T) {
+	var q queue
+	if _, err := q.acquire(context.Background(), Document); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.acquire(ctx, Query); err == nil {
+		t.Fatal("expected a context error while the model is busy")
+	}
+	if n := q.snapshot().Waiting; n != 0 {
+		t.Errorf("expected the cancelled call to leave the queue, %d waiting", n)
+	}
+
+	q.release()
+	if _, err := q.acquire(context.Background(), Query); err != nil {
+		t.Errorf("expected the model to be free again: %v", err)
+	}
+}
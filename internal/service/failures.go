@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/description"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+// Failure classes recorded in index.Failure.Class.
+const (
+	// FailureUnreadable: the file can't be decoded as an image.
+	FailureUnreadable = "unreadable"
+	// FailureIO: reading the file failed, as network shares and failing
+	// disks do (EIO, ESTALE). Retried: the file itself may be fine.
+	FailureIO = "io"
+	// FailureTooLarge: the file or its pixels are over the configured
+	// limits, checked before decoding.
+	FailureTooLarge = "too_large"
+	// FailureMalformed: decoding the image crashed, as crafted files do.
+	FailureMalformed = "malformed"
+	// FailureContextFull: the image didn't fit the vision model's context
+	// (KV cache full during prefill), even at the smallest ladder rung.
+	// Deterministic at the same settings.
+	FailureContextFull = "context_full"
+	// FailureTimeout: a model call ran past its per-image timeout, the
+	// image took over the decode timeout to decode, or no decoder freed up
+	// for it in time. Retried: the machine may just have been busy.
+	FailureTimeout = "timeout"
+	// FailureUnusable: every describe ladder rung produced a description
+	// not worth indexing, such as a loop or a refusal.
+	FailureUnusable = "unusable"
+
+	// Other errors are classed by the step that failed.
+	FailureDescribe   = "describe"
+	FailureCategorize = "categorize"
+	FailureEmbed      = "embed"
+)
+
+// maxBackoffDoublings caps the exponential backoff of retryPolicy.
+const maxBackoffDoublings = 10
+
+// classifyFailure returns the failure class of err, returned by step.
+func classifyFailure(step string, err error) string {
+	switch {
+	// Checked first: description wraps them in ErrUnreadableImage.
+	case errors.Is(err, image.ErrTooLarge):
+		return FailureTooLarge
+	case errors.Is(err, image.ErrMalformed):
+		return FailureMalformed
+	case errors.Is(err, image.ErrDecodeTimeout), errors.Is(err, image.ErrBusy), errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case image.IsIOError(err):
+		return FailureIO
+	case errors.Is(err, description.ErrUnreadableImage):
+		return FailureUnreadable
+	case errors.Is(err, ErrUnusableDescription):
+		return FailureUnusable
+	case contextFull(err):
+		return FailureContextFull
+	}
+	return step
+}
+
+// contextFull reports whether err is the vision model running out of
+// context. The SDK reports llama.cpp's "no KV slot" decode result only as
+// text.
+func contextFull(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "context window is full")
+}
+
+// retryPolicy decides when images that failed to index are tried again.
+type retryPolicy struct {
+	maxAttempts int           // 0 keeps retrying
+	backoff     time.Duration // before the first retry, doubled after each failure
+}
+
+// permanent reports whether failures of class would fail again the same way,
+// so the image is skipped right away.
+func (p retryPolicy) permanent(class string) bool {
+	switch class {
+	case FailureUnreadable, FailureTooLarge, FailureMalformed, FailureContextFull:
+		return true
+	}
+	return false
+}
+
+// fail returns the failure to record for an attempt at path that failed with
+// err, given the previous failure of the image, if any.
+func (p retryPolicy) fail(prev index.Failure, path, class string, err error, now time.Time) index.Failure {
+	f := index.Failure{
+		Path:        path,
+		Class:       class,
+		Error:       err.Error(),
+		Attempts:    prev.Attempts + 1,
+		LastAttempt: now,
+	}
+	if fi, err := os.Stat(path); err == nil {
+		f.FileModTime, f.FileSize = fi.ModTime(), fi.Size()
+	}
+
+	// A new version of the file starts over.
+	if !prev.FileModTime.Equal(f.FileModTime) || prev.FileSize != f.FileSize {
+		f.Attempts = 1
+	}
+
+	if p.permanent(class) || (p.maxAttempts > 0 && f.Attempts >= p.maxAttempts) {
+		f.Skipped = true
+		return f
+	}
+	f.NextRetry = now.Add(p.backoff << min(f.Attempts-1, maxBackoffDoublings))
+	return f
+}
+
+// due reports whether an image that failed before should be tried again: its
+// backoff has elapsed and it wasn't skipped, or the file changed since.
+func (p retryPolicy) due(f index.Failure, now time.Time) bool {
+	if fi, err := os.Stat(f.Path); err == nil && (!fi.ModTime().Equal(f.FileModTime) || fi.Size() != f.FileSize) {
+		return true
+	}
+	return !f.Skipped && !now.Before(f.NextRetry)
+}
+
+// retryDue reports whether imgPath may be indexed in this run: it never
+// failed, or its failure is due for a retry.
+func (s *Service) retryDue(idx *index.Index, imgPath string) bool {
+	f, failed := idx.Failure(imgPath)
+	return !failed || s.retry.due(f, time.Now())
+}
+
+// recordFailure persists the failure of step for imgPath in the folder index.
+// Failures caused by the run being cancelled aren't the image's fault and
+// aren't recorded.
+func (s *Service) recordFailure(ctx context.Context, idx *index.Index, imgPath, step string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	prev, _ := idx.Failure(imgPath)
+	f := s.retry.fail(prev, imgPath, classifyFailure(step, err), err, time.Now())
+	idx.SetFailure(f)
+	if err := idx.Save(); err != nil {
+		s.log(ctx, "save failure error", "path", imgPath, "error", err)
+		return
+	}
+
+	if f.Skipped {
+		s.log(ctx, "image skipped permanently", "path", imgPath, "class", f.Class, "attempts", f.Attempts)
+	} else {
+		s.log(ctx, "image will be retried", "path", imgPath, "class", f.Class, "attempts", f.Attempts, "next retry", f.NextRetry)
+	}
+}
+
+// RetryFailures forgets the failures recorded for paths in the index of a
+// folder, or for all of its images when paths is empty, so the next indexing
+// run tries them again, skipped ones included. It returns how many it
+// forgot. If another process is indexing the folder, RetryFailures fails
+// with an error matching index.ErrLocked.
+func (s *Service) RetryFailures(folderPath string, paths []string) (int, error) {
+	folderPath = filepath.Clean(folderPath)
+	if holder, ok := s.lockedByOther(folderPath); ok {
+		return 0, &index.LockedError{Holder: holder}
+	}
+
+	idx, err := s.indexes.get(folderPath, false)
+	if err != nil {
+		return 0, err
+	}
+	if idx == nil {
+		return 0, nil
+	}
+
+	if len(paths) == 0 {
+		for _, f := range idx.Failures() {
+			paths = append(paths, f.Path)
+		}
+	}
+	cleared := 0
+	for _, p := range paths {
+		if _, ok := idx.Failure(filepath.Clean(p)); ok {
+			idx.ClearFailure(filepath.Clean(p))
+			cleared++
+		}
+	}
+	if cleared == 0 {
+		return 0, nil
+	}
+
+	if err := idx.Save(); err != nil {
+		return 0, fmt.Errorf("save index: %w", err)
+	}
+	return cleared, nil
+}
+
+// Failures returns the images of a folder that failed to index, sorted by
+// path.
+func (s *Service) Failures(folderPath string) ([]index.Failure, error) {
+	idx, err := s.indexes.get(filepath.Clean(folderPath), false)
+	if err != nil {
+		return nil, err
+	}
+	if idx == nil {
+		return []index.Failure{}, nil
+	}
+
+	failures := idx.Failures()
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Path < failures[j].Path })
+	return failures, nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/description"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"too large", fmt.Errorf("resize image: %w: %w", description.ErrUnreadableImage, image.ErrTooLarge), FailureTooLarge},
+		{"decoder panic", fmt.Errorf("resize image: %w: %w", description.ErrUnreadableImage, image.ErrMalformed), FailureMalformed},
+		{"decode timeout", fmt.Errorf("resize image: %w", image.ErrDecodeTimeout), FailureTimeout},
+		{"decoders busy", fmt.Errorf("resize image: %w", image.ErrBusy), FailureTimeout},
+		{"model timeout", context.DeadlineExceeded, FailureTimeout},
+		{"read error", fmt.Errorf("resize image: %w", &fs.PathError{Op: "read", Path: "a.jpg", Err: syscall.EIO}), FailureIO},
+		{"undecodable", fmt.Errorf("resize image: %w: %w", description.ErrUnreadableImage, errors.New("image: unknown format")), FailureUnreadable},
+		{"unusable", ErrUnusableDescription, FailureUnusable},
+		{"context full", errors.New("chat: context window is full"), FailureContextFull},
+		{"other", errors.New("embed: model crashed"), FailureEmbed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(FailureEmbed, tt.err); got != tt.want {
+				t.Errorf("classifyFailure = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Fail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	if err := os.WriteFile(path, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	const backoff = time.Minute
+
+	// prev records a failure of the file as it is now.
+	prev := func(attempts int) index.Failure {
+		return index.Failure{Path: path, Attempts: attempts, FileModTime: fi.ModTime(), FileSize: fi.Size()}
+	}
+
+	tests := []struct {
+		name         string
+		policy       retryPolicy
+		prev         index.Failure
+		class        string
+		wantAttempts int
+		wantSkipped  bool
+		wantWait     time.Duration // until NextRetry, when not skipped
+	}{
+		{"first failure", retryPolicy{maxAttempts: 3, backoff: backoff}, index.Failure{}, FailureDescribe, 1, false, backoff},
+		{"backoff doubles", retryPolicy{maxAttempts: 5, backoff: backoff}, prev(2), FailureDescribe, 3, false, 4 * backoff},
+		{"backoff capped", retryPolicy{backoff: backoff}, prev(20), FailureDescribe, 21, false, backoff << maxBackoffDoublings},
+		{"max attempts", retryPolicy{maxAttempts: 3, backoff: backoff}, prev(2), FailureDescribe, 3, true, 0},
+		{"no max attempts", retryPolicy{backoff: backoff}, prev(5), FailureDescribe, 6, false, 32 * backoff},
+		{"permanent class", retryPolicy{maxAttempts: 3, backoff: backoff}, index.Failure{}, FailureUnreadable, 1, true, 0},
+		{"retryable class", retryPolicy{maxAttempts: 3, backoff: backoff}, index.Failure{}, FailureIO, 1, false, backoff},
+		{"file changed starts over", retryPolicy{maxAttempts: 3, backoff: backoff}, index.Failure{Path: path, Attempts: 2, FileModTime: fi.ModTime(), FileSize: fi.Size() + 1}, FailureDescribe, 1, false, backoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.policy.fail(tt.prev, path, tt.class, errors.New("failed"), now)
+
+			if f.Attempts != tt.wantAttempts {
+				t.Errorf("Attempts = %d, want %d", f.Attempts, tt.wantAttempts)
+			}
+			if f.Skipped != tt.wantSkipped {
+				t.Errorf("Skipped = %v, want %v", f.Skipped, tt.wantSkipped)
+			}
+			if tt.wantSkipped {
+				if !f.NextRetry.IsZero() {
+					t.Errorf("NextRetry = %v, want zero when skipped", f.NextRetry)
+				}
+			} else if got := f.NextRetry.Sub(now); got != tt.wantWait {
+				t.Errorf("NextRetry in %s, want %s", got, tt.wantWait)
+			}
+			if !f.FileModTime.Equal(fi.ModTime()) || f.FileSize != fi.Size() {
+				t.Errorf("file version = %v/%d, want %v/%d", f.FileModTime, f.FileSize, fi.ModTime(), fi.Size())
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Due(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	if err := os.WriteFile(path, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	policy := retryPolicy{maxAttempts: 3, backoff: time.Minute}
+
+	tests := []struct {
+		name string
+		f    index.Failure
+		want bool
+	}{
+		{"backoff pending", index.Failure{NextRetry: now.Add(time.Second)}, false},
+		{"backoff elapsed", index.Failure{NextRetry: now}, true},
+		{"skipped", index.Failure{Skipped: true}, false},
+		{"skipped, file changed", index.Failure{Skipped: true, FileSize: fi.Size() + 1}, true},
+		{"backoff pending, file changed", index.Failure{NextRetry: now.Add(time.Hour), FileModTime: fi.ModTime().Add(-time.Hour)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			f.Path = path
+			if f.FileModTime.IsZero() {
+				f.FileModTime = fi.ModTime()
+			}
+			if f.FileSize == 0 {
+				f.FileSize = fi.Size()
+			}
+			if got := policy.due(f, now); got != tt.want {
+				t.Errorf("due = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// folderSettingsFileName is the per-folder settings file, kept next to the
+// folder index.
+const folderSettingsFileName = ".locallens.json"
+
+// folderSettings is the content of a folder settings file.
+type folderSettings struct {
+	// Context describes what the images of the folder share, such as
+	// "wedding of Ana and João, ceremony and party". It steers the vision
+	// and categorization prompts toward its vocabulary.
+	Context string `json:"context,omitempty"`
+}
+
+// FolderContext returns the context set for the images of a folder, or ""
+// if none is.
+func (s *Service) FolderContext(folderPath string) (string, error) {
+	settings, err := s.readFolderSettings(filepath.Clean(folderPath))
+	if err != nil {
+		return "", err
+	}
+	return settings.Context, nil
+}
+
+// SetFolderContext sets the context of the images of a folder. An empty
+// context removes it. Images already indexed keep their descriptions; their
+// entries record the hash of the context they were described with.
+func (s *Service) SetFolderContext(folderPath, folderContext string) error {
+	path, err := s.folderSettingsPath(filepath.Clean(folderPath))
+	if err != nil {
+		return err
+	}
+
+	settings := folderSettings{Context: strings.TrimSpace(folderContext)}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode folder settings: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create index dir: %w", err)
+	}
+	if err := writeFolderSettings(path, data); err != nil {
+		return fmt.Errorf("write folder settings: %w", err)
+	}
+	return nil
+}
+
+// writeFolderSettings writes data to a temporary file next to path and renames
+// it into place, so an indexing run reading the settings never sees a partial
+// file and a failed write keeps the previous one.
+func writeFolderSettings(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
+
+// readFolderSettings reads the settings file of a folder. A missing file
+// holds the zero settings.
+func (s *Service) readFolderSettings(folderPath string) (folderSettings, error) {
+	path, err := s.folderSettingsPath(folderPath)
+	if err != nil {
+		return folderSettings{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return folderSettings{}, nil
+	}
+	if err != nil {
+		return folderSettings{}, fmt.Errorf("read folder settings: %w", err)
+	}
+
+	var settings folderSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return folderSettings{}, fmt.Errorf("decode folder settings %q: %w", path, err)
+	}
+	return settings, nil
+}
+
+// folderSettingsPath returns where the settings file of a folder is kept:
+// in the same directory as its index, so folders indexed elsewhere don't
+// need to be writable.
+func (s *Service) folderSettingsPath(folderPath string) (string, error) {
+	indexPath, err := s.indexPath(folderPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(indexPath), folderSettingsFileName), nil
+}
+
+// contextHash identifies a folder context in index entries, so descriptions
+// made with another context can be told apart. It is "" for no context.
+func contextHash(folderContext string) string {
+	if folderContext == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(folderContext))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,562 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Sampling is how SampleFrames picks the frames of an animation.
+type Sampling string
+
+const (
+	// SampleScene picks the frames that differ most from the one before,
+	// filling up with evenly spaced ones when the animation changes little.
+	SampleScene Sampling = "scene"
+	// SampleEven picks frames evenly spaced over the animation.
+	SampleEven Sampling = "even"
+)
+
+// ParseSampling converts a config string to a Sampling. Empty means
+// SampleScene.
+func ParseSampling(s string) (Sampling, error) {
+	switch Sampling(s) {
+	case "", SampleScene:
+		return SampleScene, nil
+	case SampleEven:
+		return SampleEven, nil
+	}
+	return "", fmt.Errorf("unknown frame sampling %q: want %q or %q", s, SampleScene, SampleEven)
+}
+
+const (
+	// signatureSide is the side of the grayscale thumbnails frames are
+	// compared by.
+	signatureSide = 16
+
+	// sceneThreshold is the mean difference, from 0 to 1, between the
+	// signatures of two consecutive frames from which the second starts a
+	// new scene.
+	sceneThreshold = 0.08
+
+	// sheetGap is the gap between contact sheet cells, as a share of the
+	// longer side of a frame.
+	sheetGap = 0.03
+)
+
+// Frame is one frame of an animated image, composited onto the animation
+// canvas.
+type Frame struct {
+	// Index is the position of the frame in the animation, from 0.
+	Index int
+	*Source
+}
+
+// animation is a decoded animated image. Its frames are composited in order
+// onto a canvas of its size.
+type animation struct {
+	size   image.Point
+	frames []animationFrame
+}
+
+// animationFrame is one frame of an animation, before compositing.
+type animationFrame struct {
+	// decode returns the frame, positioned on the canvas. WebP frames are
+	// decoded on each call, so they are never all held in memory.
+	decode func() (image.Image, error)
+	// blend draws the frame over the canvas; otherwise it replaces it.
+	blend bool
+	// disposeBackground clears the frame area once it has been shown, and
+	// disposePrevious restores the area to what it was before.
+	disposeBackground bool
+	disposePrevious   bool
+}
+
+// SampleFrames decodes up to n representative frames of the animated GIF or
+// WebP image at path, in animation order, along with the number of frames
+// it has. The first frame is always included. Still images, and every image
+// when n is below 2, give their only, or first, frame. Decoding stays within
+// the limits set by SetLimits.
+func SampleFrames(path string, n int, sampling Sampling) ([]Frame, int, error) {
+	type sampled struct {
+		frames []Frame
+		total  int
+	}
+	s, err := guard(path, func() (sampled, error) {
+		frames, total, err := sampleFrames(path, n, sampling)
+		return sampled{frames, total}, err
+	})
+	return s.frames, s.total, err
+}
+
+func sampleFrames(path string, n int, sampling Sampling) ([]Frame, int, error) {
+	anim, err := openAnimation(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if anim == nil || n < 2 {
+		src, err := open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		total := 1
+		if anim != nil {
+			total = len(anim.frames)
+		}
+		return []Frame{{Index: 0, Source: src}}, total, nil
+	}
+
+	total := len(anim.frames)
+	var picked []int
+	if sampling == SampleEven {
+		picked = evenFrames(total, n)
+	} else {
+		diffs := make([]float64, total)
+		var prev []uint8
+		err := anim.composite(func(i int, canvas *image.RGBA) bool {
+			sig := signature(canvas)
+			if prev != nil {
+				diffs[i] = signatureDiff(prev, sig)
+			}
+			prev = sig
+			return true
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		picked = sceneFrames(diffs, n)
+	}
+
+	frames := make([]Frame, 0, len(picked))
+	want := make(map[int]bool, len(picked))
+	for _, i := range picked {
+		want[i] = true
+	}
+	err = anim.composite(func(i int, canvas *image.RGBA) bool {
+		if want[i] {
+			frame := image.NewRGBA(canvas.Rect)
+			copy(frame.Pix, canvas.Pix)
+			frames = append(frames, Frame{Index: i, Source: &Source{img: frame}})
+		}
+		return len(frames) < len(picked)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return frames, total, nil
+}
+
+// ContactSheet lays frames out in a grid of equal cells, in reading order,
+// separated by gaps of the background color, into one image to describe.
+func ContactSheet(frames []Frame, background color.Color) *Source {
+	if background == nil {
+		background = color.White
+	}
+
+	var cell image.Point
+	for _, f := range frames {
+		size := f.Size()
+		cell.X, cell.Y = max(cell.X, size.X), max(cell.Y, size.Y)
+	}
+	cols := int(math.Ceil(math.Sqrt(float64(len(frames)))))
+	rows := (len(frames) + cols - 1) / cols
+	gap := int(math.Ceil(sheetGap * float64(max(cell.X, cell.Y))))
+
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*cell.X+(cols-1)*gap, rows*cell.Y+(rows-1)*gap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	for i, f := range frames {
+		at := image.Pt(i%cols*(cell.X+gap), i/cols*(cell.Y+gap))
+		draw.Draw(sheet, f.img.Bounds().Sub(f.img.Bounds().Min).Add(at), f.img, f.img.Bounds().Min, draw.Over)
+	}
+	return &Source{img: sheet}
+}
+
+// composite draws the frames of a in order onto a transparent canvas and
+// calls fn with each frame index and the canvas showing it, until fn returns
+// false. The canvas is reused from frame to frame.
+func (a *animation) composite(fn func(i int, canvas *image.RGBA) bool) error {
+	canvas := image.NewRGBA(image.Rectangle{Max: a.size})
+	var saved *image.RGBA
+
+	for i, f := range a.frames {
+		img, err := f.decode()
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+		area := img.Bounds().Intersect(canvas.Rect)
+
+		if f.disposePrevious {
+			saved = image.NewRGBA(area)
+			draw.Draw(saved, area, canvas, area.Min, draw.Src)
+		}
+		op := draw.Src
+		if f.blend {
+			op = draw.Over
+		}
+		draw.Draw(canvas, area, img, area.Min, op)
+
+		if !fn(i, canvas) {
+			return nil
+		}
+
+		switch {
+		case f.disposeBackground:
+			draw.Draw(canvas, area, image.Transparent, image.Point{}, draw.Src)
+		case f.disposePrevious:
+			draw.Draw(canvas, area, saved, area.Min, draw.Src)
+		}
+	}
+	return nil
+}
+
+// openAnimation decodes the animated GIF or WebP image at path. It returns
+// nil for still images and other formats.
+func openAnimation(path string) (*animation, error) {
+	if err := checkFile(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	var header [12]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return nil, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	switch {
+	case string(header[:4]) == "GIF8":
+		return decodeGIFAnimation(f)
+	case string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		return decodeWebPAnimation(data)
+	}
+	return nil, nil
+}
+
+// decodeGIFAnimation decodes every frame of a GIF file at once, so they are
+// checked against the pixel limit together first.
+func decodeGIFAnimation(r io.ReadSeeker) (*animation, error) {
+	canvas, pixels, err := gifPixels(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if err := checkPixels(canvas.X, canvas.Y); err != nil {
+		return nil, err
+	}
+	if err := checkFramePixels(pixels); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if len(g.Image) < 2 {
+		return nil, nil
+	}
+
+	a := &animation{size: image.Pt(g.Config.Width, g.Config.Height)}
+	for i, img := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		a.frames = append(a.frames, animationFrame{
+			decode:            func() (image.Image, error) { return img, nil },
+			blend:             true, // transparent pixels show what is below
+			disposeBackground: disposal == gif.DisposalBackground,
+			disposePrevious:   disposal == gif.DisposalPrevious,
+		})
+	}
+	return a, nil
+}
+
+// WebP container chunk flags.
+const (
+	webpAnimationFlag = 1 << 1
+	webpAlphaFlag     = 1 << 4
+	webpDisposeFlag   = 1 << 0 // ANMF: dispose to background
+	webpNoBlendFlag   = 1 << 1 // ANMF: replace instead of blending
+)
+
+var errInvalidWebP = errors.New("invalid webp animation")
+
+// webpChunk is one chunk of a RIFF container.
+type webpChunk struct {
+	id   string
+	data []byte
+}
+
+// webpChunks splits data into RIFF chunks.
+func webpChunks(data []byte) ([]webpChunk, error) {
+	var chunks []webpChunk
+	for len(data) >= 8 {
+		n := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(n) > uint64(len(data)-8) {
+			return nil, errInvalidWebP
+		}
+		chunks = append(chunks, webpChunk{id: string(data[:4]), data: data[8 : 8+n]})
+		next := min(8+int(n)+int(n&1), len(data)) // chunks are padded to even sizes
+		data = data[next:]
+	}
+	return chunks, nil
+}
+
+// decodeWebPAnimation parses an animated WebP file. Each frame is decoded by
+// the still image decoder from a WebP file rebuilt out of its chunks.
+func decodeWebPAnimation(data []byte) (*animation, error) {
+	if len(data) < 12 {
+		return nil, errInvalidWebP
+	}
+	chunks, err := webpChunks(data[12:])
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].id != "VP8X" || len(chunks[0].data) < 10 || chunks[0].data[0]&webpAnimationFlag == 0 {
+		return nil, nil
+	}
+	a := &animation{size: image.Pt(int(uint24(chunks[0].data[4:]))+1, int(uint24(chunks[0].data[7:]))+1)}
+	if err := checkPixels(a.size.X, a.size.Y); err != nil {
+		return nil, err
+	}
+
+	for _, c := range chunks[1:] {
+		if c.id != "ANMF" {
+			continue
+		}
+		if len(c.data) < 16 {
+			return nil, errInvalidWebP
+		}
+		at := image.Pt(2*int(uint24(c.data[0:])), 2*int(uint24(c.data[3:])))
+		flags := c.data[15]
+		frameData := c.data[16:]
+
+		a.frames = append(a.frames, animationFrame{
+			decode: func() (image.Image, error) {
+				img, err := decodeWebPFrame(frameData)
+				if err != nil {
+					return nil, err
+				}
+				return translated{img, at}, nil
+			},
+			blend:             flags&webpNoBlendFlag == 0,
+			disposeBackground: flags&webpDisposeFlag != 0,
+		})
+	}
+	if len(a.frames) == 0 {
+		return nil, errInvalidWebP
+	}
+	return a, nil
+}
+
+// decodeWebPFrame decodes the image chunks of one ANMF frame: an optional
+// ALPH chunk and a VP8 one, or a VP8L one.
+func decodeWebPFrame(data []byte) (image.Image, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	for _, c := range chunks {
+		switch c.id {
+		case "ALPH":
+			// Alpha needs a VP8X header of the frame size ahead of it.
+			vp8, ok := findChunk(chunks, "VP8 ")
+			if !ok || len(vp8) < 10 {
+				return nil, errInvalidWebP
+			}
+			w := binary.LittleEndian.Uint16(vp8[6:8]) & 0x3fff
+			h := binary.LittleEndian.Uint16(vp8[8:10]) & 0x3fff
+			if w == 0 || h == 0 {
+				return nil, errInvalidWebP
+			}
+			x := make([]byte, 10)
+			x[0] = webpAlphaFlag
+			putUint24(x[4:], uint32(w)-1)
+			putUint24(x[7:], uint32(h)-1)
+			writeChunk(&body, "VP8X", x)
+			writeChunk(&body, c.id, c.data)
+		case "VP8 ", "VP8L":
+			writeChunk(&body, c.id, c.data)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(4+body.Len()))
+	file.WriteString("WEBP")
+	file.Write(body.Bytes())
+
+	// Frames are decoded one at a time, each within the limit.
+	cfg, err := webp.DecodeConfig(bytes.NewReader(file.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPixels(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	return webp.Decode(&file)
+}
+
+func findChunk(chunks []webpChunk, id string) ([]byte, bool) {
+	for _, c := range chunks {
+		if c.id == id {
+			return c.data, true
+		}
+	}
+	return nil, false
+}
+
+func writeChunk(w *bytes.Buffer, id string, data []byte) {
+	w.WriteString(id)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)%2 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// translated is an image moved by an offset.
+type translated struct {
+	image.Image
+	at image.Point
+}
+
+func (t translated) Bounds() image.Rectangle {
+	return t.Image.Bounds().Add(t.at)
+}
+
+func (t translated) At(x, y int) color.Color {
+	return t.Image.At(x-t.at.X, y-t.at.Y)
+}
+
+// decodeWebP decodes a WebP file. Animated files, which the still image
+// decoder rejects, give their first frame.
+func decodeWebP(path string) (image.Image, error) {
+	img, err := decodeFile(path)
+	if err == nil || errors.Is(err, ErrTooLarge) {
+		return img, err
+	}
+
+	anim, animErr := openAnimation(path)
+	if animErr != nil || anim == nil {
+		return nil, err
+	}
+	var first *image.RGBA
+	animErr = anim.composite(func(_ int, canvas *image.RGBA) bool {
+		first = canvas
+		return false
+	})
+	if animErr != nil {
+		return nil, animErr
+	}
+	return first, nil
+}
+
+// evenFrames returns n frame indexes evenly spaced over total frames,
+// starting at the first.
+func evenFrames(total, n int) []int {
+	n = min(n, total)
+	picked := make([]int, n)
+	for i := range picked {
+		picked[i] = i * total / n
+	}
+	return picked
+}
+
+// sceneFrames returns the first frame and up to n-1 others, those whose diff
+// to the frame before is largest and at least sceneThreshold, topped up with
+// evenly spaced frames, in order.
+func sceneFrames(diffs []float64, n int) []int {
+	n = min(n, len(diffs))
+	byDiff := make([]int, 0, len(diffs)-1)
+	for i := 1; i < len(diffs); i++ {
+		if diffs[i] >= sceneThreshold {
+			byDiff = append(byDiff, i)
+		}
+	}
+	sort.SliceStable(byDiff, func(a, b int) bool { return diffs[byDiff[a]] > diffs[byDiff[b]] })
+
+	chosen := map[int]bool{0: true}
+	for _, i := range byDiff {
+		if len(chosen) == n {
+			break
+		}
+		chosen[i] = true
+	}
+	for _, i := range evenFrames(len(diffs), n) {
+		if len(chosen) == n {
+			break
+		}
+		chosen[i] = true
+	}
+	for i := 0; len(chosen) < n; i++ {
+		chosen[i] = true
+	}
+
+	picked := make([]int, 0, n)
+	for i := range chosen {
+		picked = append(picked, i)
+	}
+	sort.Ints(picked)
+	return picked
+}
+
+// signature returns a signatureSide² grayscale thumbnail of img, sampled at
+// the cell centers, to compare frames by.
+func signature(img *image.RGBA) []uint8 {
+	sig := make([]uint8, signatureSide*signatureSide)
+	b := img.Rect
+	for y := range signatureSide {
+		for x := range signatureSide {
+			px := b.Min.X + (2*x+1)*b.Dx()/(2*signatureSide)
+			py := b.Min.Y + (2*y+1)*b.Dy()/(2*signatureSide)
+			c := img.RGBAAt(px, py)
+			sig[y*signatureSide+x] = uint8((299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000)
+		}
+	}
+	return sig
+}
+
+// signatureDiff returns the mean absolute difference of two signatures, from
+// 0 to 1.
+func signatureDiff(a, b []uint8) float64 {
+	var sum int
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		sum += max(d, -d)
+	}
+	return float64(sum) / float64(255*len(a))
+}
@@ -0,0 +1,266 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	goimage "image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// solidFrames returns an animated GIF of 8x8 frames, one per color.
+func solidFrames(t *testing.T, colors ...color.Color) string {
+	t.Helper()
+	anim := &gif.GIF{}
+	for _, c := range colors {
+		frame := goimage.NewPaletted(goimage.Rect(0, 0, 8, 8), palette.Plan9)
+		idx := uint8(frame.Palette.Index(c))
+		for i := range frame.Pix {
+			frame.Pix[i] = idx
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	return writeFile(t, "anim.gif", buf.Bytes())
+}
+
+var (
+	red   = color.RGBA{R: 255, A: 255}
+	green = color.RGBA{G: 255, A: 255}
+	blue  = color.RGBA{B: 255, A: 255}
+)
+
+func frameIndexes(frames []image.Frame) []int {
+	var idx []int
+	for _, f := range frames {
+		idx = append(idx, f.Index)
+	}
+	return idx
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSampleFrames(t *testing.T) {
+	path := solidFrames(t, red, red, blue, blue, green)
+
+	tests := []struct {
+		name     string
+		n        int
+		sampling image.Sampling
+		want     []int
+	}{
+		{"scene changes", 3, image.SampleScene, []int{0, 2, 4}},
+		{"scene topped up evenly", 4, image.SampleScene, []int{0, 1, 2, 4}},
+		{"even", 3, image.SampleEven, []int{0, 1, 3}},
+		{"more than frames", 10, image.SampleEven, []int{0, 1, 2, 3, 4}},
+		{"first only", 1, image.SampleScene, []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, total, err := image.SampleFrames(path, tt.n, tt.sampling)
+			if err != nil {
+				t.Fatalf("sample: %v", err)
+			}
+			if total != 5 {
+				t.Errorf("expected 5 frames, got %d", total)
+			}
+			if got := frameIndexes(frames); !equalInts(got, tt.want) {
+				t.Errorf("expected frames %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSampleFrames_Colors(t *testing.T) {
+	frames, _, err := image.SampleFrames(solidFrames(t, red, blue, green), 3, image.SampleScene)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	for i, want := range []string{"red", "blue", "green"} {
+		data, err := frames[i].Encode(image.Options{MaxSide: maxSideDefault})
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if got := dominantJPEG(t, data, 4, 4); got != want {
+			t.Errorf("frame %d: expected %s, got %s", i, want, got)
+		}
+	}
+}
+
+func TestSampleFrames_StillImage(t *testing.T) {
+	path := writePNG(t, solidImage(10, 10))
+	frames, total, err := image.SampleFrames(path, 4, image.SampleScene)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	if len(frames) != 1 || total != 1 {
+		t.Errorf("expected a single frame, got %d of %d", len(frames), total)
+	}
+}
+
+func TestContactSheet(t *testing.T) {
+	frames, _, err := image.SampleFrames(solidFrames(t, red, blue, green), 3, image.SampleEven)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+
+	// 2x2 cells of 8 pixels with a 1 pixel gap; the last cell stays empty.
+	sheet := image.ContactSheet(frames, nil)
+	if size := sheet.Size(); size.X != 17 || size.Y != 17 {
+		t.Fatalf("expected 17x17, got %v", size)
+	}
+	data, err := sheet.Encode(image.Options{MaxSide: maxSideDefault})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	for _, c := range []struct {
+		x, y int
+		want string
+	}{{3, 3, "red"}, {13, 3, "blue"}, {3, 13, "green"}} {
+		if got := dominantJPEG(t, data, c.x, c.y); got != c.want {
+			t.Errorf("cell at (%d,%d): expected %s, got %s", c.x, c.y, c.want, got)
+		}
+	}
+}
+
+func TestParseSampling(t *testing.T) {
+	if s, err := image.ParseSampling(""); err != nil || s != image.SampleScene {
+		t.Errorf("expected scene by default, got %q, %v", s, err)
+	}
+	if _, err := image.ParseSampling("random"); err == nil {
+		t.Error("expected an error for an unknown sampling")
+	}
+}
+
+// grayVP8 is a 1x1 gray lossy WebP image chunk.
+var grayVP8 = []byte("\x30\x01\x00\x9d\x01\x2a\x01\x00\x01\x00\x0e\xc0\xfe\x25\xa4\x00\x03\x70\x00\x00\x00\x00")
+
+func riffChunk(id string, data []byte) []byte {
+	out := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func uint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// animatedWebP returns an animated WebP of a 4x1 canvas showing a gray pixel
+// at x=0, then one at x=2. flags are the ANMF flags of the first frame.
+func animatedWebP(t *testing.T, flags byte) string {
+	t.Helper()
+	vp8x := append([]byte{1 << 1, 0, 0, 0}, append(uint24(3), uint24(0)...)...)
+	anim := make([]byte, 6) // background color and loop count
+
+	frame := func(x int, flags byte) []byte {
+		hdr := append(uint24(x/2), uint24(0)...)
+		hdr = append(hdr, uint24(0)...) // width - 1
+		hdr = append(hdr, uint24(0)...) // height - 1
+		hdr = append(hdr, uint24(100)...)
+		hdr = append(hdr, flags)
+		return riffChunk("ANMF", append(hdr, riffChunk("VP8 ", grayVP8)...))
+	}
+
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("ANIM", anim)...)
+	body = append(body, frame(0, flags)...)
+	body = append(body, frame(2, 0)...)
+	return writeFile(t, "anim.webp", riffChunk("RIFF", body))
+}
+
+func TestSampleFrames_WebP(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags byte
+		gray  []int // gray pixels of the second frame
+	}{
+		{"keep", 0, []int{0, 2}},
+		{"dispose to background", 1, []int{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, total, err := image.SampleFrames(animatedWebP(t, tt.flags), 2, image.SampleEven)
+			if err != nil {
+				t.Fatalf("sample: %v", err)
+			}
+			if total != 2 || len(frames) != 2 {
+				t.Fatalf("expected 2 frames, got %d of %d", len(frames), total)
+			}
+
+			// Transparent pixels are flattened onto the red background, darker
+			// than gray; chroma is too subsampled at this size to tell apart.
+			data, err := frames[1].Encode(image.Options{MaxSide: maxSideDefault, Background: red})
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			img := decodeJPEG(t, data)
+			for x := range 4 {
+				want := "red"
+				for _, g := range tt.gray {
+					if x == g {
+						want = "gray"
+					}
+				}
+				if got := grayOrRed(img.At(x, 0)); got != want {
+					t.Errorf("pixel %d: expected %s, got %s", x, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestResize_AnimatedWebP(t *testing.T) {
+	data, err := image.Resize(animatedWebP(t, 0), maxSideDefault)
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	if b := decodeJPEG(t, data).Bounds(); b.Dx() != 4 || b.Dy() != 1 {
+		t.Errorf("expected the 4x1 canvas, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func decodeJPEG(t *testing.T, data []byte) goimage.Image {
+	t.Helper()
+	img, _, err := goimage.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return img
+}
+
+func dominantJPEG(t *testing.T, data []byte, x, y int) string {
+	t.Helper()
+	return dominant(decodeJPEG(t, data).At(x, y))
+}
+
+func grayOrRed(c color.Color) string {
+	if color.GrayModel.Convert(c).(color.Gray).Y > 100 {
+		return "gray"
+	}
+	return "red"
+}
@@ -0,0 +1,128 @@
+package image
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseColor parses a "#rrggbb" or "#rgb" hex color, with or without the
+// leading '#'.
+func ParseColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// sRGBGamma is the PNG gAMA value of sRGB-like encoding, 1/2.2 scaled by
+// 100000. Files that declare it, or within gammaTolerance of it, are used as
+// they are.
+const (
+	sRGBGamma      = 45455
+	gammaTolerance = 0.05
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngGamma returns the gamma a PNG file declares in its gAMA chunk. It
+// reports false for other formats, for files without the chunk, and for
+// files with an sRGB or iCCP chunk, which take precedence over gAMA.
+func pngGamma(path string) (float64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || string(sig) != string(pngSignature) {
+		return 0, false
+	}
+
+	gamma, found := 0.0, false
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, false
+		}
+		n := int(binary.BigEndian.Uint32(hdr[0:4]))
+
+		switch string(hdr[4:8]) {
+		case "sRGB", "iCCP":
+			return 0, false
+		case "gAMA":
+			var v [4]byte
+			if n != 4 {
+				return 0, false
+			}
+			if _, err := io.ReadFull(r, v[:]); err != nil {
+				return 0, false
+			}
+			gamma, found = float64(binary.BigEndian.Uint32(v[:]))/100000, true
+			n = 0
+		case "IDAT", "IEND":
+			// Color chunks must come before the image data.
+			return gamma, found && gamma > 0
+		}
+
+		if _, err := r.Discard(n + 4); err != nil { // data and CRC
+			return 0, false
+		}
+	}
+}
+
+// isSRGBGamma reports whether a file gamma is close enough to sRGB's to use
+// the samples as they are.
+func isSRGBGamma(gamma float64) bool {
+	return math.Abs(gamma*100000/sRGBGamma-1) <= gammaTolerance
+}
+
+// fromSRGB encodes an sRGB color with the given file gamma, the inverse of
+// toSRGB, so a background drawn before toSRGB comes out as c.
+func fromSRGB(c color.RGBA, gamma float64) color.RGBA {
+	if isSRGBGamma(gamma) {
+		return c
+	}
+	exp := gamma * 2.2
+	conv := func(v uint8) uint8 { return uint8(math.Round(255 * math.Pow(float64(v)/255, exp))) }
+	return color.RGBA{R: conv(c.R), G: conv(c.G), B: conv(c.B), A: c.A}
+}
+
+// toSRGB re-encodes the color channels of img, stored with the given file
+// gamma, to the sRGB curve in place.
+func toSRGB(img *image.RGBA, gamma float64) {
+	if isSRGBGamma(gamma) {
+		return
+	}
+
+	// sample = light^gamma, and sRGB is about light^(1/2.2).
+	exp := 1 / (gamma * 2.2)
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, exp)))
+	}
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = lut[img.Pix[i]]
+		img.Pix[i+1] = lut[img.Pix[i+1]]
+		img.Pix[i+2] = lut[img.Pix[i+2]]
+	}
+}
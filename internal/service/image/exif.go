@@ -0,0 +1,425 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned when an image has no readable EXIF block.
+var ErrNoExif = errors.New("no exif data")
+
+// Exif holds the EXIF metadata LocalLens uses. Zero values mean the tag is
+// absent.
+type Exif struct {
+	DateTimeOriginal time.Time // capture time, in local time (EXIF has no zone)
+	Make             string    // camera maker
+	Model            string    // camera model
+	// Orientation is how the stored pixels must be transformed for display,
+	// from 1 (as stored) to 8. See Orient.
+	Orientation int
+
+	// HasGPS reports whether Latitude and Longitude, in decimal degrees,
+	// were recorded.
+	HasGPS    bool
+	Latitude  float64
+	Longitude float64
+}
+
+// Camera returns the camera make and model, without repeating the make when
+// the model already starts with it, as in "Canon" "Canon EOS R6".
+func (x Exif) Camera() string {
+	if x.Make == "" || strings.HasPrefix(strings.ToLower(x.Model), strings.ToLower(x.Make)) {
+		return x.Model
+	}
+	return strings.TrimSpace(x.Make + " " + x.Model)
+}
+
+// EXIF tags read by parseExif.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+
+	// Tags of the GPS IFD.
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// ReadExif reads the EXIF metadata of a JPEG, WebP, TIFF or TIFF-based RAW
+// file. Only the container headers are read, never the pixel data. It
+// returns ErrNoExif for other formats and for files without an EXIF block.
+func ReadExif(path string) (Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Exif{}, fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	tiff, err := findExifTIFF(bufio.NewReader(f))
+	if err != nil {
+		return Exif{}, err
+	}
+
+	return parseExif(tiff)
+}
+
+// tiffExifMaxBytes is how much of a TIFF file is read for its metadata. The
+// IFDs of scans and camera RAWs come before the pixel data; values stored
+// past it are skipped.
+const tiffExifMaxBytes = 4 << 20
+
+// findExifTIFF locates the TIFF-structured EXIF payload inside a JPEG APP1
+// segment or a WebP EXIF chunk, or the start of a TIFF file, which is one.
+func findExifTIFF(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(12)
+	if err != nil && len(head) < 2 {
+		return nil, ErrNoExif
+	}
+
+	switch {
+	case head[0] == 0xFF && head[1] == 0xD8:
+		return jpegExif(r)
+	case len(head) == 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return webpExif(r)
+	case len(head) >= 4 && isTIFFHeader(head):
+		return io.ReadAll(io.LimitReader(r, tiffExifMaxBytes))
+	}
+	return nil, ErrNoExif
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// jpegExif walks JPEG marker segments up to the start of scan looking for an
+// APP1 segment with an Exif header.
+func jpegExif(r *bufio.Reader) ([]byte, error) {
+	if _, err := r.Discard(2); err != nil { // SOI
+		return nil, ErrNoExif
+	}
+
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return nil, ErrNoExif
+		}
+		// Padding 0xFF bytes may precede a marker.
+		for marker[1] == 0xFF {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, ErrNoExif
+			}
+			marker[1] = b
+		}
+
+		switch {
+		case marker[1] == 0xD9 || marker[1] == 0xDA: // EOI, SOS: no more metadata
+			return nil, ErrNoExif
+		case marker[1] == 0x01 || (marker[1] >= 0xD0 && marker[1] <= 0xD7): // standalone markers
+			continue
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, ErrNoExif
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, ErrNoExif
+		}
+
+		if marker[1] != 0xE1 {
+			if _, err := r.Discard(n); err != nil {
+				return nil, ErrNoExif
+			}
+			continue
+		}
+
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return nil, ErrNoExif
+		}
+		if bytes.HasPrefix(seg, exifHeader) {
+			return seg[len(exifHeader):], nil
+		}
+		// Other APP1 payloads (e.g. XMP) are skipped.
+	}
+}
+
+// webpExif walks the RIFF chunks of a WebP file looking for the EXIF chunk.
+func webpExif(r *bufio.Reader) ([]byte, error) {
+	if _, err := r.Discard(12); err != nil {
+		return nil, ErrNoExif
+	}
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, ErrNoExif
+		}
+		n := int(binary.LittleEndian.Uint32(hdr[4:8]))
+		padded := n + n&1
+
+		if string(hdr[0:4]) != "EXIF" {
+			if _, err := r.Discard(padded); err != nil {
+				return nil, ErrNoExif
+			}
+			continue
+		}
+
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, ErrNoExif
+		}
+		// Some encoders keep the JPEG-style header in the chunk.
+		return bytes.TrimPrefix(chunk, exifHeader), nil
+	}
+}
+
+// parseExif decodes the tags LocalLens uses from a TIFF-structured payload.
+func parseExif(data []byte) (Exif, error) {
+	t, err := newTIFF(data)
+	if err != nil {
+		return Exif{}, err
+	}
+
+	ifd0, err := t.ifd(t.first())
+	if err != nil {
+		return Exif{}, err
+	}
+
+	var x Exif
+
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if sub, err := t.ifd(e.uint(t.order)); err == nil {
+			if e, ok := sub[tagDateTimeOriginal]; ok {
+				x.DateTimeOriginal = parseExifTime(e.ascii())
+			}
+		}
+	}
+	if x.DateTimeOriginal.IsZero() {
+		if e, ok := ifd0[tagDateTime]; ok {
+			x.DateTimeOriginal = parseExifTime(e.ascii())
+		}
+	}
+
+	if e, ok := ifd0[tagMake]; ok {
+		x.Make = e.ascii()
+	}
+	if e, ok := ifd0[tagModel]; ok {
+		x.Model = e.ascii()
+	}
+	if e, ok := ifd0[tagOrientation]; ok {
+		x.Orientation = int(e.uint(t.order))
+	}
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if gps, err := t.ifd(e.uint(t.order)); err == nil {
+			lat, latOK := gpsCoordinate(gps[tagGPSLatitude], gps[tagGPSLatitudeRef], "S", t.order)
+			lon, lonOK := gpsCoordinate(gps[tagGPSLongitude], gps[tagGPSLongitudeRef], "W", t.order)
+			if latOK && lonOK {
+				x.HasGPS, x.Latitude, x.Longitude = true, lat, lon
+			}
+		}
+	}
+
+	return x, nil
+}
+
+// gpsCoordinate converts a degrees, minutes, seconds GPS entry to decimal
+// degrees, negative when ref is the negative hemisphere neg.
+func gpsCoordinate(dms, ref tiffEntry, neg string, order binary.ByteOrder) (float64, bool) {
+	if dms.typ != 5 || dms.count < 3 {
+		return 0, false
+	}
+
+	v := dms.rational(order, 0) + dms.rational(order, 1)/60 + dms.rational(order, 2)/3600
+	if ref.ascii() == neg {
+		v = -v
+	}
+	return v, true
+}
+
+func parseExifTime(s string) time.Time {
+	t, err := time.ParseInLocation(exifTimeLayout, s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// =========================================================================
+// Minimal TIFF IFD reader
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffEntry is one IFD entry with its raw value bytes resolved, whether they
+// were stored inline or at an offset.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffTypeSize holds the byte size of each TIFF field type.
+var tiffTypeSize = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// isTIFFHeader reports whether b starts with a TIFF byte order mark and
+// magic number.
+func isTIFFHeader(b []byte) bool {
+	s := string(b[:4])
+	return s == "II*\x00" || s == "MM\x00*"
+}
+
+func newTIFF(data []byte) (tiffReader, error) {
+	if len(data) < 8 {
+		return tiffReader{}, ErrNoExif
+	}
+
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return tiffReader{}, fmt.Errorf("exif: bad byte order")
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return tiffReader{}, fmt.Errorf("exif: bad tiff magic")
+	}
+
+	return tiffReader{data: data, order: order}, nil
+}
+
+// ifd reads the directory at offset. Entries with unknown types or values
+// pointing outside the payload are skipped.
+func (t tiffReader) ifd(offset uint32) (map[uint16]tiffEntry, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return nil, fmt.Errorf("exif: ifd offset out of range")
+	}
+
+	n := uint32(t.order.Uint16(t.data[offset:]))
+	if uint64(offset)+2+uint64(n)*12 > uint64(len(t.data)) {
+		return nil, fmt.Errorf("exif: ifd out of range")
+	}
+
+	entries := make(map[uint16]tiffEntry, n)
+	for i := range n {
+		p := offset + 2 + i*12
+		tag := t.order.Uint16(t.data[p:])
+		typ := t.order.Uint16(t.data[p+2:])
+		count := t.order.Uint32(t.data[p+4:])
+
+		size, ok := tiffTypeSize[typ]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(count)
+
+		var value []byte
+		if total <= 4 {
+			value = t.data[p+8 : p+8+uint32(total)]
+		} else {
+			at := uint64(t.order.Uint32(t.data[p+8:]))
+			if at+total > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[at : at+total]
+		}
+
+		entries[tag] = tiffEntry{typ: typ, count: count, value: value}
+	}
+
+	return entries, nil
+}
+
+// first returns the offset of the first IFD.
+func (t tiffReader) first() uint32 {
+	return t.order.Uint32(t.data[4:8])
+}
+
+// next returns the offset of the IFD that follows the one at offset, or 0 if
+// it is the last.
+func (t tiffReader) next(offset uint32) uint32 {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return 0
+	}
+	n := uint64(t.order.Uint16(t.data[offset:]))
+	at := uint64(offset) + 2 + n*12
+	if at+4 > uint64(len(t.data)) {
+		return 0
+	}
+	return t.order.Uint32(t.data[at:])
+}
+
+// ascii returns an ASCII value without its NUL terminator and padding.
+func (e tiffEntry) ascii() string {
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+// rational returns the i-th value of a RATIONAL entry, or 0 if it has none
+// or its denominator is 0.
+func (e tiffEntry) rational(order binary.ByteOrder, i int) float64 {
+	if e.typ != 5 || len(e.value) < (i+1)*8 {
+		return 0
+	}
+	num := order.Uint32(e.value[i*8:])
+	den := order.Uint32(e.value[i*8+4:])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// uints returns every value of a BYTE, SHORT or LONG entry.
+func (e tiffEntry) uints(order binary.ByteOrder) []uint32 {
+	size := tiffTypeSize[e.typ]
+	if size == 0 || e.typ > 4 || e.typ == 2 {
+		return nil
+	}
+	vals := make([]uint32, 0, e.count)
+	for i := uint32(0); i < e.count && int((i+1)*size) <= len(e.value); i++ {
+		v := e.value[i*size:]
+		switch e.typ {
+		case 1:
+			vals = append(vals, uint32(v[0]))
+		case 3:
+			vals = append(vals, uint32(order.Uint16(v)))
+		case 4:
+			vals = append(vals, order.Uint32(v))
+		}
+	}
+	return vals
+}
+
+// uint returns the first value of a BYTE, SHORT or LONG entry.
+func (e tiffEntry) uint(order binary.ByteOrder) uint32 {
+	switch {
+	case e.typ == 1 && len(e.value) >= 1:
+		return uint32(e.value[0])
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(order.Uint16(e.value))
+	case e.typ == 4 && len(e.value) >= 4:
+		return order.Uint32(e.value)
+	}
+	return 0
+}
@@ -0,0 +1,216 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// exifTag is one IFD entry for buildTIFF. Values longer than 4 bytes are
+// stored out of line automatically.
+type exifTag struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiTag(tag uint16, s string) exifTag {
+	v := append([]byte(s), 0)
+	return exifTag{tag: tag, typ: 2, count: uint32(len(v)), value: v}
+}
+
+func shortTag(tag uint16, v uint16) exifTag {
+	return exifTag{tag: tag, typ: 3, count: 1, value: binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func rationalTag(tag uint16, vals ...[2]uint32) exifTag {
+	var v []byte
+	for _, r := range vals {
+		v = binary.LittleEndian.AppendUint32(v, r[0])
+		v = binary.LittleEndian.AppendUint32(v, r[1])
+	}
+	return exifTag{tag: tag, typ: 5, count: uint32(len(vals)), value: v}
+}
+
+// buildTIFF returns a little-endian TIFF payload with ifd0 and, when exifIFD
+// is non-empty, an Exif sub-IFD linked from ifd0.
+func buildTIFF(ifd0, exifIFD []exifTag) []byte {
+	return buildTIFFWithGPS(ifd0, exifIFD, nil)
+}
+
+// buildTIFFWithGPS is buildTIFF with a GPS sub-IFD too, when gpsIFD is
+// non-empty.
+func buildTIFFWithGPS(ifd0, exifIFD, gpsIFD []exifTag) []byte {
+	le := binary.LittleEndian
+	ifdSize := func(n int) uint32 { return uint32(2 + n*12 + 4) }
+
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, exifTag{tag: 0x8769, typ: 4, count: 1}) // value patched below
+	}
+	if len(gpsIFD) > 0 {
+		ifd0 = append(ifd0, exifTag{tag: 0x8825, typ: 4, count: 1}) // value patched below
+	}
+
+	ifd0At := uint32(8)
+	exifAt := ifd0At + ifdSize(len(ifd0))
+	gpsAt := exifAt
+	if len(exifIFD) > 0 {
+		gpsAt += ifdSize(len(exifIFD))
+	}
+	dataAt := gpsAt
+	if len(gpsIFD) > 0 {
+		dataAt += ifdSize(len(gpsIFD))
+	}
+
+	var data []byte
+	writeIFD := func(buf []byte, tags []exifTag) []byte {
+		buf = le.AppendUint16(buf, uint16(len(tags)))
+		for _, t := range tags {
+			switch t.tag {
+			case 0x8769:
+				t.value = le.AppendUint32(nil, exifAt)
+			case 0x8825:
+				t.value = le.AppendUint32(nil, gpsAt)
+			}
+			buf = le.AppendUint16(buf, t.tag)
+			buf = le.AppendUint16(buf, t.typ)
+			buf = le.AppendUint32(buf, t.count)
+			if len(t.value) <= 4 {
+				v := make([]byte, 4)
+				copy(v, t.value)
+				buf = append(buf, v...)
+				continue
+			}
+			buf = le.AppendUint32(buf, dataAt+uint32(len(data)))
+			data = append(data, t.value...)
+		}
+		return le.AppendUint32(buf, 0) // no next IFD
+	}
+
+	buf := []byte("II")
+	buf = le.AppendUint16(buf, 42)
+	buf = le.AppendUint32(buf, ifd0At)
+	buf = writeIFD(buf, ifd0)
+	if len(exifIFD) > 0 {
+		buf = writeIFD(buf, exifIFD)
+	}
+	if len(gpsIFD) > 0 {
+		buf = writeIFD(buf, gpsIFD)
+	}
+	return append(buf, data...)
+}
+
+// writeJPEGWithExif encodes img as JPEG with tiff inserted as an APP1 Exif
+// segment right after SOI, and returns the written file path.
+func writeJPEGWithExif(t *testing.T, img goimage.Image, tiff []byte) string {
+	t.Helper()
+
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	raw := enc.Bytes()
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	seg = append(seg, payload...)
+
+	out := append([]byte{}, raw[:2]...)
+	out = append(out, seg...)
+	out = append(out, raw[2:]...)
+
+	path := filepath.Join(t.TempDir(), "exif.jpg")
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func solidImage(w, h int) goimage.Image {
+	img := goimage.NewRGBA(goimage.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	return img
+}
+
+func TestReadExif_DateTimeOriginal(t *testing.T) {
+	tiff := buildTIFF(
+		[]exifTag{asciiTag(0x0132, "2020:01:01 00:00:00")},
+		[]exifTag{asciiTag(0x9003, "2024:08:15 18:30:05")},
+	)
+	path := writeJPEGWithExif(t, solidImage(8, 8), tiff)
+
+	x, err := image.ReadExif(path)
+	if err != nil {
+		t.Fatalf("read exif: %v", err)
+	}
+
+	want := time.Date(2024, 8, 15, 18, 30, 5, 0, time.Local)
+	if !x.DateTimeOriginal.Equal(want) {
+		t.Errorf("DateTimeOriginal = %v, want %v", x.DateTimeOriginal, want)
+	}
+}
+
+func TestReadExif_FallsBackToDateTime(t *testing.T) {
+	tiff := buildTIFF([]exifTag{asciiTag(0x0132, "2019:05:04 10:00:00")}, nil)
+	path := writeJPEGWithExif(t, solidImage(8, 8), tiff)
+
+	x, err := image.ReadExif(path)
+	if err != nil {
+		t.Fatalf("read exif: %v", err)
+	}
+
+	want := time.Date(2019, 5, 4, 10, 0, 0, 0, time.Local)
+	if !x.DateTimeOriginal.Equal(want) {
+		t.Errorf("DateTimeOriginal = %v, want %v", x.DateTimeOriginal, want)
+	}
+}
+
+func TestReadExif_NoExif(t *testing.T) {
+	if _, err := image.ReadExif(filepath.Join(testdataDir, "app.gif")); err != image.ErrNoExif {
+		t.Errorf("expected ErrNoExif for GIF, got %v", err)
+	}
+}
+
+func TestReadExif_CameraAndGPS(t *testing.T) {
+	tiff := buildTIFFWithGPS(
+		[]exifTag{asciiTag(0x010F, "Canon"), asciiTag(0x0110, "Canon EOS R6")},
+		nil,
+		[]exifTag{
+			asciiTag(0x0001, "N"),
+			rationalTag(0x0002, [2]uint32{38, 1}, [2]uint32{42, 1}, [2]uint32{3600, 100}),
+			asciiTag(0x0003, "W"),
+			rationalTag(0x0004, [2]uint32{9, 1}, [2]uint32{9, 1}, [2]uint32{0, 1}),
+		},
+	)
+	path := writeJPEGWithExif(t, solidImage(8, 8), tiff)
+
+	x, err := image.ReadExif(path)
+	if err != nil {
+		t.Fatalf("read exif: %v", err)
+	}
+
+	if got := x.Camera(); got != "Canon EOS R6" {
+		t.Errorf("Camera() = %q, want %q", got, "Canon EOS R6")
+	}
+	if !x.HasGPS {
+		t.Fatal("expected GPS position")
+	}
+	if math.Abs(x.Latitude-38.71) > 1e-9 || math.Abs(x.Longitude+9.15) > 1e-9 {
+		t.Errorf("position = %v, %v, want 38.71, -9.15", x.Latitude, x.Longitude)
+	}
+}
@@ -0,0 +1,159 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "golang.org/x/image/tiff" // register TIFF decoder
+)
+
+// ErrUnsupportedFormat is returned for files whose extension has no
+// registered decoder.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// Decoder decodes the image file at path.
+type Decoder func(path string) (image.Image, error)
+
+// decoders is the registry of the file extensions LocalLens indexes and
+// browses, each with the decoder for its files.
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		".jpg":  decodeFile,
+		".jpeg": decodeFile,
+		".png":  decodeFile,
+		".gif":  decodeFile,
+		".webp": decodeWebP,
+		".bmp":  decodeFile,
+		".tif":  decodeFile,
+		".tiff": decodeFile,
+
+		// TIFF-based camera RAWs: only their embedded preview is decoded.
+		".cr2": decodeRawPreview,
+		".nef": decodeRawPreview,
+		".nrw": decodeRawPreview,
+		".arw": decodeRawPreview,
+		".dng": decodeRawPreview,
+		".pef": decodeRawPreview,
+		".srw": decodeRawPreview,
+	}
+)
+
+// IsSupported reports whether files with extension ext, such as ".JPG", can
+// be decoded.
+func IsSupported(ext string) bool {
+	_, ok := decoderFor(ext)
+	return ok
+}
+
+// Extensions returns the supported file extensions, sorted.
+func Extensions() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	exts := make([]string, 0, len(decoders))
+	for ext := range decoders {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// RegisterDecoder makes files with extension ext decodable by d, replacing
+// the built-in decoder if there is one. It is the hook for containers that
+// can't be decoded in pure Go, such as HEIC; see NewCommandDecoder.
+func RegisterDecoder(ext string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[normalizeExt(ext)] = d
+}
+
+func decoderFor(ext string) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	d, ok := decoders[normalizeExt(ext)]
+	return d, ok
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// decodeFile decodes any format registered with the standard image package,
+// whatever the file extension says, once its header shows it is within the
+// pixel limit.
+func decodeFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if err := checkPixels(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek: %w", err)
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return img, nil
+}
+
+// commandTimeout bounds an external decoder run.
+const commandTimeout = 2 * time.Minute
+
+// NewCommandDecoder returns a Decoder that runs an external program to
+// convert a file to PNG, then decodes the PNG. args is the command line, in
+// which "{in}" is replaced by the source path and "{out}" by the temporary
+// PNG path, e.g. ["heif-convert", "{in}", "{out}"].
+func NewCommandDecoder(args []string) (Decoder, error) {
+	joined := strings.Join(args, " ")
+	if len(args) == 0 || !strings.Contains(joined, "{in}") || !strings.Contains(joined, "{out}") {
+		return nil, fmt.Errorf("external decoder %q: needs a command with {in} and {out}", joined)
+	}
+
+	return func(path string) (image.Image, error) {
+		out, err := os.CreateTemp("", "locallens-decode-*.png")
+		if err != nil {
+			return nil, fmt.Errorf("create temp file: %w", err)
+		}
+		out.Close()
+		defer os.Remove(out.Name())
+
+		argv := make([]string, len(args))
+		for i, a := range args {
+			a = strings.ReplaceAll(a, "{in}", path)
+			argv[i] = strings.ReplaceAll(a, "{out}", out.Name())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+		if output, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("external decoder %s: %w: %s", filepath.Base(argv[0]), err, strings.TrimSpace(string(output)))
+		}
+
+		return decodeFile(out.Name())
+	}, nil
+}
@@ -0,0 +1,191 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/tiff"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func longTag(tag uint16, vals ...uint32) exifTag {
+	var v []byte
+	for _, x := range vals {
+		v = binary.LittleEndian.AppendUint32(v, x)
+	}
+	return exifTag{tag: tag, typ: 4, count: uint32(len(vals)), value: v}
+}
+
+// payloadAt is where buildTIFFFile stores the payload.
+const payloadAt = 8
+
+// buildTIFFFile returns a little-endian TIFF file with payload right after
+// the header, at payloadAt, followed by one IFD with tags.
+func buildTIFFFile(payload []byte, tags []exifTag) []byte {
+	le := binary.LittleEndian
+	ifdAt := uint32(payloadAt + len(payload))
+	ifdAt += ifdAt & 1 // word aligned
+	dataAt := ifdAt + uint32(2+len(tags)*12+4)
+
+	buf := []byte("II")
+	buf = le.AppendUint16(buf, 42)
+	buf = le.AppendUint32(buf, ifdAt)
+	buf = append(buf, payload...)
+	if len(buf)%2 == 1 {
+		buf = append(buf, 0)
+	}
+
+	var data []byte
+	buf = le.AppendUint16(buf, uint16(len(tags)))
+	for _, t := range tags {
+		buf = le.AppendUint16(buf, t.tag)
+		buf = le.AppendUint16(buf, t.typ)
+		buf = le.AppendUint32(buf, t.count)
+		if len(t.value) <= 4 {
+			v := make([]byte, 4)
+			copy(v, t.value)
+			buf = append(buf, v...)
+			continue
+		}
+		buf = le.AppendUint32(buf, dataAt+uint32(len(data)))
+		data = append(data, t.value...)
+	}
+	buf = le.AppendUint32(buf, 0)
+	return append(buf, data...)
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, solidImage(w, h), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestResize_TIFF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, solidImage(12, 8), &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		t.Fatalf("encode tiff: %v", err)
+	}
+	path := writeFile(t, "scan.tif", buf.Bytes())
+
+	out, err := image.Resize(path, maxSideDefault)
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 12 || b.Dy() != 8 {
+		t.Errorf("expected 12x8, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestResize_RawPreview(t *testing.T) {
+	preview := encodeJPEG(t, 64, 32)
+	thumb := encodeJPEG(t, 8, 4)
+	payload := append(append([]byte{}, preview...), thumb...)
+
+	// Like a CR2: the preview as an old-style JPEG strip, and a thumbnail.
+	data := buildTIFFFile(payload, []exifTag{
+		longTag(256, 64),
+		longTag(257, 32),
+		shortTag(259, 6),
+		longTag(273, payloadAt),
+		longTag(279, uint32(len(preview))),
+		longTag(0x0201, payloadAt+uint32(len(preview))),
+		longTag(0x0202, uint32(len(thumb))),
+	})
+	path := writeFile(t, "photo.CR2", data)
+
+	out, err := image.Resize(path, maxSideDefault)
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("expected the 64x32 preview, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestResize_RawWithoutPreview(t *testing.T) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, solidImage(2, 2), nil); err != nil {
+		t.Fatalf("encode tiff: %v", err)
+	}
+	path := writeFile(t, "photo.nef", buf.Bytes())
+	if _, err := image.Resize(path, maxSideDefault); err == nil {
+		t.Error("expected an error for a raw file without preview")
+	}
+}
+
+func TestIsSupported(t *testing.T) {
+	for _, ext := range []string{".jpg", ".JPEG", ".tif", ".tiff", ".CR2", ".nef", ".arw", ".dng"} {
+		if !image.IsSupported(ext) {
+			t.Errorf("expected %s to be supported", ext)
+		}
+	}
+	for _, ext := range []string{".txt", ".mp4", ""} {
+		if image.IsSupported(ext) {
+			t.Errorf("expected %q not to be supported", ext)
+		}
+	}
+}
+
+func TestCommandDecoder(t *testing.T) {
+	cp, err := exec.LookPath("cp")
+	if err != nil {
+		t.Skip("no cp command")
+	}
+
+	if _, err := image.NewCommandDecoder([]string{"heif-convert", "{in}"}); err == nil {
+		t.Error("expected an error for a command without {out}")
+	}
+
+	// A ".heicx" file that is really a PNG, "converted" by copying it.
+	d, err := image.NewCommandDecoder([]string{cp, "{in}", "{out}"})
+	if err != nil {
+		t.Fatalf("new command decoder: %v", err)
+	}
+	image.RegisterDecoder(".heicx", d)
+
+	var buf bytes.Buffer
+	png.Encode(&buf, solidImage(10, 6))
+	path := writeFile(t, "photo.heicx", buf.Bytes())
+
+	if !image.IsSupported(".HEICX") {
+		t.Error("expected a registered extension to be supported")
+	}
+	out, err := image.Resize(path, maxSideDefault)
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 6 {
+		t.Errorf("expected 10x6, got %dx%d", b.Dx(), b.Dy())
+	}
+}
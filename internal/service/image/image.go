@@ -3,13 +3,15 @@ package image
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	_ "image/gif" // register GIF decoder
 	"image/jpeg"
 	_ "image/png" // register PNG decoder
-
-	"os"
+	"io/fs"
+	"path/filepath"
 
 	_ "golang.org/x/image/bmp"  // register BMP decoder
 	_ "golang.org/x/image/webp" // register WebP decoder
@@ -17,51 +19,151 @@ import (
 	"golang.org/x/image/draw"
 )
 
-const DefaultQuality = 90 //TODO: investigate better this parameter and see if the config value is being used or not.
+// DefaultQuality is the JPEG quality Resize encodes with when none is
+// configured. Lower qualities blur the small text the vision model reads.
+const DefaultQuality = 100
+
+// Options control how ResizeWith prepares an image for vision inference.
+type Options struct {
+	// MaxSide is the longest side the image is scaled down to.
+	MaxSide int
+	// Background is the color transparent areas are flattened onto, since
+	// JPEG has no alpha. Nil means white.
+	Background color.Color
+	// Quality is the JPEG quality, from 1 to 100. 0 means DefaultQuality.
+	Quality int
+}
 
 // Resize loads an image, resizes it for vision inference, and returns JPEG bytes.
 // If the image is already small enough, it still re-encodes to JPEG for consistency.
+// The image is turned upright first, following its EXIF orientation.
 func Resize(srcPath string, maxSide int) ([]byte, error) {
-	img, err := load(srcPath)
+	return ResizeWith(srcPath, Options{MaxSide: maxSide})
+}
+
+// ResizeWith is Resize with the background and JPEG quality of opts.
+//
+// Whatever the input (paletted, grayscale, 16-bit, with or without alpha),
+// the output is 8-bit sRGB: transparency is flattened onto the background
+// and PNG files declaring another gamma are re-encoded to the sRGB curve.
+// Embedded ICC profiles are not applied.
+func ResizeWith(srcPath string, opts Options) ([]byte, error) {
+	src, err := Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	return src.Encode(opts)
+}
+
+// Source is a decoded image, turned upright, that can be encoded for vision
+// inference whole or one region at a time without decoding it again.
+type Source struct {
+	img      image.Image
+	gamma    float64
+	hasGamma bool
+}
+
+// Open decodes the image at path and turns it upright, following its EXIF
+// orientation. Decoding stays within the limits set by SetLimits.
+func Open(path string) (*Source, error) {
+	return guard(path, func() (*Source, error) { return open(path) })
+}
+
+// IsIOError reports whether err, returned by Open, ResizeWith or
+// SampleFrames, is the file failing to read, such as EIO or ESTALE from a
+// network share, rather than its content failing to decode. Unlike a decode
+// error, it may not happen again.
+func IsIOError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
+func open(path string) (*Source, error) {
+	img, err := load(path)
 	if err != nil {
 		return nil, err
 	}
 
-	bounds := img.Bounds()
-	w := bounds.Dx()
-	h := bounds.Dy()
-
-	// Scale down if needed
-	if w > maxSide || h > maxSide {
-		w, h = scaleDimensions(w, h, maxSide)
-		dst := image.NewRGBA(image.Rect(0, 0, w, h))
-		// TODO: handle the alpha channel of images for non jpeg
-		//	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)  // fill white first
-		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
-		img = dst
+	// EXIF is optional; without it the image is used as stored.
+	if x, err := ReadExif(path); err == nil {
+		img = Orient(img, x.Orientation)
+	}
+
+	gamma, hasGamma := pngGamma(path)
+	return &Source{img: img, gamma: gamma, hasGamma: hasGamma}, nil
+}
+
+// Size returns the width and height of the upright image.
+func (s *Source) Size() image.Point {
+	return s.img.Bounds().Size()
+}
+
+// Encode scales the whole image down to opts.MaxSide and encodes it as JPEG,
+// like ResizeWith.
+func (s *Source) Encode(opts Options) ([]byte, error) {
+	return s.EncodeRegion(image.Rectangle{Max: s.Size()}, opts)
+}
+
+// EncodeRegion is Encode for the part r of the image, in coordinates of the
+// upright image with the origin at its top left corner.
+func (s *Source) EncodeRegion(r image.Rectangle, opts Options) ([]byte, error) {
+	bounds := r.Add(s.img.Bounds().Min).Intersect(s.img.Bounds())
+	if bounds.Empty() {
+		return nil, fmt.Errorf("region %v outside image %v", r, s.Size())
+	}
+
+	bg := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	if opts.Background != nil {
+		bg = color.RGBAModel.Convert(opts.Background).(color.RGBA)
+		bg.A = 255
+	}
+	if s.hasGamma {
+		bg = fromSRGB(bg, s.gamma)
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > opts.MaxSide || h > opts.MaxSide {
+		w, h = scaleDimensions(w, h, opts.MaxSide)
+	}
+
+	// Drawing over an opaque background flattens any alpha and converts
+	// every color model to 8-bit RGB in one pass.
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	if w != bounds.Dx() || h != bounds.Dy() {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), s.img, bounds, draw.Over, nil)
+	} else {
+		draw.Draw(dst, dst.Bounds(), s.img, bounds.Min, draw.Over)
+	}
+
+	if s.hasGamma {
+		toSRGB(dst, s.gamma)
+	}
+
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = DefaultQuality
 	}
 
 	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
 		return nil, fmt.Errorf("encode jpeg: %w", err)
 	}
 
 	return buf.Bytes(), nil
 }
 
+// load decodes the image at path with the decoder registered for its
+// extension. Unknown extensions are decoded by content.
 func load(path string) (image.Image, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("open: %w", err)
+	if err := checkFile(path); err != nil {
+		return nil, err
 	}
-	defer file.Close()
-
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, fmt.Errorf("decode: %w", err)
+	decode, ok := decoderFor(filepath.Ext(path))
+	if !ok {
+		decode = decodeFile
 	}
-
-	return img, nil
+	return decode(path)
 }
 
 func scaleDimensions(w, h, maxSide int) (int, int) {
@@ -2,7 +2,13 @@ package image_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	goimage "image"
+	"image/color"
 	"image/jpeg"
+	"image/png"
 	"os"
 	"path/filepath"
 	"testing"
@@ -114,3 +120,263 @@ func TestResize_InvalidImage(t *testing.T) {
 		t.Error("expected error for invalid image")
 	}
 }
+
+// markedImage returns a 40x20 blue image with a red block in its top-left
+// corner and a green one in its top-right corner, so every orientation moves
+// them somewhere distinct.
+func markedImage() goimage.Image {
+	img := goimage.NewRGBA(goimage.Rect(0, 0, 40, 20))
+	for y := range 20 {
+		for x := range 40 {
+			c := color.RGBA{B: 255, A: 255}
+			switch {
+			case x < 10 && y < 10:
+				c = color.RGBA{R: 255, A: 255}
+			case x >= 30 && y < 10:
+				c = color.RGBA{G: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// dominant names the strongest channel of c.
+func dominant(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	switch {
+	case r > g && r > b:
+		return "red"
+	case g > r && g > b:
+		return "green"
+	}
+	return "blue"
+}
+
+func TestResize_Orientation(t *testing.T) {
+	tests := []struct {
+		orientation   int
+		width, height int
+		red, green    string // corners the markers are displayed in
+	}{
+		{image.OrientNormal, 40, 20, "top-left", "top-right"},
+		{image.OrientFlipH, 40, 20, "top-right", "top-left"},
+		{image.OrientRotate180, 40, 20, "bottom-right", "bottom-left"},
+		{image.OrientFlipV, 40, 20, "bottom-left", "bottom-right"},
+		{image.OrientTranspose, 20, 40, "top-left", "bottom-left"},
+		{image.OrientRotate90, 20, 40, "top-right", "bottom-right"},
+		{image.OrientTransverse, 20, 40, "bottom-right", "top-right"},
+		{image.OrientRotate270, 20, 40, "bottom-left", "top-left"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("orientation %d", tt.orientation), func(t *testing.T) {
+			tiff := buildTIFF([]exifTag{shortTag(0x0112, uint16(tt.orientation))}, nil)
+			path := writeJPEGWithExif(t, markedImage(), tiff)
+
+			data, err := image.Resize(path, maxSideDefault)
+			if err != nil {
+				t.Fatalf("resize: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+
+			b := img.Bounds()
+			if b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Fatalf("expected %dx%d, got %dx%d", tt.width, tt.height, b.Dx(), b.Dy())
+			}
+
+			corners := map[string]goimage.Point{
+				"top-left":     {5, 5},
+				"top-right":    {b.Dx() - 5, 5},
+				"bottom-left":  {5, b.Dy() - 5},
+				"bottom-right": {b.Dx() - 5, b.Dy() - 5},
+			}
+			for name, p := range corners {
+				want := "blue"
+				switch name {
+				case tt.red:
+					want = "red"
+				case tt.green:
+					want = "green"
+				}
+				if got := dominant(img.At(p.X, p.Y)); got != want {
+					t.Errorf("%s corner: expected %s, got %s", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+// writePNG encodes img as PNG, inserting extra chunks right after IHDR, and
+// returns the written file path.
+func writePNG(t *testing.T, img goimage.Image, chunks ...[]byte) string {
+	t.Helper()
+
+	var enc bytes.Buffer
+	if err := png.Encode(&enc, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	raw := enc.Bytes()
+
+	const ihdrEnd = 8 + 8 + 13 + 4 // signature, IHDR header, data and CRC
+	out := append([]byte{}, raw[:ihdrEnd]...)
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	out = append(out, raw[ihdrEnd:]...)
+
+	path := filepath.Join(t.TempDir(), "test.png")
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+// pngChunk builds a PNG chunk with its length and CRC.
+func pngChunk(typ string, data []byte) []byte {
+	c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	c = append(c, typ...)
+	c = append(c, data...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+// centerColor resizes the image at path with opts and returns the color at
+// the center of the output.
+func centerColor(t *testing.T, path string, opts image.Options) color.RGBA {
+	t.Helper()
+
+	data, err := image.ResizeWith(path, opts)
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	b := img.Bounds()
+	return color.RGBAModel.Convert(img.At(b.Dx()/2, b.Dy()/2)).(color.RGBA)
+}
+
+// near reports whether two colors are within JPEG rounding of each other.
+func near(a, b color.RGBA) bool {
+	d := func(x, y uint8) bool { return max(x, y)-min(x, y) < 6 }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B)
+}
+
+func TestResize_FlattensTransparency(t *testing.T) {
+	transparent := goimage.NewNRGBA(goimage.Rect(0, 0, 16, 16))
+	path := writePNG(t, transparent)
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 512}); !near(got, white) {
+		t.Errorf("expected white by default, got %v", got)
+	}
+
+	green := color.RGBA{G: 128, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 8, Background: green}); !near(got, green) {
+		t.Errorf("expected the configured background when scaling, got %v", got)
+	}
+}
+
+func TestResize_PalettedTransparency(t *testing.T) {
+	pal := color.Palette{color.RGBA{}, color.RGBA{R: 255, A: 255}}
+	img := goimage.NewPaletted(goimage.Rect(0, 0, 16, 16), pal) // all index 0, transparent
+
+	path := writePNG(t, img)
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 512}); !near(got, white) {
+		t.Errorf("expected transparent palette entries on white, got %v", got)
+	}
+}
+
+func TestResize_16Bit(t *testing.T) {
+	img := goimage.NewNRGBA64(goimage.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.SetNRGBA64(x, y, color.NRGBA64{R: 0xFFFF, G: 0x8080, A: 0xFFFF})
+		}
+	}
+
+	path := writePNG(t, img)
+	want := color.RGBA{R: 255, G: 128, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 512}); !near(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestResize_PNGGamma(t *testing.T) {
+	img := goimage.NewNRGBA(goimage.Rect(0, 0, 16, 16))
+	for y := range 16 {
+		for x := range 16 {
+			img.SetNRGBA(x, y, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+		}
+	}
+
+	// Linear samples (gamma 1.0): mid-gray light is about 186 in sRGB.
+	path := writePNG(t, img, pngChunk("gAMA", binary.BigEndian.AppendUint32(nil, 100000)))
+	want := color.RGBA{R: 186, G: 186, B: 186, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 512}); !near(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	// sRGB gamma leaves samples as they are.
+	path = writePNG(t, img, pngChunk("gAMA", binary.BigEndian.AppendUint32(nil, 45455)))
+	want = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	if got := centerColor(t, path, image.Options{MaxSide: 512}); !near(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+		ok   bool
+	}{
+		{"#ffffff", color.RGBA{R: 255, G: 255, B: 255, A: 255}, true},
+		{"00ff80", color.RGBA{G: 255, B: 128, A: 255}, true},
+		{"#f00", color.RGBA{R: 255, A: 255}, true},
+		{"white", color.RGBA{}, false},
+		{"#12345", color.RGBA{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := image.ParseColor(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseColor(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSource_EncodeRegion(t *testing.T) {
+	src, err := image.Open(writePNG(t, markedImage()))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if size := src.Size(); size.X != 40 || size.Y != 20 {
+		t.Fatalf("expected 40x20, got %v", size)
+	}
+
+	// The top right corner holds the green marker.
+	data, err := src.EncodeRegion(goimage.Rect(30, 0, 40, 10), image.Options{MaxSide: maxSideDefault})
+	if err != nil {
+		t.Fatalf("encode region: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Errorf("expected 10x10, got %dx%d", b.Dx(), b.Dy())
+	}
+	if got := dominant(img.At(5, 5)); got != "green" {
+		t.Errorf("expected green, got %s", got)
+	}
+
+	if _, err := src.EncodeRegion(goimage.Rect(50, 50, 60, 60), image.Options{MaxSide: maxSideDefault}); err == nil {
+		t.Error("expected an error for a region outside the image")
+	}
+}
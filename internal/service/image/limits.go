@@ -0,0 +1,246 @@
+package image
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooLarge is returned for files over the byte limit and images over
+	// the pixel limit, before they are decoded. See SetLimits.
+	ErrTooLarge = errors.New("image too large")
+	// ErrMalformed is returned when decoding an image panics.
+	ErrMalformed = errors.New("malformed image")
+	// ErrDecodeTimeout is returned when decoding an image runs past the
+	// decode timeout. Crafted files do, but so do large ones on a loaded
+	// machine, so unlike ErrMalformed it may not happen again.
+	ErrDecodeTimeout = errors.New("image decode timed out")
+	// ErrBusy is returned when no decode slot frees up within the decode
+	// timeout, because every slot is held by a decode still running past
+	// its own.
+	ErrBusy = errors.New("image decoders busy")
+)
+
+// Limits bound what decoding one image file may cost, so a decompression
+// bomb or a crafted file fails on its own instead of exhausting memory or
+// crashing the process. Zero fields impose no limit.
+type Limits struct {
+	// MaxPixels caps the width times height of an image, read from its
+	// header before it is decoded. For animations it caps the canvas and
+	// the frames together.
+	MaxPixels int64
+	// MaxBytes caps the size of an image file.
+	MaxBytes int64
+	// Timeout caps the time a caller waits for an image to decode. It
+	// doesn't stop the decode: Go can't interrupt a decoder, so one past
+	// its timeout keeps running in the background, holding its memory and
+	// its decode slot until it finishes.
+	Timeout time.Duration
+	// MaxDecodes caps the decodes running at once, including those past
+	// their timeout, so abandoned decodes can't pile up.
+	MaxDecodes int
+}
+
+var (
+	limitsMu sync.RWMutex
+	limits   Limits
+	slots    chan struct{} // one per running decode, nil for no limit
+)
+
+// SetLimits sets the limits Open and SampleFrames decode images within.
+// Decodes already running keep the slots they hold.
+func SetLimits(l Limits) {
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	limits = l
+	slots = nil
+	if l.MaxDecodes > 0 {
+		slots = make(chan struct{}, l.MaxDecodes)
+	}
+}
+
+func currentLimits() Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return limits
+}
+
+func currentSlots() chan struct{} {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return slots
+}
+
+// checkFile returns ErrTooLarge if the file at path is over the byte limit.
+func checkFile(path string) error {
+	limit := currentLimits().MaxBytes
+	if limit <= 0 {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	if fi.Size() > limit {
+		return fmt.Errorf("%w: %d bytes, over the limit of %d", ErrTooLarge, fi.Size(), limit)
+	}
+	return nil
+}
+
+// checkPixels returns ErrTooLarge if a w×h image is over the pixel limit.
+func checkPixels(w, h int) error {
+	limit := currentLimits().MaxPixels
+	if limit <= 0 || int64(w)*int64(h) <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: %dx%d, over the limit of %d pixels", ErrTooLarge, w, h, limit)
+}
+
+// checkFramePixels returns ErrTooLarge if the frames of an animation, of
+// pixels together, are over the pixel limit.
+func checkFramePixels(pixels int64) error {
+	limit := currentLimits().MaxPixels
+	if limit <= 0 || pixels <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: frames of %d pixels together, over the limit of %d", ErrTooLarge, pixels, limit)
+}
+
+// guard runs decode in a decode slot within the decode timeout, turning a
+// panic into ErrMalformed. Waiting for a slot has a timeout of its own. A
+// decode past its timeout isn't stopped: guard returns ErrDecodeTimeout and the
+// decode finishes in the background, within the pixel limit, keeping its
+// slot until then.
+func guard[T any](path string, decode func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	var zero T
+	d := currentLimits().Timeout
+
+	slots := currentSlots()
+	if slots != nil {
+		wait, stop := after(d)
+		select {
+		case slots <- struct{}{}:
+			stop()
+		case <-wait:
+			return zero, fmt.Errorf("%w: %s: no decode slot free within %s", ErrBusy, path, d)
+		}
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if slots != nil {
+				<-slots
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{err: fmt.Errorf("%w: %s: decoder panic: %v", ErrMalformed, path, r)}
+			}
+		}()
+		v, err := decode()
+		done <- result{v, err}
+	}()
+
+	timeout, stop := after(d)
+	defer stop()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timeout:
+		return zero, fmt.Errorf("%w: %s: decoding took over %s", ErrDecodeTimeout, path, d)
+	}
+}
+
+// after returns a channel that receives once d has passed, never for d <= 0,
+// and a func releasing its timer.
+func after(d time.Duration) (<-chan time.Time, func()) {
+	if d <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(d)
+	return timer.C, func() { timer.Stop() }
+}
+
+// gifPixels returns the canvas size of a GIF file and the pixels of all its
+// frames together, read from their descriptors without decoding them.
+func gifPixels(r io.Reader) (image.Point, int64, error) {
+	br := bufio.NewReader(r)
+	var header [13]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return image.Point{}, 0, err
+	}
+	canvas := image.Pt(int(binary.LittleEndian.Uint16(header[6:8])), int(binary.LittleEndian.Uint16(header[8:10])))
+	if err := skipColorTable(br, header[10]); err != nil {
+		return image.Point{}, 0, err
+	}
+
+	var total int64
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return image.Point{}, 0, err
+		}
+		switch block {
+		case 0x21: // extension: label, then data sub-blocks
+			if _, err := br.ReadByte(); err != nil {
+				return image.Point{}, 0, err
+			}
+		case 0x2C: // image descriptor, then LZW minimum code size and data
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return image.Point{}, 0, err
+			}
+			w, h := binary.LittleEndian.Uint16(desc[4:6]), binary.LittleEndian.Uint16(desc[6:8])
+			total += int64(w) * int64(h)
+			if err := skipColorTable(br, desc[8]); err != nil {
+				return image.Point{}, 0, err
+			}
+			if _, err := br.ReadByte(); err != nil {
+				return image.Point{}, 0, err
+			}
+		case 0x3B: // trailer
+			return canvas, total, nil
+		default:
+			return image.Point{}, 0, fmt.Errorf("gif: unknown block 0x%02x", block)
+		}
+		if err := skipSubBlocks(br); err != nil {
+			return image.Point{}, 0, err
+		}
+	}
+}
+
+// skipColorTable skips the color table that GIF flags say follows.
+func skipColorTable(br *bufio.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << (flags&0x07 + 1))
+	return err
+}
+
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		n, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+}
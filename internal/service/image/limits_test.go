@@ -0,0 +1,131 @@
+package image_test
+
+import (
+	"encoding/binary"
+	"errors"
+	goimage "image"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// setLimits sets l for the duration of the test.
+func setLimits(t *testing.T, l image.Limits) {
+	t.Helper()
+	image.SetLimits(l)
+	t.Cleanup(func() { image.SetLimits(image.Limits{}) })
+}
+
+// bombPNG returns a PNG file whose header claims width×height pixels, with
+// no image data behind it.
+func bombPNG(t *testing.T, width, height uint32) string {
+	t.Helper()
+	ihdr := binary.BigEndian.AppendUint32(nil, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8-bit RGBA
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = append(data, pngChunk("IHDR", ihdr)...)
+	data = append(data, pngChunk("IEND", nil)...)
+	return writeFile(t, "bomb.png", data)
+}
+
+func TestOpen_Limits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits image.Limits
+		path   func(t *testing.T) string
+	}{
+		{"decompression bomb", image.Limits{MaxPixels: 250_000_000}, func(t *testing.T) string { return bombPNG(t, 60000, 60000) }},
+		{"over pixels", image.Limits{MaxPixels: 99}, func(t *testing.T) string { return writePNG(t, solidImage(10, 10)) }},
+		{"over bytes", image.Limits{MaxBytes: 10}, func(t *testing.T) string { return writePNG(t, solidImage(10, 10)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLimits(t, tt.limits)
+			_, err := image.Open(tt.path(t))
+			if !errors.Is(err, image.ErrTooLarge) {
+				t.Errorf("expected ErrTooLarge, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpen_WithinLimits(t *testing.T) {
+	setLimits(t, image.Limits{MaxPixels: 100, MaxBytes: 1 << 20, Timeout: time.Minute})
+	src, err := image.Open(writePNG(t, solidImage(10, 10)))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if size := src.Size(); size != goimage.Pt(10, 10) {
+		t.Errorf("expected 10x10, got %v", size)
+	}
+}
+
+func TestSampleFrames_Limits(t *testing.T) {
+	// Five 8x8 frames: the canvas fits, the frames together don't.
+	path := solidFrames(t, red, red, blue, blue, green)
+	setLimits(t, image.Limits{MaxPixels: 300})
+
+	if _, _, err := image.SampleFrames(path, 4, image.SampleScene); !errors.Is(err, image.ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestOpen_DecoderPanic(t *testing.T) {
+	image.RegisterDecoder(".panics", func(string) (goimage.Image, error) {
+		panic("corrupt frame table")
+	})
+
+	_, err := image.Open(writeFile(t, "crafted.panics", []byte("x")))
+	if !errors.Is(err, image.ErrMalformed) {
+		t.Errorf("expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestOpen_DecodeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	image.RegisterDecoder(".hangs", func(string) (goimage.Image, error) {
+		<-release
+		return nil, errors.New("released")
+	})
+	setLimits(t, image.Limits{Timeout: 10 * time.Millisecond})
+
+	_, err := image.Open(writeFile(t, "crafted.hangs", []byte("x")))
+	if !errors.Is(err, image.ErrDecodeTimeout) {
+		t.Errorf("expected ErrDecodeTimeout, got %v", err)
+	}
+}
+
+func TestOpen_DecodeSlots(t *testing.T) {
+	release := make(chan struct{})
+	image.RegisterDecoder(".stalls", func(string) (goimage.Image, error) {
+		<-release
+		return nil, errors.New("released")
+	})
+	setLimits(t, image.Limits{Timeout: 10 * time.Millisecond, MaxDecodes: 1})
+	path := writeFile(t, "crafted.stalls", []byte("x"))
+
+	if _, err := image.Open(path); !errors.Is(err, image.ErrDecodeTimeout) {
+		t.Fatalf("expected ErrDecodeTimeout, got %v", err)
+	}
+	// The abandoned decode still holds the only slot.
+	if _, err := image.Open(writePNG(t, solidImage(10, 10))); !errors.Is(err, image.ErrBusy) {
+		t.Fatalf("expected ErrBusy, got %v", err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := image.Open(writePNG(t, solidImage(10, 10)))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, image.ErrBusy) || time.Now().After(deadline) {
+			t.Fatalf("expected the slot to free up, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// EXIF orientation values: how the stored pixels are transformed for
+// display.
+const (
+	OrientNormal     = 1 // as stored
+	OrientFlipH      = 2 // mirrored left to right
+	OrientRotate180  = 3
+	OrientFlipV      = 4 // mirrored top to bottom
+	OrientTranspose  = 5 // mirrored along the top-left to bottom-right diagonal
+	OrientRotate90   = 6 // rotated 90° clockwise
+	OrientTransverse = 7 // mirrored along the top-right to bottom-left diagonal
+	OrientRotate270  = 8 // rotated 90° counterclockwise
+)
+
+// Orient returns img transformed as the EXIF orientation o says it should be
+// displayed, so a phone photo stored sideways comes out upright. Unknown
+// values and OrientNormal return img unchanged.
+func Orient(img image.Image, o int) image.Image {
+	if o <= OrientNormal || o > OrientRotate270 {
+		return img
+	}
+
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= OrientTranspose {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch o {
+			case OrientFlipH:
+				dx, dy = w-1-x, y
+			case OrientRotate180:
+				dx, dy = w-1-x, h-1-y
+			case OrientFlipV:
+				dx, dy = x, h-1-y
+			case OrientTranspose:
+				dx, dy = y, x
+			case OrientRotate90:
+				dx, dy = h-1-y, x
+			case OrientTransverse:
+				dx, dy = h-1-y, w-1-x
+			case OrientRotate270:
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
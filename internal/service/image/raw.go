@@ -0,0 +1,125 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"sort"
+)
+
+// ErrNoPreview is returned for RAW files without an embedded JPEG preview
+// the decoder can read.
+var ErrNoPreview = errors.New("no embedded preview")
+
+// Tags that locate embedded JPEG previews in TIFF-based RAW files.
+const (
+	tagCompression           = 0x0103
+	tagStripOffsets          = 0x0111
+	tagStripByteCounts       = 0x0117
+	tagSubIFDs               = 0x014A
+	tagJPEGInterchange       = 0x0201
+	tagJPEGInterchangeLength = 0x0202
+)
+
+// Compression values of JPEG-compressed TIFF images.
+const (
+	tiffOldJPEG = 6
+	tiffJPEG    = 7
+)
+
+// maxIFDs bounds the IFDs walked in a RAW file, so a file whose IFDs link
+// in a cycle can't loop forever.
+const maxIFDs = 64
+
+// decodeRawPreview decodes the largest embedded JPEG preview of a
+// TIFF-based camera RAW file (CR2, NEF, ARW, DNG and the like). The sensor
+// data itself is never decoded: the preview is what the camera rendered, and
+// is plenty for a vision model.
+func decodeRawPreview(path string) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open: %w", err)
+	}
+
+	t, err := newTIFF(data)
+	if err != nil {
+		return nil, fmt.Errorf("raw: %w", err)
+	}
+
+	previews := rawPreviews(t)
+	sort.Slice(previews, func(i, j int) bool { return len(previews[i]) > len(previews[j]) })
+
+	// Lossless JPEG sensor data looks like a preview too, but the standard
+	// decoder rejects it, so the next largest is tried.
+	for _, p := range previews {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(p))
+		if err != nil {
+			continue
+		}
+		if err := checkPixels(cfg.Width, cfg.Height); err != nil {
+			return nil, err
+		}
+		if img, err := jpeg.Decode(bytes.NewReader(p)); err == nil {
+			return img, nil
+		}
+	}
+	return nil, ErrNoPreview
+}
+
+// rawPreviews returns the JPEG streams referenced from any IFD of t: the
+// IFD chain, and the SubIFDs they point to.
+func rawPreviews(t tiffReader) [][]byte {
+	var previews [][]byte
+	seen := make(map[uint32]bool)
+	queue := []uint32{t.first()}
+
+	for len(queue) > 0 && len(seen) < maxIFDs {
+		off := queue[0]
+		queue = queue[1:]
+		if off == 0 || seen[off] {
+			continue
+		}
+		seen[off] = true
+
+		ifd, err := t.ifd(off)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, t.next(off))
+		if e, ok := ifd[tagSubIFDs]; ok {
+			queue = append(queue, e.uints(t.order)...)
+		}
+
+		// A JPEG thumbnail or preview stored as an opaque blob.
+		if at, ok := ifd[tagJPEGInterchange]; ok {
+			if n, ok := ifd[tagJPEGInterchangeLength]; ok {
+				previews = appendJPEG(previews, t.data, at.uint(t.order), n.uint(t.order))
+			}
+		}
+
+		// A JPEG-compressed image in a single strip.
+		if c, ok := ifd[tagCompression]; ok {
+			if cmp := c.uint(t.order); cmp == tiffOldJPEG || cmp == tiffJPEG {
+				offsets := ifd[tagStripOffsets].uints(t.order)
+				counts := ifd[tagStripByteCounts].uints(t.order)
+				if len(offsets) == 1 && len(counts) == 1 {
+					previews = appendJPEG(previews, t.data, offsets[0], counts[0])
+				}
+			}
+		}
+	}
+	return previews
+}
+
+// appendJPEG appends data[off:off+n] to previews if it is in range and
+// starts like a JPEG stream.
+func appendJPEG(previews [][]byte, data []byte, off, n uint32) [][]byte {
+	end := uint64(off) + uint64(n)
+	if n < 2 || end > uint64(len(data)) || data[off] != 0xFF || data[off+1] != 0xD8 {
+		return previews
+	}
+	return append(previews, data[off:end])
+}
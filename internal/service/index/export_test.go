@@ -0,0 +1,14 @@
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+// SetLockTiming shortens the lock heartbeat and stale age for the duration
+// of a test.
+func SetLockTiming(t testing.TB, heartbeat, staleAfter time.Duration) {
+	oldHeartbeat, oldStaleAfter := lockHeartbeat, lockStaleAfter
+	lockHeartbeat, lockStaleAfter = heartbeat, staleAfter
+	t.Cleanup(func() { lockHeartbeat, lockStaleAfter = oldHeartbeat, oldStaleAfter })
+}
@@ -0,0 +1,75 @@
+package index
+
+import (
+	"time"
+)
+
+// Failure records an image of the folder that could not be indexed, so later
+// runs can decide whether to retry it instead of starting over blindly.
+type Failure struct {
+	Path        string
+	Class       string // kind of error, set by the caller
+	Error       string // last error message
+	Attempts    int
+	LastAttempt time.Time
+	// NextRetry is when the image may be tried again. Zero when Skipped.
+	NextRetry time.Time
+	// Skipped marks an image given up on: it is not retried automatically
+	// unless the file changes.
+	Skipped bool
+	// FileModTime and FileSize identify the file version that failed.
+	FileModTime time.Time
+	FileSize    int64
+}
+
+// Failure returns the failure recorded for path.
+func (idx *Index) Failure(path string) (Failure, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	f, ok := idx.failures[path]
+	return f, ok
+}
+
+// Failures returns every recorded failure.
+func (idx *Index) Failures() []Failure {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	failures := make([]Failure, 0, len(idx.failures))
+	for _, f := range idx.failures {
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// SetFailure records a failure, replacing the previous one for its path.
+func (idx *Index) SetFailure(f Failure) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.failures[f.Path] = f
+	idx.failuresDirty[f.Path] = true
+}
+
+// ClearFailure forgets the failure recorded for path, if any. Add clears it
+// too.
+func (idx *Index) ClearFailure(path string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.clearFailure(path)
+}
+
+func (idx *Index) clearFailure(path string) {
+	if _, ok := idx.failures[path]; !ok {
+		return
+	}
+	delete(idx.failures, path)
+	idx.failuresDirty[path] = false
+}
+
+// failureBytes estimates the memory held by f.
+func failureBytes(f Failure) int64 {
+	return int64(len(f.Path)+len(f.Class)+len(f.Error)) + 128
+}
@@ -3,12 +3,17 @@ package index
 
 import (
 	"sync"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
 )
 
 // ExpressionEmbedding is the embedding of a single image search expression.
 type ExpressionEmbedding struct {
 	Expression string
 	Vector     []float32
+	// Region is the part of the image the expression was found in, when
+	// the image was described in tiles. Nil for the whole image.
+	Region *search.Region
 }
 
 // Entry represents an indexed image with its description and one embedding
@@ -17,46 +22,191 @@ type Entry struct {
 	Path        string
 	Description string
 	Embeddings  []ExpressionEmbedding
+	// Rung is the step of the describe retry ladder the description was
+	// obtained at; 0 means the configured settings.
+	Rung int
+	// Quality scores the description from 0 to 1, and QualityIssues names
+	// what lowered it. Entries indexed before descriptions were scored have
+	// neither; see QualityScore.
+	Quality       float32
+	QualityIssues []string
+	// ContextHash identifies the folder context the image was described
+	// with; empty when its folder had none.
+	ContextHash string
+}
+
+// QualityScore returns the quality score of the description, taking entries
+// that were never scored as flawless.
+func (e Entry) QualityScore() float32 {
+	if e.Quality == 0 && len(e.QualityIssues) == 0 {
+		return 1
+	}
+	return e.Quality
 }
 
-// Index stores image embeddings.
+// Listener is notified after entries are added to or removed from an Index,
+// so secondary structures such as an ANN graph stay in sync without
+// rescanning it. Calls happen outside the index lock.
+type Listener interface {
+	EntryAdded(Entry)
+	EntryRemoved(path string)
+}
+
+// Index stores image embeddings. The vectors are kept once, as raw rows in a
+// search.Matrix along with the inverse norm of each row, for fast exact
+// scoring; the entries held next to it carry everything else, and Get puts
+// the vectors back on demand.
+//
+// Vectors can be stored at reduced precision (see SetQuantization). The
+// quantization is chosen per index and recorded in its Meta; the matrix
+// holds the vectors at that precision, in memory as on disk, and searches
+// score them directly.
+//
+// The vector dimension is fixed by the first entry added and recorded in the
+// Meta too, so callers can detect an embedder configured with another size.
+//
+// Entries are persisted through a Store. Load reads all of them into memory,
+// whichever the Store. Add and Remove only change memory; Save commits the
+// changes since the last Save in one transaction.
 type Index struct {
-	mu        sync.Mutex
-	entries   map[string]Entry // key: image path
-	indexPath string
+	mu           sync.Mutex
+	entries      map[string]Entry // key: image path; vectors are in matrix
+	dim          int
+	quantization search.Quantization
+	matrix       *search.Matrix
+	entryBytes   int64 // estimated memory held by entries, outside the matrix
+	store        Store
+	dirty        map[string]bool // path → true if added, false if removed since the last Save
+	listeners    []Listener
+
+	failures      map[string]Failure // key: image path
+	failuresDirty map[string]bool    // like dirty, for failures
 }
 
-// New creates an Index with the given storage path.
+// New creates an Index stored in the gob file at indexPath.
 func New(indexPath string) *Index {
+	return NewWithStore(NewGobStore(indexPath))
+}
+
+// NewWithStore creates an Index persisted through store.
+func NewWithStore(store Store) *Index {
 	return &Index{
-		entries:   make(map[string]Entry),
-		indexPath: indexPath,
+		entries:       make(map[string]Entry),
+		matrix:        search.NewMatrix(search.QuantizeNone),
+		store:         store,
+		dirty:         make(map[string]bool),
+		failures:      make(map[string]Failure),
+		failuresDirty: make(map[string]bool),
 	}
 }
 
-// Add adds or updates an entry in the index.
-func (idx *Index) Add(entry Entry) {
+// Dim returns the dimension of the vectors in the index, or 0 while it is
+// empty.
+func (idx *Index) Dim() int {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	idx.entries[entry.Path] = entry
+	return idx.dim
 }
 
-// Get retrieves an entry by path.
+// Quantization returns the precision the index stores its vectors at.
+func (idx *Index) Quantization() search.Quantization {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.quantization
+}
+
+// SetQuantization changes the precision vectors are stored at from the next
+// Save on, and rebuilds the matrix at that precision. Going back to a higher
+// precision doesn't restore what quantizing lost.
+func (idx *Index) SetQuantization(q search.Quantization) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if q == idx.quantization {
+		return
+	}
+	matrix := search.NewMatrix(q)
+	for _, e := range idx.entries {
+		matrix.Add(matrixEntry(idx.withVectors(e)))
+	}
+	idx.quantization = q
+	idx.matrix = matrix
+	for path := range idx.entries {
+		idx.dirty[path] = true
+	}
+}
+
+// Matrix returns the scoring matrix of the index. It is kept in sync by Add,
+// Remove and Load.
+func (idx *Index) Matrix() *search.Matrix {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.matrix
+}
+
+// AddListener registers l to be notified of every later Add and Remove.
+func (idx *Index) AddListener(l Listener) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.listeners = append(idx.listeners, l)
+}
+
+// Add adds or updates an entry in the index, and clears the failure recorded
+// for its path.
+func (idx *Index) Add(entry Entry) {
+	idx.mu.Lock()
+	idx.clearFailure(entry.Path)
+	if old, ok := idx.entries[entry.Path]; ok {
+		idx.entryBytes -= entryBytes(old)
+	}
+	idx.entries[entry.Path] = withoutVectors(entry)
+	idx.entryBytes += entryBytes(entry)
+	idx.dirty[entry.Path] = true
+	if idx.dim == 0 {
+		idx.dim = entryDim(entry)
+	}
+	idx.matrix.Add(matrixEntry(entry))
+	listeners := idx.listeners
+	idx.mu.Unlock()
+
+	for _, l := range listeners {
+		l.EntryAdded(entry)
+	}
+}
+
+// Get retrieves an entry by path, with its vectors expanded from the matrix
+// at the precision of the index, and copied on every call.
+// Expressions the matrix doesn't score, such as zero vectors, are left out.
 func (idx *Index) Get(path string) (Entry, bool) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
 	entry, ok := idx.entries[path]
-	return entry, ok
+	if !ok {
+		return Entry{}, false
+	}
+	return idx.withVectors(entry), true
 }
 
 // Remove deletes an entry from the index.
 func (idx *Index) Remove(path string) {
 	idx.mu.Lock()
-	defer idx.mu.Unlock()
-
+	if old, ok := idx.entries[path]; ok {
+		idx.entryBytes -= entryBytes(old)
+	}
 	delete(idx.entries, path)
+	idx.dirty[path] = false
+	idx.matrix.Remove(path)
+	listeners := idx.listeners
+	idx.mu.Unlock()
+
+	for _, l := range listeners {
+		l.EntryRemoved(path)
+	}
 }
 
 // Len returns the number of entries in the index.
@@ -67,7 +217,50 @@ func (idx *Index) Len() int {
 	return len(idx.entries)
 }
 
-// All returns all entries as a slice for searching.
+// Paths returns the paths of all entries.
+func (idx *Index) Paths() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	paths := make([]string, 0, len(idx.entries))
+	for path := range idx.entries {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// MemoryEstimate returns roughly how many bytes the index holds in memory:
+// the vectors in the matrix, the entries and failures next to it, and
+// whatever its store keeps.
+func (idx *Index) MemoryEstimate() int64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	n := idx.matrix.Bytes() + idx.entryBytes + idx.store.MemoryEstimate()
+	for _, f := range idx.failures {
+		n += failureBytes(f)
+	}
+	return n
+}
+
+// entryBytes estimates the memory an entry holds outside the matrix.
+func entryBytes(e Entry) int64 {
+	n := int64(len(e.Path)+len(e.Description)+len(e.ContextHash)) + 128
+	for _, issue := range e.QualityIssues {
+		n += int64(len(issue)) + 16
+	}
+	for _, emb := range e.Embeddings {
+		n += int64(len(emb.Expression)) + 48
+		if emb.Region != nil {
+			n += 16
+		}
+	}
+	return n
+}
+
+// All returns all entries, without their vectors: expanding them all would
+// undo the savings of quantization. Use Get for the vectors of one entry,
+// and Matrix to score them.
 func (idx *Index) All() []Entry {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
@@ -78,3 +271,151 @@ func (idx *Index) All() []Entry {
 	}
 	return entries
 }
+
+// matrixEntry converts an entry for the scoring matrix.
+func matrixEntry(e Entry) search.Entry {
+	expressions := make([]search.ExpressionVector, 0, len(e.Embeddings))
+	for _, fe := range e.Embeddings {
+		expressions = append(expressions, search.ExpressionVector{
+			Expression: fe.Expression,
+			Vector:     fe.Vector,
+		})
+	}
+	return search.Entry{
+		Path:        e.Path,
+		Description: e.Description,
+		Expressions: expressions,
+	}
+}
+
+// withoutVectors returns a copy of e whose embeddings have no vectors.
+func withoutVectors(e Entry) Entry {
+	embeddings := make([]ExpressionEmbedding, len(e.Embeddings))
+	for i, emb := range e.Embeddings {
+		embeddings[i] = ExpressionEmbedding{Expression: emb.Expression, Region: emb.Region}
+	}
+	e.Embeddings = embeddings
+	return e
+}
+
+// withVectors returns e with the vectors of its expressions from the matrix.
+// Must hold idx.mu.
+func (idx *Index) withVectors(e Entry) Entry {
+	stored, _ := idx.matrix.Vectors(e.Path)
+	vectors := make(map[string][]float32, len(stored))
+	for _, ev := range stored {
+		vectors[ev.Expression] = ev.Vector
+	}
+
+	embeddings := make([]ExpressionEmbedding, 0, len(e.Embeddings))
+	for _, emb := range e.Embeddings {
+		if v, ok := vectors[emb.Expression]; ok {
+			emb.Vector = v
+			embeddings = append(embeddings, emb)
+		}
+	}
+	e.Embeddings = embeddings
+	return e
+}
+
+// entryDim returns the dimension of the first vector of e, or 0 if it has
+// none.
+func entryDim(e Entry) int {
+	for _, emb := range e.Embeddings {
+		if len(emb.Vector) > 0 {
+			return len(emb.Vector)
+		}
+	}
+	return 0
+}
+
+// Save commits the entries and failures changed since the last Save, along
+// with the index Meta, to the store.
+func (idx *Index) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	err := idx.store.Update(func(tx Tx) error {
+		if err := tx.SetMeta(Meta{Dim: idx.dim, Quantization: idx.quantization}); err != nil {
+			return err
+		}
+		for path, added := range idx.dirty {
+			var err error
+			if e, ok := idx.entries[path]; added && ok {
+				err = tx.Put(idx.withVectors(e))
+			} else {
+				err = tx.Delete(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for path, set := range idx.failuresDirty {
+			var err error
+			if f, ok := idx.failures[path]; set && ok {
+				err = tx.PutFailure(f)
+			} else {
+				err = tx.DeleteFailure(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clear(idx.dirty)
+	clear(idx.failuresDirty)
+	return nil
+}
+
+// Load reads the index from its store. An empty or missing store leaves the
+// index empty. Vectors go straight into the matrix, at the quantization
+// recorded in the store Meta, which the index takes.
+func (idx *Index) Load() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	meta, err := idx.store.Meta()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]Entry)
+	matrix := search.NewMatrix(meta.Quantization)
+	var size int64
+	err = idx.store.Iterate(func(e Entry) error {
+		entries[e.Path] = withoutVectors(e)
+		size += entryBytes(e)
+		matrix.Add(matrixEntry(e))
+		if meta.Dim == 0 {
+			meta.Dim = entryDim(e)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stored, err := idx.store.Failures()
+	if err != nil {
+		return err
+	}
+	failures := make(map[string]Failure, len(stored))
+	for _, f := range stored {
+		failures[f.Path] = f
+	}
+
+	idx.entries = entries
+	idx.entryBytes = size
+	idx.failures = failures
+	idx.dim = meta.Dim
+	idx.quantization = meta.Quantization
+	idx.matrix = matrix
+	clear(idx.dirty)
+	clear(idx.failuresDirty)
+	return nil
+}
@@ -1,10 +1,16 @@
 package index_test
 
 import (
+	"encoding/gob"
+	"errors"
+	"math"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ramon-reichert/locallens/internal/service/index"
+	"github.com/ramon-reichert/locallens/internal/service/search"
 )
 
 func TestAddAndGet(t *testing.T) {
@@ -79,11 +85,19 @@ func TestSaveAndLoad(t *testing.T) {
 	tmpDir := t.TempDir()
 	indexPath := filepath.Join(tmpDir, "test.index")
 
+	region := &search.Region{X: 0.5, Y: 0, W: 0.5, H: 1}
 	idx := index.New(indexPath)
 	idx.Add(index.Entry{
-		Path:        "photo1.jpg",
-		Description: "A sunset over the ocean",
-		Embeddings:  []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{0.5, 0.5, 0, 0}}},
+		Path:          "photo1.jpg",
+		Description:   "A sunset over the ocean, a lighthouse on the right",
+		Rung:          2,
+		ContextHash:   "5f2c",
+		Quality:       0.7,
+		QualityIssues: []string{"truncated"},
+		Embeddings: []index.ExpressionEmbedding{
+			{Expression: "scene", Vector: []float32{0.5, 0.5, 0, 0}},
+			{Expression: "lighthouse", Vector: []float32{0, 0, 0.5, 0.5}, Region: region},
+		},
 	})
 	idx.Add(index.Entry{
 		Path:        "photo2.jpg",
@@ -109,12 +123,21 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Fatal("photo1.jpg not found after load")
 	}
 
-	if entry.Description != "A sunset over the ocean" {
+	if entry.Description != "A sunset over the ocean, a lighthouse on the right" {
 		t.Errorf("unexpected description: %s", entry.Description)
 	}
+	if entry.Rung != 2 {
+		t.Errorf("expected rung 2 after load, got %d", entry.Rung)
+	}
+	if entry.ContextHash != "5f2c" {
+		t.Errorf("expected context hash 5f2c after load, got %q", entry.ContextHash)
+	}
+	if entry.QualityScore() != 0.7 || len(entry.QualityIssues) != 1 || entry.QualityIssues[0] != "truncated" {
+		t.Errorf("expected quality 0.7 [truncated], got %v %v", entry.QualityScore(), entry.QualityIssues)
+	}
 
-	if len(entry.Embeddings) != 1 {
-		t.Fatalf("expected 1 expression embedding after load, got %d", len(entry.Embeddings))
+	if len(entry.Embeddings) != 2 {
+		t.Fatalf("expected 2 expression embeddings after load, got %d", len(entry.Embeddings))
 	}
 	if entry.Embeddings[0].Expression != "scene" {
 		t.Errorf("unexpected expression name: %s", entry.Embeddings[0].Expression)
@@ -129,6 +152,17 @@ func TestSaveAndLoad(t *testing.T) {
 			}
 		}
 	}
+	if entry.Embeddings[0].Region != nil {
+		t.Errorf("expected no region for a whole-image expression, got %v", entry.Embeddings[0].Region)
+	}
+	if got := entry.Embeddings[1].Region; got == nil || *got != *region {
+		t.Errorf("expected region %v, got %v", *region, got)
+	}
+
+	// Entries indexed before quality was scored count as good.
+	if other, _ := loaded.Get("photo2.jpg"); other.QualityScore() != 1 {
+		t.Errorf("expected unscored entry to score 1, got %v", other.QualityScore())
+	}
 }
 
 func TestLoadNonExistent(t *testing.T) {
@@ -144,3 +178,285 @@ func TestLoadNonExistent(t *testing.T) {
 		t.Errorf("expected 0 entries, got %d", idx.Len())
 	}
 }
+
+func TestMatrixFollowsIndex(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+	idx := index.New(indexPath)
+
+	idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0}}}})
+	idx.Add(index.Entry{Path: "b.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{0, 1}}}})
+	idx.Remove("b.jpg")
+
+	if n := idx.Matrix().Len(); n != 1 {
+		t.Fatalf("expected 1 image in matrix, got %d", n)
+	}
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := index.New(indexPath)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	results := loaded.Matrix().TopK([]float32{1, 0}, 5)
+	if len(results) != 1 || results[0].Path != "a.jpg" {
+		t.Errorf("expected a.jpg from loaded matrix, got %v", results)
+	}
+}
+
+func TestSaveAndLoad_Quantized(t *testing.T) {
+	want := []float32{0.12, -0.5, 0.33, 0.9}
+
+	for _, q := range []search.Quantization{search.QuantizeFloat16, search.QuantizeInt8} {
+		indexPath := filepath.Join(t.TempDir(), "test.index")
+		idx := index.New(indexPath)
+		idx.SetQuantization(q)
+		idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: want}}})
+		if err := idx.Save(); err != nil {
+			t.Fatalf("%s: save: %v", q, err)
+		}
+
+		loaded := index.New(indexPath)
+		if err := loaded.Load(); err != nil {
+			t.Fatalf("%s: load: %v", q, err)
+		}
+		if got := loaded.Quantization(); got != q {
+			t.Errorf("quantization = %q, want %q", got, q)
+		}
+
+		entry, _ := loaded.Get("a.jpg")
+		for i, x := range entry.Embeddings[0].Vector {
+			if math.Abs(float64(x-want[i])) > 0.005 {
+				t.Errorf("%s: vector[%d] = %v, want ~%v", q, i, x, want[i])
+			}
+		}
+		if r := loaded.Matrix().TopK(want, 1); len(r) != 1 || r[0].Score < 0.99 {
+			t.Errorf("%s: expected the stored vector to match itself, got %v", q, r)
+		}
+	}
+}
+
+func TestLoad_HeaderlessFile(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "old.index")
+	f, err := os.Create(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := map[string]index.Entry{
+		"a.jpg": {Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0}}}},
+	}
+	if err := gob.NewEncoder(f).Encode(old); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	idx := index.New(indexPath)
+	if err := idx.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if idx.Len() != 1 || idx.Quantization() != search.QuantizeNone {
+		t.Errorf("expected 1 full-precision entry, got %d at %q", idx.Len(), idx.Quantization())
+	}
+}
+
+func TestDimRecorded(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+	idx := index.New(indexPath)
+	if idx.Dim() != 0 {
+		t.Fatalf("expected dim 0 for an empty index, got %d", idx.Dim())
+	}
+
+	idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0, 0}}}})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := index.New(indexPath)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Dim() != 3 {
+		t.Errorf("expected dim 3 after load, got %d", loaded.Dim())
+	}
+}
+
+func TestGobStore_UpdateIsAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.index")
+	store := index.NewGobStore(path)
+
+	err := store.Update(func(tx index.Tx) error {
+		return tx.Put(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0}}}})
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	failed := errors.New("abort")
+	err = store.Update(func(tx index.Tx) error {
+		if err := tx.Delete("a.jpg"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the transaction error, got %v", err)
+	}
+
+	// A fresh store sees only the committed transaction.
+	reopened := index.NewGobStore(path)
+	if n, err := reopened.Len(); err != nil || n != 1 {
+		t.Fatalf("expected 1 committed entry, got %d (%v)", n, err)
+	}
+	vectors, err := reopened.Vectors("a.jpg")
+	if err != nil || len(vectors) != 1 || vectors[0].Vector[0] != 1 {
+		t.Errorf("unexpected vectors %v (%v)", vectors, err)
+	}
+
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestGobStore_UpgradesFile(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+	f, err := os.Create(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(index.Meta{Version: 1, Dim: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(map[string]struct{ Path string }{"a.jpg": {Path: "a.jpg"}, "b.jpg": {Path: "b.jpg"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(map[string]index.Failure{"bad.jpg": {Path: "bad.jpg", Class: "decode"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	store := index.NewGobStore(indexPath)
+	err = store.Update(func(tx index.Tx) error {
+		return tx.Delete("b.jpg")
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	reopened := index.NewGobStore(indexPath)
+	meta, err := reopened.Meta()
+	if err != nil || meta.Version != 2 || meta.Dim != 2 {
+		t.Errorf("unexpected meta %+v (%v)", meta, err)
+	}
+	if n, err := reopened.Len(); err != nil || n != 1 {
+		t.Errorf("expected 1 entry, got %d (%v)", n, err)
+	}
+	if _, ok, err := reopened.Get("a.jpg"); !ok || err != nil {
+		t.Errorf("a.jpg missing after upgrade (%v)", err)
+	}
+	if failures, err := reopened.Failures(); err != nil || len(failures) != 1 {
+		t.Errorf("expected 1 failure, got %v (%v)", failures, err)
+	}
+}
+
+func TestMemoryEstimate(t *testing.T) {
+	idx := index.New("")
+	if got := idx.MemoryEstimate(); got != 0 {
+		t.Fatalf("empty index estimate: got %d, want 0", got)
+	}
+
+	vec := []float32{1, 0, 0, 0}
+	idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: vec}}})
+	idx.Add(index.Entry{Path: "b.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: vec}}})
+	two := idx.MemoryEstimate()
+	if two <= 0 {
+		t.Fatalf("estimate with two entries: got %d", two)
+	}
+
+	// Replacing an entry doesn't grow the vectors it holds.
+	idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: vec}}})
+	idx.Remove("b.jpg")
+	if got := idx.MemoryEstimate(); got >= two+int64(len(vec))*4 {
+		t.Errorf("estimate after replace and remove: got %d, want below %d", got, two+int64(len(vec))*4)
+	}
+
+	if got := len(idx.Paths()); got != 1 {
+		t.Errorf("Paths: got %d, want 1", got)
+	}
+
+	// Failures are held in memory too.
+	before := idx.MemoryEstimate()
+	idx.SetFailure(index.Failure{Path: "bad.jpg", Error: "unexpected EOF"})
+	if got := idx.MemoryEstimate(); got <= before {
+		t.Errorf("estimate with a failure: got %d, want above %d", got, before)
+	}
+}
+
+func TestFailures_SaveAndLoad(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+	idx := index.New(indexPath)
+
+	when := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	idx.SetFailure(index.Failure{Path: "bad.jpg", Class: "decode", Error: "unexpected EOF", Attempts: 1, LastAttempt: when, Skipped: true})
+	idx.SetFailure(index.Failure{Path: "slow.jpg", Class: "timeout", Attempts: 2, LastAttempt: when, NextRetry: when.Add(time.Hour)})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Indexing an image successfully clears its failure.
+	idx.Add(index.Entry{Path: "slow.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0}}}})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := index.New(indexPath)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := len(loaded.Failures()); got != 1 {
+		t.Fatalf("expected 1 failure, got %d", got)
+	}
+	f, ok := loaded.Failure("bad.jpg")
+	if !ok || f.Class != "decode" || !f.Skipped || !f.LastAttempt.Equal(when) {
+		t.Errorf("unexpected failure after load: %+v", f)
+	}
+	if _, ok := loaded.Failure("slow.jpg"); ok {
+		t.Error("expected the failure of an indexed image to be cleared")
+	}
+
+	loaded.ClearFailure("bad.jpg")
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	reloaded := index.New(indexPath)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := len(reloaded.Failures()); got != 0 {
+		t.Errorf("expected no failures after clearing, got %d", got)
+	}
+}
+
+func TestLoad_FileWithoutFailures(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "v1.index")
+	f, err := os.Create(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(f)
+	if err := enc.Encode(index.Meta{Version: 1, Dim: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(map[string]struct{ Path string }{"a.jpg": {Path: "a.jpg"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	idx := index.New(indexPath)
+	if err := idx.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if idx.Len() != 1 || len(idx.Failures()) != 0 {
+		t.Errorf("expected 1 entry and no failures, got %d and %d", idx.Len(), len(idx.Failures()))
+	}
+}
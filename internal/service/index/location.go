@@ -0,0 +1,163 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Location is where the index files of an image folder are kept.
+type Location string
+
+const (
+	// LocationFolder keeps the index files inside the image folder.
+	LocationFolder Location = "folder"
+
+	// LocationCentral keeps the index files under a central directory, in a
+	// subdirectory named after the folder ID. Image folders are left
+	// untouched, so read-only media and shared folders can be indexed.
+	LocationCentral Location = "central"
+)
+
+// ParseLocation converts a config string to a Location. Empty means
+// LocationFolder.
+func ParseLocation(s string) (Location, error) {
+	switch l := Location(s); l {
+	case LocationFolder, LocationCentral:
+		return l, nil
+	case "":
+		return LocationFolder, nil
+	}
+	return "", fmt.Errorf("unknown index location %q (want folder or central)", s)
+}
+
+// LocatorConfig configures a Locator.
+type LocatorConfig struct {
+	Location   Location
+	CentralDir string // root of LocationCentral
+
+	// Mapping assigns index directories to image folders explicitly,
+	// whatever the Location. A mapped folder's subfolders are mapped to the
+	// matching subdirectories; the longest matching folder wins.
+	Mapping map[string]string
+}
+
+// Locator resolves the directory holding the index files of an image folder.
+// It is safe for concurrent use.
+type Locator struct {
+	location   Location
+	centralDir string
+	mapping    map[string]string // cleaned folder → cleaned index directory
+
+	mu  sync.Mutex
+	ids map[string]cachedID // cleaned folder → its FolderID
+}
+
+// cachedID is the FolderID of a folder, valid while the folder is the same
+// directory.
+type cachedID struct {
+	dir os.FileInfo
+	id  string
+}
+
+// NewLocator validates cfg and returns a Locator.
+func NewLocator(cfg LocatorConfig) (*Locator, error) {
+	location, err := ParseLocation(string(cfg.Location))
+	if err != nil {
+		return nil, err
+	}
+	if location == LocationCentral && cfg.CentralDir == "" {
+		return nil, fmt.Errorf("central index location needs a directory")
+	}
+
+	mapping := make(map[string]string, len(cfg.Mapping))
+	for folder, dir := range cfg.Mapping {
+		if folder == "" || dir == "" {
+			return nil, fmt.Errorf("index mapping %q → %q: empty path", folder, dir)
+		}
+		mapping[filepath.Clean(folder)] = filepath.Clean(dir)
+	}
+
+	return &Locator{location: location, centralDir: filepath.Clean(cfg.CentralDir), mapping: mapping, ids: make(map[string]cachedID)}, nil
+}
+
+// Dir returns the directory holding the index files of folderPath. It
+// doesn't create it.
+func (l *Locator) Dir(folderPath string) (string, error) {
+	folder := filepath.Clean(folderPath)
+
+	if dir, ok := l.mapped(folder); ok {
+		return dir, nil
+	}
+	if l.location != LocationCentral {
+		return folder, nil
+	}
+
+	id, err := l.folderID(folder)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.centralDir, id), nil
+}
+
+// folderID returns the FolderID of folder, cached while folder is the same
+// directory. Reading the volume ID can mean scanning the mount table, but a
+// stat tells when another disk was mounted at the same path.
+func (l *Locator) folderID(folder string) (string, error) {
+	dir, err := os.Stat(folder)
+	if err != nil {
+		return FolderID(folder)
+	}
+
+	l.mu.Lock()
+	c, ok := l.ids[folder]
+	l.mu.Unlock()
+	if ok && os.SameFile(c.dir, dir) {
+		return c.id, nil
+	}
+
+	id, err := FolderID(folder)
+	if err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	l.ids[folder] = cachedID{dir: dir, id: id}
+	l.mu.Unlock()
+	return id, nil
+}
+
+func (l *Locator) mapped(folder string) (string, bool) {
+	best, bestDir := "", ""
+	for from, dir := range l.mapping {
+		if len(from) <= len(best) {
+			continue
+		}
+		rel, err := filepath.Rel(from, folder)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		best, bestDir = from, filepath.Join(dir, rel)
+	}
+	return bestDir, best != ""
+}
+
+// FolderID identifies an image folder by the volume it is on and its path,
+// so the same path on two different disks, such as two camera cards mounted
+// one after the other, gets two different IDs.
+func FolderID(folderPath string) (string, error) {
+	abs, err := filepath.Abs(folderPath)
+	if err != nil {
+		return "", fmt.Errorf("folder id: %w", err)
+	}
+	volume, err := volumeID(abs)
+	if err != nil {
+		return "", fmt.Errorf("folder id: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(volume + "\x00" + abs))
+	return hex.EncodeToString(sum[:16]), nil
+}
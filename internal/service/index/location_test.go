@@ -0,0 +1,120 @@
+package index_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+func TestLocator_Folder(t *testing.T) {
+	loc, err := index.NewLocator(index.LocatorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folder := t.TempDir()
+	dir, err := loc.Dir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != folder {
+		t.Errorf("got %q, want the folder itself", dir)
+	}
+}
+
+func TestLocator_Central(t *testing.T) {
+	central := t.TempDir()
+	loc, err := index.NewLocator(index.LocatorConfig{Location: index.LocationCentral, CentralDir: central})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := t.TempDir(), t.TempDir()
+	dirA, err := loc.Dir(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirB, err := loc.Dir(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Dir(dirA) != central {
+		t.Errorf("got %q, want a directory under %q", dirA, central)
+	}
+	if dirA == dirB {
+		t.Error("two folders share an index directory")
+	}
+
+	again, err := loc.Dir(a + string(filepath.Separator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != dirA {
+		t.Errorf("same folder resolved twice: %q and %q", dirA, again)
+	}
+
+	// A directory replaced at the same path and on the same volume is
+	// looked up again, and gets the same ID.
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(a, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if replaced, err := loc.Dir(a); err != nil || replaced != dirA {
+		t.Errorf("replaced folder resolved to %q, %v; want %q", replaced, err, dirA)
+	}
+
+	if _, err := loc.Dir(filepath.Join(a, "missing")); err == nil {
+		t.Error("expected an error for a folder that doesn't exist")
+	}
+}
+
+func TestLocator_Mapping(t *testing.T) {
+	root := filepath.FromSlash("/media/card")
+	loc, err := index.NewLocator(index.LocatorConfig{
+		Location:   index.LocationCentral,
+		CentralDir: t.TempDir(),
+		Mapping: map[string]string{
+			root:                               filepath.FromSlash("/idx/card"),
+			filepath.Join(root, "DCIM", "100"): filepath.FromSlash("/idx/hundred"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		folder string
+		want   string
+	}{
+		{root, "/idx/card"},
+		{filepath.Join(root, "DCIM"), "/idx/card/DCIM"},
+		{filepath.Join(root, "DCIM", "100"), "/idx/hundred"},
+		{filepath.Join(root, "DCIM", "100", "raw"), "/idx/hundred/raw"},
+	}
+	for _, tt := range tests {
+		got, err := loc.Dir(tt.folder)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.folder, err)
+		}
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("%s: got %q, want %q", tt.folder, got, want)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	if l, err := index.ParseLocation(""); err != nil || l != index.LocationFolder {
+		t.Errorf("empty: got %q, %v", l, err)
+	}
+	if _, err := index.ParseLocation("cloud"); err == nil {
+		t.Error("expected an error for an unknown location")
+	}
+	if _, err := index.NewLocator(index.LocatorConfig{Location: index.LocationCentral}); err == nil {
+		t.Error("expected an error for a central location without a directory")
+	}
+}
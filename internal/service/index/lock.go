@@ -0,0 +1,297 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lock timing, variable so tests can shorten it.
+var (
+	// lockHeartbeat is how often a held lock file is touched.
+	lockHeartbeat = 10 * time.Second
+
+	// lockStaleAfter is how long a lock file may go without a heartbeat
+	// before it is considered abandoned, for holders on another host or
+	// whose process can't be checked.
+	lockStaleAfter = 3 * lockHeartbeat
+)
+
+var (
+	// ErrLocked is returned when another process holds the lock of an
+	// index. The returned error is a *LockedError naming the holder.
+	ErrLocked = errors.New("index is locked")
+
+	// ErrLockLost is reported by Lock.Err once a held lock is lost: another
+	// process took it over, or its heartbeat failed for long enough that
+	// others would.
+	ErrLockLost = errors.New("index lock lost")
+)
+
+// LockInfo identifies the holder of an index lock.
+type LockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"-"` // modification time of the lock file
+}
+
+// Ours reports whether the lock is held by the current process.
+func (li LockInfo) Ours() bool {
+	host, _ := os.Hostname()
+	return li.PID == os.Getpid() && li.Host == host
+}
+
+// stale reports whether the holder is gone: its heartbeat stopped, or it ran
+// on this host and its process no longer exists.
+func (li LockInfo) stale() bool {
+	if time.Since(li.Heartbeat) > lockStaleAfter {
+		return true
+	}
+	host, _ := os.Hostname()
+	return li.Host == host && !processAlive(li.PID)
+}
+
+// LockedError reports the process holding an index lock.
+type LockedError struct {
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v by process %d on %s since %s", ErrLocked, e.Holder.PID, e.Holder.Host, e.Holder.Started.Format(time.DateTime))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Lock is an advisory, cross-process lock on an index, held through a lock
+// file next to it. The file records the holder's PID, host and start time,
+// and its modification time is refreshed as a heartbeat while the lock is
+// held, so a lock left by a crashed process is detected and taken over.
+//
+// Each heartbeat first checks that the file still names this holder. A
+// holder stalled past lockStaleAfter, by a suspended laptop for instance, can
+// find its lock taken over; Lost reports it.
+type Lock struct {
+	path string
+	info LockInfo
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+	err  error // why the lock was lost; set before lost is closed
+}
+
+// LockPathFor returns the lock file path of the index at indexPath.
+func LockPathFor(indexPath string) string {
+	return indexPath + ".lock"
+}
+
+// AcquireLock takes the lock of the index at indexPath. If another live
+// process holds it, the error is a *LockedError matching ErrLocked.
+func AcquireLock(indexPath string) (*Lock, error) {
+	path := LockPathFor(indexPath)
+	host, _ := os.Hostname()
+	info := LockInfo{PID: os.Getpid(), Host: host, Started: time.Now()}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("encode lock: %w", err)
+	}
+
+	// A stale lock is taken over and creation retried once; losing that
+	// race to another process reports it as the holder.
+	for range 2 {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, werr := f.Write(data)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("write lock: %w", werr)
+			}
+
+			l := &Lock{path: path, info: info, stop: make(chan struct{}), done: make(chan struct{}), lost: make(chan struct{})}
+			go l.heartbeat()
+			return l, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("create lock: %w", err)
+		}
+
+		holder, held, err := readLock(path)
+		if err != nil {
+			return nil, err
+		}
+		if held {
+			return nil, &LockedError{Holder: holder}
+		}
+		if err := takeOver(path); err != nil {
+			return nil, err
+		}
+	}
+
+	holder, _, _ := readLock(path)
+	return nil, &LockedError{Holder: holder}
+}
+
+// takeOver removes the stale lock file at path. Removing it in place would
+// race: another process may take it over between the staleness check and the
+// removal, and its fresh lock be removed instead. So the file is moved to a
+// name of its own first, which only one process can do, and checked again
+// there; a live lock moved by mistake is put back and its holder reported.
+func takeOver(path string) error {
+	moved := fmt.Sprintf("%s.%d.%d.stale", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, moved); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // taken over, or released, by another process
+		}
+		return fmt.Errorf("move stale lock: %w", err)
+	}
+	defer os.Remove(moved)
+
+	holder, held, err := readLock(moved)
+	if err != nil || !held {
+		return nil
+	}
+	// Link rather than rename back, so as not to replace a lock created
+	// meanwhile; if that fails, the holder finds out on its next heartbeat.
+	os.Link(moved, path)
+	return &LockedError{Holder: holder}
+}
+
+// ReadLock returns the live holder of the lock of the index at indexPath,
+// if any. Stale locks are reported as not held.
+func ReadLock(indexPath string) (LockInfo, bool, error) {
+	return readLock(LockPathFor(indexPath))
+}
+
+func readLock(path string) (LockInfo, bool, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return LockInfo{}, false, nil
+	}
+	if err != nil {
+		return LockInfo{}, false, fmt.Errorf("stat lock: %w", err)
+	}
+
+	var info LockInfo
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &info)
+	}
+	info.Heartbeat = fi.ModTime()
+	if err != nil {
+		// Unreadable: either being written right now or left half-written
+		// by a crash. Only the heartbeat can tell.
+		return info, time.Since(info.Heartbeat) <= lockStaleAfter, nil
+	}
+
+	return info, !info.stale(), nil
+}
+
+// Info returns the holder recorded in the lock.
+func (l *Lock) Info() LockInfo {
+	return l.info
+}
+
+// Lost returns a channel closed when the lock is lost while held. Err then
+// tells why.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns an error matching ErrLockLost once the lock is lost, and nil
+// while it is held.
+func (l *Lock) Err() error {
+	select {
+	case <-l.lost:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// owns reports whether holder, read from the lock file, is this lock.
+func (l *Lock) owns(holder LockInfo) bool {
+	return holder.PID == l.info.PID && holder.Host == l.info.Host && holder.Started.Equal(l.info.Started)
+}
+
+// Release stops the heartbeat and removes the lock file, unless another
+// process has taken it over in the meantime.
+func (l *Lock) Release() error {
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+
+	holder, _, err := readLock(l.path)
+	if err != nil {
+		return err
+	}
+	if !l.owns(holder) {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove lock: %w", err)
+	}
+	return nil
+}
+
+// heartbeat refreshes the lock file until Release, or until the lock is
+// lost: the file names another holder, or refreshing it kept failing for
+// lockStaleAfter.
+func (l *Lock) heartbeat() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(lockHeartbeat)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			err := l.touch(now)
+			if err == nil {
+				last = now
+				continue
+			}
+			if !errors.Is(err, ErrLockLost) {
+				if now.Sub(last) <= lockStaleAfter {
+					continue // retried on the next tick
+				}
+				err = fmt.Errorf("%w: no heartbeat since %s: %w", ErrLockLost, last.Format(time.DateTime), err)
+			}
+			l.err = err
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// touch refreshes the modification time of the lock file, after checking
+// that it is still this lock's.
+func (l *Lock) touch(now time.Time) error {
+	holder, _, err := readLock(l.path)
+	if err != nil {
+		return err
+	}
+	if !l.owns(holder) {
+		if holder.PID == 0 {
+			return fmt.Errorf("%w: lock file removed or replaced", ErrLockLost)
+		}
+		return fmt.Errorf("%w: taken over by process %d on %s", ErrLockLost, holder.PID, holder.Host)
+	}
+	if err := os.Chtimes(l.path, now, now); err != nil {
+		return fmt.Errorf("refresh lock: %w", err)
+	}
+	return nil
+}
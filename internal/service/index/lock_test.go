@@ -0,0 +1,128 @@
+package index_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+func TestLock_HeldUntilReleased(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+
+	lock, err := index.AcquireLock(indexPath)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	_, err = index.AcquireLock(indexPath)
+	var locked *index.LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, index.ErrLocked) {
+		t.Fatalf("expected a LockedError, got %v", err)
+	}
+	if locked.Holder.PID != os.Getpid() || !locked.Holder.Ours() {
+		t.Errorf("expected this process as holder, got %+v", locked.Holder)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, held, _ := index.ReadLock(indexPath); held {
+		t.Error("expected the lock to be free after release")
+	}
+
+	again, err := index.AcquireLock(indexPath)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	again.Release()
+}
+
+func TestLock_TakesOverStaleLocks(t *testing.T) {
+	host, _ := os.Hostname()
+
+	tests := []struct {
+		name string
+		info index.LockInfo
+		age  time.Duration
+	}{
+		{"dead process", index.LockInfo{PID: 1 << 30, Host: host}, 0},
+		{"no heartbeat", index.LockInfo{PID: 1, Host: "elsewhere"}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexPath := filepath.Join(t.TempDir(), "test.index")
+			data, _ := json.Marshal(tt.info)
+			if err := os.WriteFile(index.LockPathFor(indexPath), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-tt.age)
+			os.Chtimes(index.LockPathFor(indexPath), old, old)
+
+			lock, err := index.AcquireLock(indexPath)
+			if err != nil {
+				t.Fatalf("expected to take over the stale lock: %v", err)
+			}
+			defer lock.Release()
+
+			// The stale file is moved aside before it is removed.
+			if left, _ := filepath.Glob(index.LockPathFor(indexPath) + ".*"); len(left) > 0 {
+				t.Errorf("expected the stale lock removed, found %v", left)
+			}
+		})
+	}
+}
+
+func TestLock_LiveRemoteHolder(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+	data, _ := json.Marshal(index.LockInfo{PID: 4242, Host: "other-host"})
+	if err := os.WriteFile(index.LockPathFor(indexPath), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := index.AcquireLock(indexPath)
+	var locked *index.LockedError
+	if !errors.As(err, &locked) || locked.Holder.PID != 4242 || locked.Holder.Host != "other-host" {
+		t.Fatalf("expected the lock held by 4242 on other-host, got %v", err)
+	}
+}
+
+func TestLock_LostWhenTakenOver(t *testing.T) {
+	index.SetLockTiming(t, 10*time.Millisecond, time.Hour)
+	indexPath := filepath.Join(t.TempDir(), "test.index")
+
+	lock, err := index.AcquireLock(indexPath)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if lock.Err() != nil {
+		t.Fatalf("expected no error while held, got %v", lock.Err())
+	}
+
+	// Another process decided the lock was stale and took it over.
+	data, _ := json.Marshal(index.LockInfo{PID: 4242, Host: "other-host", Started: time.Now()})
+	if err := os.WriteFile(index.LockPathFor(indexPath), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be reported lost")
+	}
+	if err := lock.Err(); !errors.Is(err, index.ErrLockLost) {
+		t.Errorf("expected ErrLockLost, got %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	holder, held, _ := index.ReadLock(indexPath)
+	if !held || holder.PID != 4242 {
+		t.Errorf("release should leave the new holder's lock, got %+v held=%v", holder, held)
+	}
+}
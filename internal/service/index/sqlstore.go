@@ -0,0 +1,552 @@
+package index
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/ncruces/go-sqlite3/driver" // pure Go, no cgo
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+// sqlVersion is the schema version recorded in the meta table.
+const sqlVersion = 1
+
+// sqlSchema creates the tables of an index database. Vectors are stored as
+// little-endian blobs at the index quantization: 4 bytes per dimension for
+// float32, 2 for float16 and 1 for int8, which also has a scale.
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	id           INTEGER PRIMARY KEY CHECK (id = 0),
+	version      INTEGER NOT NULL,
+	dim          INTEGER NOT NULL,
+	quantization TEXT    NOT NULL
+);
+CREATE TABLE IF NOT EXISTS entries (
+	path           TEXT PRIMARY KEY,
+	description    TEXT NOT NULL,
+	rung           INTEGER NOT NULL,
+	quality        REAL NOT NULL,
+	quality_issues TEXT NOT NULL, -- JSON array
+	context_hash   TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS expressions (
+	path       TEXT    NOT NULL REFERENCES entries (path) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	expression TEXT    NOT NULL,
+	vector     BLOB    NOT NULL,
+	scale      REAL    NOT NULL,
+	region_x   REAL, -- the region columns are NULL for the whole image
+	region_y   REAL,
+	region_w   REAL,
+	region_h   REAL,
+	PRIMARY KEY (path, position)
+);
+CREATE TABLE IF NOT EXISTS failures (
+	path          TEXT PRIMARY KEY,
+	class         TEXT    NOT NULL,
+	error         TEXT    NOT NULL,
+	attempts      INTEGER NOT NULL,
+	last_attempt  TEXT, -- RFC 3339, NULL when unset
+	next_retry    TEXT,
+	skipped       BOOLEAN NOT NULL,
+	file_mod_time TEXT,
+	file_size     INTEGER NOT NULL
+);
+`
+
+// SQLStore is a Store in an embedded SQLite database, one file per index,
+// with entries, expressions and failures in tables that other tools can
+// query. Update writes only what changed, so saving a large index costs no
+// more than its changes. It doesn't shrink the index in memory: Index.Load
+// still reads every entry into the Index and its Matrix, as with GobStore.
+//
+// Like GobStore, it holds no open handle between calls, so an index dropped
+// from memory leaves nothing behind, and other processes can use the file in
+// between. Writers wait for each other through SQLite's own locking.
+type SQLStore struct {
+	path string
+}
+
+// NewSQLStore creates an SQLStore backed by the database file at path. A
+// missing file is an empty store; the first Update creates it.
+func NewSQLStore(path string) *SQLStore {
+	return &SQLStore{path: path}
+}
+
+// open opens the database, read-only unless write is set. A read of a missing
+// file yields fs.ErrNotExist instead of creating it.
+func (s *SQLStore) open(write bool) (*sql.DB, error) {
+	if !write {
+		if _, err := os.Stat(s.path); err != nil {
+			return nil, err
+		}
+	}
+
+	abs, err := filepath.Abs(s.path)
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // Windows volume
+	}
+	query := url.Values{"_pragma": {"busy_timeout(10000)", "foreign_keys(1)"}}
+	if write {
+		query.Set("_txlock", "immediate")
+	} else {
+		query.Set("mode", "ro")
+	}
+	dsn := (&url.URL{Scheme: "file", Path: p, RawQuery: query.Encode()}).String()
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if write {
+		if _, err := db.Exec(sqlSchema); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	return db, nil
+}
+
+// read runs fn on the database opened read-only. A missing file runs
+// nothing and reports no error.
+func (s *SQLStore) read(fn func(db *sql.DB) error) error {
+	db, err := s.open(false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return fn(db)
+}
+
+// Meta implements Store.
+func (s *SQLStore) Meta() (Meta, error) {
+	var meta Meta
+	err := s.read(func(db *sql.DB) error {
+		var err error
+		meta, err = readMeta(db)
+		return err
+	})
+	return meta, err
+}
+
+// readMeta reads the meta table through db, a database or a transaction.
+func readMeta(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (Meta, error) {
+	var (
+		meta Meta
+		q    string
+	)
+	err := db.QueryRow(`SELECT version, dim, quantization FROM meta`).Scan(&meta.Version, &meta.Dim, &q)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Meta{}, nil
+	}
+	if err != nil {
+		return Meta{}, fmt.Errorf("read meta: %w", err)
+	}
+	if meta.Version > sqlVersion {
+		return Meta{}, fmt.Errorf("index database version %d is newer than supported version %d", meta.Version, sqlVersion)
+	}
+	meta.Quantization = search.Quantization(q)
+	return meta, nil
+}
+
+// Get implements Store.
+func (s *SQLStore) Get(path string) (Entry, bool, error) {
+	var found Entry
+	ok := false
+	err := s.read(func(db *sql.DB) error {
+		return s.scan(db, path, func(e Entry) error {
+			found, ok = e, true
+			return nil
+		})
+	})
+	return found, ok, err
+}
+
+// Len implements Store.
+func (s *SQLStore) Len() (int, error) {
+	n := 0
+	err := s.read(func(db *sql.DB) error {
+		return db.QueryRow(`SELECT count(*) FROM entries`).Scan(&n)
+	})
+	return n, err
+}
+
+// Iterate implements Store. Entries are read in path order, one at a time.
+func (s *SQLStore) Iterate(fn func(Entry) error) error {
+	return s.read(func(db *sql.DB) error {
+		return s.scan(db, "", fn)
+	})
+}
+
+// scan reads the entry at path, or every entry if path is empty, with its
+// expressions, and calls fn for each.
+func (s *SQLStore) scan(db *sql.DB, path string, fn func(Entry) error) error {
+	meta, err := readMeta(db)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT e.path, e.description, e.rung, e.quality, e.quality_issues, e.context_hash,
+		       x.expression, x.vector, x.scale, x.region_x, x.region_y, x.region_w, x.region_h
+		FROM entries e LEFT JOIN expressions x ON x.path = e.path`
+	var args []any
+	if path != "" {
+		query += ` WHERE e.path = ?`
+		args = append(args, path)
+	}
+	query += ` ORDER BY e.path, x.position`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("read entries: %w", err)
+	}
+	defer rows.Close()
+
+	var current *Entry
+	for rows.Next() {
+		var (
+			e              Entry
+			issues         string
+			expression     sql.NullString
+			blob           []byte
+			scale          sql.NullFloat64
+			rx, ry, rw, rh sql.NullFloat64
+		)
+		err := rows.Scan(&e.Path, &e.Description, &e.Rung, &e.Quality, &issues, &e.ContextHash,
+			&expression, &blob, &scale, &rx, &ry, &rw, &rh)
+		if err != nil {
+			return fmt.Errorf("read entry: %w", err)
+		}
+
+		if current == nil || current.Path != e.Path {
+			if current != nil {
+				if err := fn(*current); err != nil {
+					return err
+				}
+			}
+			if issues != "[]" {
+				if err := json.Unmarshal([]byte(issues), &e.QualityIssues); err != nil {
+					return fmt.Errorf("read entry %s: quality issues: %w", e.Path, err)
+				}
+			}
+			current = &e
+		}
+		if !expression.Valid {
+			continue // no expressions
+		}
+
+		vector, err := decodeVector(blob, float32(scale.Float64), meta.Quantization)
+		if err != nil {
+			return fmt.Errorf("read entry %s: %w", current.Path, err)
+		}
+		emb := ExpressionEmbedding{Expression: expression.String, Vector: vector}
+		if rx.Valid {
+			emb.Region = &search.Region{X: float32(rx.Float64), Y: float32(ry.Float64), W: float32(rw.Float64), H: float32(rh.Float64)}
+		}
+		current.Embeddings = append(current.Embeddings, emb)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read entries: %w", err)
+	}
+	if current != nil {
+		return fn(*current)
+	}
+	return nil
+}
+
+// Failures implements Store.
+func (s *SQLStore) Failures() ([]Failure, error) {
+	var failures []Failure
+	err := s.read(func(db *sql.DB) error {
+		rows, err := db.Query(`SELECT path, class, error, attempts, last_attempt, next_retry, skipped, file_mod_time, file_size FROM failures`)
+		if err != nil {
+			return fmt.Errorf("read failures: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				f                           Failure
+				lastAttempt, nextRetry, mod sql.NullString
+			)
+			if err := rows.Scan(&f.Path, &f.Class, &f.Error, &f.Attempts, &lastAttempt, &nextRetry, &f.Skipped, &mod, &f.FileSize); err != nil {
+				return fmt.Errorf("read failure: %w", err)
+			}
+			for _, t := range []struct {
+				dst *time.Time
+				src sql.NullString
+			}{{&f.LastAttempt, lastAttempt}, {&f.NextRetry, nextRetry}, {&f.FileModTime, mod}} {
+				if *t.dst, err = decodeTime(t.src); err != nil {
+					return fmt.Errorf("read failure %s: %w", f.Path, err)
+				}
+			}
+			failures = append(failures, f)
+		}
+		return rows.Err()
+	})
+	return failures, err
+}
+
+// Vectors implements Store.
+func (s *SQLStore) Vectors(path string) ([]ExpressionEmbedding, error) {
+	e, _, err := s.Get(path)
+	return e.Embeddings, err
+}
+
+// MemoryEstimate implements Store. An SQLStore itself keeps nothing in
+// memory between calls.
+func (s *SQLStore) MemoryEstimate() int64 {
+	return 0
+}
+
+// Update implements Store, in one SQLite transaction.
+func (s *SQLStore) Update(fn func(tx Tx) error) error {
+	db, err := s.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	sqlTx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer sqlTx.Rollback() // no-op once committed
+
+	meta, err := readMeta(sqlTx)
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqlTxn{tx: sqlTx, meta: meta}); err != nil {
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// Close implements Store. An SQLStore holds no open handle.
+func (s *SQLStore) Close() error {
+	return nil
+}
+
+// sqlTxn is the Tx of an SQLStore Update.
+type sqlTxn struct {
+	tx   *sql.Tx
+	meta Meta
+}
+
+func (t *sqlTxn) Put(entry Entry) error {
+	issues, err := json.Marshal(entry.QualityIssues)
+	if err != nil {
+		return err
+	}
+	if entry.QualityIssues == nil {
+		issues = []byte("[]")
+	}
+
+	_, err = t.tx.Exec(`
+		INSERT INTO entries (path, description, rung, quality, quality_issues, context_hash)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET
+			description = excluded.description, rung = excluded.rung, quality = excluded.quality,
+			quality_issues = excluded.quality_issues, context_hash = excluded.context_hash`,
+		entry.Path, entry.Description, entry.Rung, entry.Quality, string(issues), entry.ContextHash)
+	if err != nil {
+		return fmt.Errorf("put entry %s: %w", entry.Path, err)
+	}
+	if _, err := t.tx.Exec(`DELETE FROM expressions WHERE path = ?`, entry.Path); err != nil {
+		return fmt.Errorf("put entry %s: %w", entry.Path, err)
+	}
+
+	for i, emb := range entry.Embeddings {
+		blob, scale := encodeVector(search.Quantize(emb.Vector, t.meta.Quantization))
+		var rx, ry, rw, rh any
+		if r := emb.Region; r != nil {
+			rx, ry, rw, rh = r.X, r.Y, r.W, r.H
+		}
+		_, err := t.tx.Exec(`
+			INSERT INTO expressions (path, position, expression, vector, scale, region_x, region_y, region_w, region_h)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.Path, i, emb.Expression, blob, scale, rx, ry, rw, rh)
+		if err != nil {
+			return fmt.Errorf("put entry %s: %w", entry.Path, err)
+		}
+	}
+	return nil
+}
+
+func (t *sqlTxn) Delete(path string) error {
+	if _, err := t.tx.Exec(`DELETE FROM entries WHERE path = ?`, path); err != nil {
+		return fmt.Errorf("delete entry %s: %w", path, err)
+	}
+	return nil
+}
+
+func (t *sqlTxn) PutFailure(f Failure) error {
+	_, err := t.tx.Exec(`
+		INSERT OR REPLACE INTO failures (path, class, error, attempts, last_attempt, next_retry, skipped, file_mod_time, file_size)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Path, f.Class, f.Error, f.Attempts, encodeTime(f.LastAttempt), encodeTime(f.NextRetry), f.Skipped, encodeTime(f.FileModTime), f.FileSize)
+	if err != nil {
+		return fmt.Errorf("put failure %s: %w", f.Path, err)
+	}
+	return nil
+}
+
+func (t *sqlTxn) DeleteFailure(path string) error {
+	if _, err := t.tx.Exec(`DELETE FROM failures WHERE path = ?`, path); err != nil {
+		return fmt.Errorf("delete failure %s: %w", path, err)
+	}
+	return nil
+}
+
+func (t *sqlTxn) SetMeta(meta Meta) error {
+	meta.Version = sqlVersion
+	if meta.Quantization != t.meta.Quantization {
+		if err := t.requantize(meta.Quantization); err != nil {
+			return err
+		}
+	}
+
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO meta (id, version, dim, quantization) VALUES (0, ?, ?, ?)`,
+		meta.Version, meta.Dim, string(meta.Quantization))
+	if err != nil {
+		return fmt.Errorf("set meta: %w", err)
+	}
+	t.meta = meta
+	return nil
+}
+
+// requantize re-encodes every stored vector at precision q.
+func (t *sqlTxn) requantize(q search.Quantization) error {
+	rows, err := t.tx.Query(`SELECT rowid, vector, scale FROM expressions`)
+	if err != nil {
+		return fmt.Errorf("requantize: %w", err)
+	}
+	type stored struct {
+		rowid int64
+		blob  []byte
+		scale float32
+	}
+	var updates []stored
+	for rows.Next() {
+		var (
+			st    stored
+			blob  []byte
+			scale float64
+		)
+		if err := rows.Scan(&st.rowid, &blob, &scale); err != nil {
+			rows.Close()
+			return fmt.Errorf("requantize: %w", err)
+		}
+		v, err := decodeVector(blob, float32(scale), t.meta.Quantization)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("requantize: %w", err)
+		}
+		st.blob, st.scale = encodeVector(search.Quantize(v, q))
+		updates = append(updates, st)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("requantize: %w", err)
+	}
+
+	for _, st := range updates {
+		if _, err := t.tx.Exec(`UPDATE expressions SET vector = ?, scale = ? WHERE rowid = ?`, st.blob, st.scale, st.rowid); err != nil {
+			return fmt.Errorf("requantize: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeVector returns the blob and scale qv is stored as.
+func encodeVector(qv search.QuantizedVector) ([]byte, float32) {
+	switch qv.Q {
+	case search.QuantizeFloat16:
+		blob := make([]byte, 0, 2*len(qv.F16))
+		for _, h := range qv.F16 {
+			blob = binary.LittleEndian.AppendUint16(blob, h)
+		}
+		return blob, 0
+	case search.QuantizeInt8:
+		blob := make([]byte, len(qv.I8))
+		for i, x := range qv.I8 {
+			blob[i] = byte(x)
+		}
+		return blob, qv.Scale
+	}
+	blob := make([]byte, 0, 4*len(qv.F32))
+	for _, x := range qv.F32 {
+		blob = binary.LittleEndian.AppendUint32(blob, math.Float32bits(x))
+	}
+	return blob, 0
+}
+
+// decodeVector expands a blob stored at precision q back to float32.
+func decodeVector(blob []byte, scale float32, q search.Quantization) ([]float32, error) {
+	qv := search.QuantizedVector{Q: q, Scale: scale}
+	switch q {
+	case search.QuantizeFloat16:
+		if len(blob)%2 != 0 {
+			return nil, fmt.Errorf("float16 vector of %d bytes", len(blob))
+		}
+		qv.F16 = make([]uint16, len(blob)/2)
+		for i := range qv.F16 {
+			qv.F16[i] = binary.LittleEndian.Uint16(blob[2*i:])
+		}
+	case search.QuantizeInt8:
+		qv.I8 = make([]int8, len(blob))
+		for i, b := range blob {
+			qv.I8[i] = int8(b)
+		}
+	default:
+		if len(blob)%4 != 0 {
+			return nil, fmt.Errorf("float32 vector of %d bytes", len(blob))
+		}
+		qv.F32 = make([]float32, len(blob)/4)
+		for i := range qv.F32 {
+			qv.F32[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*i:]))
+		}
+	}
+	return qv.Float32(), nil
+}
+
+// encodeTime stores t as RFC 3339 text, and the zero time as NULL.
+func encodeTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func decodeTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s.String)
+}
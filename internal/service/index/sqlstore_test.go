@@ -0,0 +1,141 @@
+package index_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+func TestSQLStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	idx := index.NewWithStore(index.NewSQLStore(path))
+
+	region := &search.Region{X: 0.5, Y: 0, W: 0.5, H: 1}
+	idx.Add(index.Entry{
+		Path:          "a.jpg",
+		Description:   "a dog on a beach",
+		Rung:          1,
+		Quality:       0.6,
+		QualityIssues: []string{"short"},
+		ContextHash:   "5f2c",
+		Embeddings: []index.ExpressionEmbedding{
+			{Expression: "dog", Vector: []float32{1, 0, 0}},
+			{Expression: "beach", Vector: []float32{0, 0.5, 0.25}, Region: region},
+		},
+	})
+	idx.Add(index.Entry{Path: "b.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "cat", Vector: []float32{0, 1, 0}}}})
+	when := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	idx.SetFailure(index.Failure{Path: "bad.jpg", Class: "decode", Error: "unexpected EOF", Attempts: 2, LastAttempt: when, Skipped: true, FileSize: 42})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	idx.Remove("b.jpg")
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := index.NewWithStore(index.NewSQLStore(path))
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Len() != 1 || loaded.Dim() != 3 {
+		t.Fatalf("expected 1 entry of dim 3, got %d of dim %d", loaded.Len(), loaded.Dim())
+	}
+
+	got, ok := loaded.Get("a.jpg")
+	if !ok {
+		t.Fatal("a.jpg missing after load")
+	}
+	if got.Description != "a dog on a beach" || got.Rung != 1 || got.Quality != 0.6 || got.ContextHash != "5f2c" ||
+		len(got.QualityIssues) != 1 || got.QualityIssues[0] != "short" {
+		t.Errorf("unexpected entry %+v", got)
+	}
+	if len(got.Embeddings) != 2 || got.Embeddings[1].Expression != "beach" || got.Embeddings[1].Vector[1] != 0.5 {
+		t.Fatalf("unexpected embeddings %+v", got.Embeddings)
+	}
+	if got.Embeddings[0].Region != nil || got.Embeddings[1].Region == nil || *got.Embeddings[1].Region != *region {
+		t.Errorf("unexpected regions %v and %v", got.Embeddings[0].Region, got.Embeddings[1].Region)
+	}
+
+	f, ok := loaded.Failure("bad.jpg")
+	if !ok || !f.LastAttempt.Equal(when) || !f.NextRetry.IsZero() || !f.Skipped || f.Attempts != 2 || f.FileSize != 42 {
+		t.Errorf("unexpected failure %+v (found %v)", f, ok)
+	}
+}
+
+func TestSQLStore_Quantized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	idx := index.NewWithStore(index.NewSQLStore(path))
+	idx.Add(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{0.6, -0.8}}}})
+	if err := idx.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	for _, q := range []search.Quantization{search.QuantizeFloat16, search.QuantizeInt8} {
+		idx.SetQuantization(q)
+		if err := idx.Save(); err != nil {
+			t.Fatalf("%s: save: %v", q, err)
+		}
+
+		store := index.NewSQLStore(path)
+		meta, err := store.Meta()
+		if err != nil || meta.Quantization != q {
+			t.Fatalf("%s: unexpected meta %+v (%v)", q, meta, err)
+		}
+		vectors, err := store.Vectors("a.jpg")
+		if err != nil || len(vectors) != 1 {
+			t.Fatalf("%s: unexpected vectors %v (%v)", q, vectors, err)
+		}
+		if d := vectors[0].Vector[1] + 0.8; d > 0.01 || d < -0.01 {
+			t.Errorf("%s: vector off by %.4f", q, d)
+		}
+	}
+}
+
+func TestSQLStore_UpdateIsAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	store := index.NewSQLStore(path)
+
+	err := store.Update(func(tx index.Tx) error {
+		return tx.Put(index.Entry{Path: "a.jpg", Embeddings: []index.ExpressionEmbedding{{Expression: "scene", Vector: []float32{1, 0}}}})
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	failed := errors.New("abort")
+	err = store.Update(func(tx index.Tx) error {
+		if err := tx.Delete("a.jpg"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the transaction error, got %v", err)
+	}
+
+	if n, err := index.NewSQLStore(path).Len(); err != nil || n != 1 {
+		t.Errorf("expected 1 committed entry, got %d (%v)", n, err)
+	}
+}
+
+func TestSQLStore_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	store := index.NewSQLStore(path)
+
+	if meta, err := store.Meta(); err != nil || meta != (index.Meta{}) {
+		t.Errorf("expected the zero Meta, got %+v (%v)", meta, err)
+	}
+	if n, err := store.Len(); err != nil || n != 0 {
+		t.Errorf("expected no entries, got %d (%v)", n, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("reads should not create the database, stat: %v", err)
+	}
+}
@@ -2,50 +2,507 @@ package index
 
 import (
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
 )
 
-// Save writes the index to disk using gob encoding.
-func (idx *Index) Save() error {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+// fileVersion is written in the header of every index file. Files from
+// before the header existed hold a bare map[string]Entry and are read as
+// full-precision version 0. Version 1 files hold a map of entries, which may
+// be followed by a map of failures. Version 2 files hold the failures first
+// and then stream the entries one by one, so neither reading nor rewriting
+// them needs every entry in memory at once.
+const fileVersion = 2
 
-	f, err := os.Create(idx.indexPath)
-	if err != nil {
-		return fmt.Errorf("create file: %w", err)
+// storedEntry is an Entry as written to disk, with its vectors at the
+// index's quantization.
+type storedEntry struct {
+	Path        string
+	Description string
+	Embeddings  []storedEmbedding
+	Rung        int
+
+	Quality       float32
+	QualityIssues []string
+	ContextHash   string
+}
+
+type storedEmbedding struct {
+	Expression string
+	Vector     search.QuantizedVector
+	Region     *search.Region
+}
+
+// GobStore is the default Store: the whole index in one gob file, a Meta
+// header followed by every failure and then every entry. It keeps only the
+// header and the failures in memory; entries are read from the file each time
+// they are asked for, and every committed Update rewrites the file, streaming
+// the entries it keeps from the previous one, through a temporary file and a
+// rename so a crash never leaves a half-written index behind.
+//
+// Rewriting the whole file makes Save cost as much as the index is large;
+// SQLStore only writes the entries that changed.
+type GobStore struct {
+	path string
+
+	mu       sync.RWMutex
+	loaded   bool
+	meta     Meta
+	count    int // number of entries, -1 until the file is scanned
+	failures map[string]Failure
+}
+
+// NewGobStore creates a GobStore backed by the file at path. The file is read
+// on first use; a missing file is an empty store.
+func NewGobStore(path string) *GobStore {
+	return &GobStore{path: path, count: -1}
+}
+
+// Meta implements Store.
+func (s *GobStore) Meta() (Meta, error) {
+	if err := s.load(); err != nil {
+		return Meta{}, err
 	}
-	defer f.Close()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.meta, nil
+}
+
+// errFound stops a scan once the entry looked for is found.
+var errFound = errors.New("found")
+
+// Get implements Store. It scans the file up to the entry.
+func (s *GobStore) Get(path string) (Entry, bool, error) {
+	var found Entry
+	err := s.Iterate(func(e Entry) error {
+		if e.Path != path {
+			return nil
+		}
+		found = e
+		return errFound
+	})
+	switch {
+	case errors.Is(err, errFound):
+		return found, true, nil
+	case err != nil:
+		return Entry{}, false, err
+	}
+	return Entry{}, false, nil
+}
+
+// Len implements Store. The first call scans the file.
+func (s *GobStore) Len() (int, error) {
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	count := s.count
+	s.mu.RUnlock()
+	if count >= 0 {
+		return count, nil
+	}
+
+	count = 0
+	s.mu.RLock()
+	err := s.scan(func(storedEntry) error {
+		count++
+		return nil
+	})
+	s.mu.RUnlock()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count < 0 { // an Update meanwhile counted its own file
+		s.count = count
+	}
+	return s.count, nil
+}
+
+// Iterate implements Store. Entries are decoded one at a time.
+func (s *GobStore) Iterate(fn func(Entry) error) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := s.scan(func(se storedEntry) error {
+		return fn(se.decode())
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Failures implements Store.
+func (s *GobStore) Failures() ([]Failure, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(idx.entries); err != nil {
-		return fmt.Errorf("encode: %w", err)
+	failures := make([]Failure, 0, len(s.failures))
+	for _, f := range s.failures {
+		failures = append(failures, f)
 	}
+	return failures, nil
+}
+
+// Vectors implements Store.
+func (s *GobStore) Vectors(path string) ([]ExpressionEmbedding, error) {
+	e, _, err := s.Get(path)
+	return e.Embeddings, err
+}
+
+// MemoryEstimate implements Store: the failures are the only thing a
+// GobStore keeps that grows with the index.
+func (s *GobStore) MemoryEstimate() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var n int64
+	for _, f := range s.failures {
+		n += failureBytes(f)
+	}
+	return n
+}
+
+// Update implements Store. The file is rewritten once per transaction.
+func (s *GobStore) Update(fn func(tx Tx) error) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &gobTx{
+		meta:     s.meta,
+		puts:     make(map[string]Entry),
+		deletes:  make(map[string]bool),
+		failures: make(map[string]*Failure),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.meta.Version = fileVersion
+
+	failures := make(map[string]Failure, len(s.failures)+len(tx.failures))
+	for path, f := range s.failures {
+		if _, changed := tx.failures[path]; !changed {
+			failures[path] = f
+		}
+	}
+	for path, f := range tx.failures {
+		if f != nil {
+			failures[path] = *f
+		}
+	}
+
+	count, err := s.write(tx, failures)
+	if err != nil {
+		return err
+	}
+	s.meta, s.count, s.failures = tx.meta, count, failures
+	return nil
+}
+
+// Close implements Store. A GobStore holds no open files.
+func (s *GobStore) Close() error {
+	return nil
+}
+
+// gobTx buffers the writes of one GobStore transaction.
+type gobTx struct {
+	meta     Meta
+	puts     map[string]Entry
+	deletes  map[string]bool
+	failures map[string]*Failure // nil to delete
+}
+
+func (tx *gobTx) Put(entry Entry) error {
+	tx.puts[entry.Path] = entry
+	delete(tx.deletes, entry.Path)
+	return nil
+}
+
+func (tx *gobTx) Delete(path string) error {
+	tx.deletes[path] = true
+	delete(tx.puts, path)
+	return nil
+}
+
+func (tx *gobTx) PutFailure(f Failure) error {
+	tx.failures[f.Path] = &f
+	return nil
+}
 
+func (tx *gobTx) DeleteFailure(path string) error {
+	tx.failures[path] = nil
 	return nil
 }
 
-// Load reads the index from disk. If the file doesn't exist, the index remains empty.
-func (idx *Index) Load() error {
-	idx.mu.Lock()
-	defer idx.mu.Unlock()
+func (tx *gobTx) SetMeta(meta Meta) error {
+	tx.meta = meta
+	return nil
+}
+
+// write encodes the entries of the current file changed by tx, and failures,
+// into a temporary file next to the index and renames it into place. It
+// returns the number of entries written. Must hold s.mu.
+func (s *GobStore) write(tx *gobTx, failures map[string]Failure) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return 0, fmt.Errorf("create file: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	count, err := s.encode(f, tx, failures)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return 0, fmt.Errorf("replace file: %w", err)
+	}
+	return count, nil
+}
+
+func (s *GobStore) encode(w io.Writer, tx *gobTx, failures map[string]Failure) (int, error) {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(tx.meta); err != nil {
+		return 0, fmt.Errorf("encode header: %w", err)
+	}
+	if err := enc.Encode(failures); err != nil {
+		return 0, fmt.Errorf("encode failures: %w", err)
+	}
+
+	count := 0
+	err := s.scan(func(se storedEntry) error {
+		if tx.deletes[se.Path] {
+			return nil
+		}
+		if _, ok := tx.puts[se.Path]; ok {
+			return nil
+		}
+		if tx.meta.Quantization != s.meta.Quantization {
+			se = encodeEntry(se.decode(), tx.meta.Quantization)
+		}
+		count++
+		return enc.Encode(se)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("copy entries: %w", err)
+	}
+	for _, e := range tx.puts {
+		if err := enc.Encode(encodeEntry(e, tx.meta.Quantization)); err != nil {
+			return 0, fmt.Errorf("encode: %w", err)
+		}
+		count++
+	}
 
-	if _, err := os.Stat(idx.indexPath); os.IsNotExist(err) {
+	// An entry without a path ends the stream.
+	if err := enc.Encode(storedEntry{}); err != nil {
+		return 0, fmt.Errorf("encode: %w", err)
+	}
+	return count, nil
+}
+
+// load reads the header and the failures once.
+func (s *GobStore) load() error {
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if loaded {
 		return nil
 	}
 
-	f, err := os.Open(idx.indexPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		return nil
+	}
+
+	meta, failures, err := s.readHeader()
+	if errors.Is(err, fs.ErrNotExist) {
+		meta, failures, err = Meta{}, make(map[string]Failure), nil
+		s.count = 0
+	}
+	if err != nil {
+		return err
+	}
+
+	s.meta, s.failures, s.loaded = meta, failures, true
+	return nil
+}
+
+// readHeader reads the Meta and the failures of the index file. Files before
+// version 2 keep their failures after the entries, so reading them decodes
+// the whole file.
+func (s *GobStore) readHeader() (Meta, map[string]Failure, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return Meta{}, nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	var meta Meta
+	if err := dec.Decode(&meta); err != nil {
+		meta, err := s.readLegacy(nil)
+		return meta, make(map[string]Failure), err
+	}
+	if meta.Version > fileVersion {
+		return Meta{}, nil, fmt.Errorf("index file version %d is newer than supported version %d", meta.Version, fileVersion)
+	}
+
+	if meta.Version < 2 {
+		var entries map[string]storedEntry
+		if err := dec.Decode(&entries); err != nil {
+			return Meta{}, nil, fmt.Errorf("decode: %w", err)
+		}
+	}
+
+	// Version 1 files written before failures were recorded end here.
+	var failures map[string]Failure
+	if err := dec.Decode(&failures); err != nil && !(meta.Version < 2 && errors.Is(err, io.EOF)) {
+		return Meta{}, nil, fmt.Errorf("decode failures: %w", err)
+	}
+	if failures == nil {
+		failures = make(map[string]Failure)
+	}
+	return meta, failures, nil
+}
+
+// scan decodes the entries of the index file one by one and calls fn for
+// each, stopping at the first error fn returns. Must hold s.mu.
+func (s *GobStore) scan(fn func(storedEntry) error) error {
+	f, err := os.Open(s.path)
 	if err != nil {
 		return fmt.Errorf("open file: %w", err)
 	}
 	defer f.Close()
 
-	var entries map[string]Entry
 	dec := gob.NewDecoder(f)
-	if err := dec.Decode(&entries); err != nil {
-		return fmt.Errorf("decode: %w", err)
+	var meta Meta
+	if err := dec.Decode(&meta); err != nil {
+		_, err := s.readLegacy(fn)
+		return err
 	}
 
-	idx.entries = entries
-	return nil
+	if meta.Version < 2 {
+		var entries map[string]storedEntry
+		if err := dec.Decode(&entries); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+		for _, se := range entries {
+			if err := fn(se); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var failures map[string]Failure
+	if err := dec.Decode(&failures); err != nil {
+		return fmt.Errorf("decode failures: %w", err)
+	}
+	for {
+		var se storedEntry
+		if err := dec.Decode(&se); err != nil {
+			return fmt.Errorf("decode: %w", err)
+		}
+		if se.Path == "" {
+			return nil
+		}
+		if err := fn(se); err != nil {
+			return err
+		}
+	}
+}
+
+// readLegacy decodes an index file written before the header existed, calls
+// fn for each of its entries when fn isn't nil, and returns the Meta implied
+// by them.
+func (s *GobStore) readLegacy(fn func(storedEntry) error) (Meta, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return Meta{}, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var legacy map[string]Entry
+	dec := gob.NewDecoder(f)
+	if err := dec.Decode(&legacy); err != nil {
+		return Meta{}, fmt.Errorf("decode: %w", err)
+	}
+
+	meta := Meta{}
+	for _, e := range legacy {
+		if meta.Dim == 0 {
+			meta.Dim = entryDim(e)
+		}
+		if fn != nil {
+			if err := fn(encodeEntry(e, search.QuantizeNone)); err != nil {
+				return Meta{}, err
+			}
+		}
+	}
+	return meta, nil
+}
+
+// encodeEntry stores e's vectors at precision q. Full precision vectors are
+// shared with e, not copied.
+func encodeEntry(e Entry, q search.Quantization) storedEntry {
+	se := storedEntry{
+		Path:          e.Path,
+		Description:   e.Description,
+		Rung:          e.Rung,
+		Quality:       e.Quality,
+		QualityIssues: e.QualityIssues,
+		ContextHash:   e.ContextHash,
+	}
+	for _, emb := range e.Embeddings {
+		se.Embeddings = append(se.Embeddings, storedEmbedding{
+			Expression: emb.Expression,
+			Vector:     search.Quantize(emb.Vector, q),
+			Region:     emb.Region,
+		})
+	}
+	return se
+}
+
+// decode expands se back to an Entry with float32 vectors.
+func (se storedEntry) decode() Entry {
+	e := Entry{
+		Path:          se.Path,
+		Description:   se.Description,
+		Rung:          se.Rung,
+		Quality:       se.Quality,
+		QualityIssues: se.QualityIssues,
+		ContextHash:   se.ContextHash,
+	}
+	for _, emb := range se.Embeddings {
+		e.Embeddings = append(e.Embeddings, ExpressionEmbedding{
+			Expression: emb.Expression,
+			Vector:     emb.Vector.Float32(),
+			Region:     emb.Region,
+		})
+	}
+	return e
 }
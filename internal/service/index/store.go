@@ -0,0 +1,125 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+// Backend selects the Store index files are kept in.
+type Backend string
+
+const (
+	// BackendGob keeps each index in a single gob file; see GobStore.
+	BackendGob Backend = "gob"
+
+	// BackendSQLite keeps each index in an SQLite database; see SQLStore.
+	BackendSQLite Backend = "sqlite"
+)
+
+// ParseBackend converts a config string to a Backend. Empty means
+// BackendGob.
+func ParseBackend(s string) (Backend, error) {
+	switch b := Backend(s); b {
+	case BackendGob, BackendSQLite:
+		return b, nil
+	case "":
+		return BackendGob, nil
+	}
+	return "", fmt.Errorf("unknown index store %q (want gob or sqlite)", s)
+}
+
+// NewStore returns the Store of backend b for the index file at path.
+func NewStore(b Backend, path string) Store {
+	if b == BackendSQLite {
+		return NewSQLStore(path)
+	}
+	return NewGobStore(path)
+}
+
+// Meta describes the vectors of an index and the format it is stored in.
+type Meta struct {
+	Version      int // of the store's format, set by the store on write
+	Dim          int // vector dimension, 0 for an empty index
+	Quantization search.Quantization
+}
+
+// Store persists the entries of one folder index. Entries go in and come out
+// with float32 vectors; a Store may keep them at the Quantization recorded
+// in its Meta.
+//
+// Reads see the last committed state. Writes only happen inside Update, so a
+// backend can apply them atomically.
+//
+// Two implementations exist: GobStore, a single gob file rewritten on every
+// Update, and SQLStore, an SQLite database updated in place. Neither keeps
+// the entries in memory itself, but the choice doesn't change what the
+// Index holds: it loads every entry and vector from either one.
+type Store interface {
+	// Meta returns the stored metadata. An empty store has the zero Meta.
+	Meta() (Meta, error)
+
+	// Get returns the entry stored for path.
+	Get(path string) (Entry, bool, error)
+
+	// Len returns the number of stored entries.
+	Len() (int, error)
+
+	// Iterate calls fn for every stored entry, in no particular order, and
+	// stops at the first error fn returns.
+	Iterate(fn func(Entry) error) error
+
+	// Failures returns the recorded failures.
+	Failures() ([]Failure, error)
+
+	// Vectors returns the expression embeddings of path without its other
+	// fields.
+	Vectors(path string) ([]ExpressionEmbedding, error)
+
+	// MemoryEstimate returns roughly how many bytes the store keeps in
+	// memory between calls.
+	MemoryEstimate() int64
+
+	// Update runs fn in a transaction: the writes fn makes through tx are
+	// committed together when it returns nil, and discarded otherwise.
+	Update(fn func(tx Tx) error) error
+
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// Tx is the write side of a Store transaction.
+type Tx interface {
+	Put(entry Entry) error
+	Delete(path string) error
+	PutFailure(f Failure) error
+	DeleteFailure(path string) error
+	// SetMeta replaces the metadata. Changing Quantization re-encodes the
+	// entries already stored.
+	SetMeta(meta Meta) error
+}
+
+// Copy writes the Meta, entries and failures of src into dst in one
+// transaction, for moving an index to another backend.
+func Copy(dst, src Store) error {
+	meta, err := src.Meta()
+	if err != nil {
+		return err
+	}
+	failures, err := src.Failures()
+	if err != nil {
+		return err
+	}
+
+	return dst.Update(func(tx Tx) error {
+		if err := tx.SetMeta(meta); err != nil {
+			return err
+		}
+		for _, f := range failures {
+			if err := tx.PutFailure(f); err != nil {
+				return err
+			}
+		}
+		return src.Iterate(tx.Put)
+	})
+}
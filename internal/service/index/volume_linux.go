@@ -0,0 +1,112 @@
+package index
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// volumeID returns the UUID of the file system holding path or, for file
+// systems without one, its statfs ID. Unlike the device number, which the
+// kernel hands out in mount order, the UUID is the same every time a disk is
+// mounted.
+func volumeID(path string) (string, error) {
+	if f, err := os.Open("/proc/self/mountinfo"); err == nil {
+		id, ok := uuidVolumeID(f, "/dev/disk/by-uuid", path)
+		f.Close()
+		if ok {
+			return id, nil
+		}
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return fmt.Sprintf("fsid:%08x%08x", uint32(st.Fsid.X__val[0]), uint32(st.Fsid.X__val[1])), nil
+}
+
+// uuidVolumeID finds the mount holding path in mountinfo and returns the
+// UUID of its source device, as named by a link in byUUID.
+func uuidVolumeID(mountinfo io.Reader, byUUID, path string) (string, bool) {
+	source, ok := mountSource(mountinfo, path)
+	if !ok {
+		return "", false
+	}
+	device, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", false
+	}
+
+	links, err := os.ReadDir(byUUID)
+	if err != nil {
+		return "", false
+	}
+	for _, link := range links {
+		target, err := filepath.EvalSymlinks(filepath.Join(byUUID, link.Name()))
+		if err == nil && target == device {
+			return "uuid:" + link.Name(), true
+		}
+	}
+	return "", false
+}
+
+// mountSource returns the source of the longest mount point holding path,
+// read from mountinfo lines: "id parent major:minor root mountpoint options
+// [optional fields] - fstype source superoptions".
+func mountSource(mountinfo io.Reader, path string) (string, bool) {
+	best, bestSource := "", ""
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+2 >= len(fields) {
+			continue
+		}
+
+		mountPoint := unescapeMountinfo(fields[4])
+		// Equal mount points: the later mount hides the earlier one.
+		if !underMount(path, mountPoint) || best != "" && len(mountPoint) < len(best) {
+			continue
+		}
+		best, bestSource = mountPoint, unescapeMountinfo(fields[sep+2])
+	}
+	return bestSource, best != "" && strings.HasPrefix(bestSource, "/")
+}
+
+// underMount reports whether path is mountPoint or inside it.
+func underMount(path, mountPoint string) bool {
+	return mountPoint == "/" || path == mountPoint || strings.HasPrefix(path, mountPoint+"/")
+}
+
+// unescapeMountinfo decodes the octal escapes mountinfo writes for spaces,
+// tabs, newlines and backslashes in paths.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
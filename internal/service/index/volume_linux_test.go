@@ -0,0 +1,69 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDisk creates a device file and a by-uuid directory linking uuid to it.
+func fakeDisk(t *testing.T, uuid string) (device, byUUID string) {
+	t.Helper()
+	dir := t.TempDir()
+	device = filepath.Join(dir, "sdb1")
+	if err := os.WriteFile(device, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	byUUID = filepath.Join(dir, "by-uuid")
+	if err := os.Mkdir(byUUID, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../sdb1", filepath.Join(byUUID, uuid)); err != nil {
+		t.Fatal(err)
+	}
+	return device, byUUID
+}
+
+func TestVolumeID_StableAcrossDeviceNumbers(t *testing.T) {
+	device, byUUID := fakeDisk(t, "1234-ABCD")
+
+	// The same card, mounted once as 8:17 and once as 8:33.
+	var ids []string
+	for _, devno := range []string{"8:17", "8:33"} {
+		mountinfo := strings.Join([]string{
+			"22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw",
+			"36 22 " + devno + " / /media/my\\040card rw,nosuid shared:20 - vfat " + device + " rw",
+		}, "\n")
+		id, ok := uuidVolumeID(strings.NewReader(mountinfo), byUUID, "/media/my card/DCIM")
+		if !ok {
+			t.Fatalf("%s: no UUID found", devno)
+		}
+		ids = append(ids, id)
+	}
+
+	if ids[0] != "uuid:1234-ABCD" || ids[1] != ids[0] {
+		t.Errorf("expected uuid:1234-ABCD for both mounts, got %v", ids)
+	}
+}
+
+func TestVolumeID_NoUUID(t *testing.T) {
+	_, byUUID := fakeDisk(t, "1234-ABCD")
+	mountinfo := "22 1 0:31 / / rw - tmpfs tmpfs rw\n"
+
+	if id, ok := uuidVolumeID(strings.NewReader(mountinfo), byUUID, "/photos"); ok {
+		t.Errorf("expected no UUID for a tmpfs mount, got %q", id)
+	}
+}
+
+func TestVolumeID(t *testing.T) {
+	dir := t.TempDir()
+	a, err := volumeID(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := volumeID(dir)
+	if err != nil || a != b {
+		t.Errorf("expected the same ID twice, got %q and %q (%v)", a, b, err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows && !linux
+
+package index
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// volumeID returns the statfs ID of the file system holding path.
+//
+// How well it tells volumes apart depends on the system. On macOS the statfs
+// ID is only the device number and the file system type, and device numbers
+// are handed out in mount order: two cards mounted one after the other can
+// share an ID, and a card remounted after other disks can get a new one,
+// which leaves its central index behind. Reading the volume UUID instead
+// needs getattrlist, which the syscall package doesn't offer. Where that
+// matters, keep the index in the folder or map it explicitly.
+func volumeID(path string) (string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return fmt.Sprintf("fsid:%08x%08x", uint32(st.Fsid.Val[0]), uint32(st.Fsid.Val[1])), nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package index
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// volumeID returns the serial number of the volume holding path.
+func volumeID(path string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	// Backup semantics let CreateFile open directories.
+	h, err := syscall.CreateFile(p, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+
+	var info syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &info); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vol:%08x", info.VolumeSerialNumber), nil
+}
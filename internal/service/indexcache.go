@@ -0,0 +1,236 @@
+package service
+
+import (
+	"container/list"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+// fileStamp identifies a version of an index file. The zero stamp stands for
+// a missing file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statIndex(indexPath string) (fileStamp, error) {
+	fi, err := os.Stat(indexPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+type cachedIndex struct {
+	key   string
+	idx   *index.Index
+	stamp fileStamp // of the file idx was loaded from or last saved to
+	pins  int
+}
+
+// indexLoad is a load of a folder index in flight, waited on by other gets
+// of the same folder.
+type indexLoad struct {
+	done chan struct{} // closed once the load finished
+	err  error
+}
+
+// indexCache keeps folder indexes loaded between calls. An index is reloaded
+// when its file changes on disk, for example because another machine indexed
+// a shared folder, and the least recently used ones are evicted once the
+// cache holds more than maxBytes. Pinned indexes are being written by this
+// process: they are trusted over the file and never evicted.
+type indexCache struct {
+	mu       sync.Mutex
+	maxBytes int64      // 0 means no cap
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+	loading  map[string]*indexLoad
+
+	// locate returns the index file path of a folder, and load reads it.
+	locate func(key string) (string, error)
+	load   func(key, indexPath string) (*index.Index, error)
+
+	// dropped is called, without the cache lock held, for every folder whose
+	// index was reloaded or evicted, to drop the state derived from it.
+	dropped func(key string)
+}
+
+func newIndexCache(maxBytes int64, locate func(string) (string, error), load func(string, string) (*index.Index, error), dropped func(string)) *indexCache {
+	return &indexCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		loading:  make(map[string]*indexLoad),
+		locate:   locate,
+		load:     load,
+		dropped:  dropped,
+	}
+}
+
+// get returns the index of a folder, loading it if it isn't cached or its
+// file changed since. If create is false and the folder has no index file,
+// get returns nil and caches nothing, so browsing un-indexed folders doesn't
+// fill the cache with empty indexes. Concurrent gets of a folder share one
+// load, run without the cache lock.
+func (c *indexCache) get(key string, create bool) (*index.Index, error) {
+	var dropped []string
+	defer func() {
+		for _, k := range dropped {
+			c.dropped(k)
+		}
+	}()
+
+	for {
+		indexPath, err := c.locate(key)
+		if err != nil {
+			return nil, err
+		}
+		stamp, err := statIndex(indexPath)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		if el, ok := c.items[key]; ok {
+			item := el.Value.(*cachedIndex)
+			if item.pins > 0 || item.stamp == stamp {
+				c.order.MoveToFront(el)
+				c.mu.Unlock()
+				return item.idx, nil
+			}
+			c.order.Remove(el)
+			delete(c.items, key)
+			dropped = append(dropped, key)
+		}
+
+		if !create && stamp == (fileStamp{}) {
+			c.mu.Unlock()
+			return nil, nil
+		}
+
+		// Another get is loading the folder: wait for it, then look again.
+		if l, ok := c.loading[key]; ok {
+			c.mu.Unlock()
+			<-l.done
+			if l.err != nil {
+				return nil, l.err
+			}
+			continue
+		}
+
+		// Load without the lock, so other folders aren't held up.
+		l := &indexLoad{done: make(chan struct{})}
+		c.loading[key] = l
+		c.mu.Unlock()
+
+		idx, err := c.load(key, indexPath)
+
+		c.mu.Lock()
+		delete(c.loading, key)
+		if err == nil {
+			c.items[key] = c.order.PushFront(&cachedIndex{key: key, idx: idx, stamp: stamp})
+			dropped = append(dropped, c.evict()...)
+		}
+		c.mu.Unlock()
+
+		l.err = err
+		close(l.done)
+		if err != nil {
+			return nil, err
+		}
+		return idx, nil
+	}
+}
+
+// current reports whether idx is the cached index of a folder.
+func (c *indexCache) current(key string, idx *index.Index) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	return ok && el.Value.(*cachedIndex).idx == idx
+}
+
+// pin returns the index of a folder and keeps it cached, as is, until unpin.
+func (c *indexCache) pin(key string) (*index.Index, error) {
+	for {
+		idx, err := c.get(key, true)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		el, ok := c.items[key]
+		if ok && el.Value.(*cachedIndex).idx == idx {
+			el.Value.(*cachedIndex).pins++
+			c.mu.Unlock()
+			return idx, nil
+		}
+		// Reloaded or evicted in between: try again.
+		c.mu.Unlock()
+	}
+}
+
+// unpin releases a pin taken by pin and records the current state of the
+// index file as the one the cached index matches, so this process's own
+// saves don't trigger a reload.
+func (c *indexCache) unpin(key string) {
+	indexPath, err := c.locate(key)
+	stamp := fileStamp{}
+	if err == nil {
+		stamp, err = statIndex(indexPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+	item := el.Value.(*cachedIndex)
+	item.pins--
+	if err == nil {
+		item.stamp = stamp
+	} else {
+		// Unknown state: reload on next access.
+		item.stamp = fileStamp{size: -1}
+	}
+}
+
+// evict removes the least recently used indexes until the cache fits in
+// maxBytes, and returns their keys. The most recently used index always
+// stays, however large. Must be called with c.mu held.
+func (c *indexCache) evict() []string {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+
+	var total int64
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		total += el.Value.(*cachedIndex).idx.MemoryEstimate()
+	}
+
+	var evicted []string
+	for el := c.order.Back(); el != nil && el != c.order.Front() && total > c.maxBytes; {
+		prev := el.Prev()
+		item := el.Value.(*cachedIndex)
+		if item.pins == 0 {
+			total -= item.idx.MemoryEstimate()
+			c.order.Remove(el)
+			delete(c.items, item.key)
+			evicted = append(evicted, item.key)
+		}
+		el = prev
+	}
+	return evicted
+}
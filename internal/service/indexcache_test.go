@@ -0,0 +1,254 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/index"
+)
+
+// fakeIndexes backs an indexCache with index files in a temporary directory
+// and counts the loads.
+type fakeIndexes struct {
+	dir string
+
+	mu      sync.Mutex
+	loads   map[string]int
+	dropped []string
+	err     error         // returned by load, when set
+	release chan struct{} // load waits for it to close, when set
+}
+
+func newFakeIndexes(t *testing.T) *fakeIndexes {
+	return &fakeIndexes{dir: t.TempDir(), loads: make(map[string]int)}
+}
+
+func (f *fakeIndexes) cache(maxBytes int64) *indexCache {
+	return newIndexCache(maxBytes, f.locate, f.load, f.drop)
+}
+
+func (f *fakeIndexes) locate(key string) (string, error) {
+	return filepath.Join(f.dir, key+".index"), nil
+}
+
+func (f *fakeIndexes) load(key, indexPath string) (*index.Index, error) {
+	f.mu.Lock()
+	f.loads[key]++
+	release, err := f.release, f.err
+	f.mu.Unlock()
+
+	if release != nil {
+		<-release
+	}
+	if err != nil {
+		return nil, err
+	}
+	return testIndex(indexPath), nil
+}
+
+func (f *fakeIndexes) drop(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dropped = append(f.dropped, key)
+}
+
+func (f *fakeIndexes) loadCount(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.loads[key]
+}
+
+// write creates or rewrites the index file of key with size bytes.
+func (f *fakeIndexes) write(t *testing.T, key string, size int) {
+	t.Helper()
+	path, _ := f.locate(key)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testIndex returns an index of one image, the same size for every path.
+func testIndex(indexPath string) *index.Index {
+	idx := index.New(indexPath)
+	idx.Add(index.Entry{
+		Path:       "a.jpg",
+		Embeddings: []index.ExpressionEmbedding{{Expression: "a cat", Vector: make([]float32, 256)}},
+	})
+	return idx
+}
+
+func mustGet(t *testing.T, c *indexCache, key string) *index.Index {
+	t.Helper()
+	idx, err := c.get(key, true)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	return idx
+}
+
+func TestIndexCache_ReloadsChangedFile(t *testing.T) {
+	f := newFakeIndexes(t)
+	c := f.cache(0)
+	f.write(t, "a", 10)
+
+	first := mustGet(t, c, "a")
+	if again := mustGet(t, c, "a"); again != first || f.loadCount("a") != 1 {
+		t.Fatalf("expected the cached index, got %d loads", f.loadCount("a"))
+	}
+
+	f.write(t, "a", 20)
+	if reloaded := mustGet(t, c, "a"); reloaded == first {
+		t.Error("expected a new index after the file changed")
+	}
+	if f.loadCount("a") != 2 {
+		t.Errorf("loads = %d, want 2", f.loadCount("a"))
+	}
+	if !slices.Equal(f.dropped, []string{"a"}) {
+		t.Errorf("dropped = %v, want [a]", f.dropped)
+	}
+}
+
+func TestIndexCache_MissingFile(t *testing.T) {
+	f := newFakeIndexes(t)
+	c := f.cache(0)
+
+	idx, err := c.get("a", false)
+	if err != nil || idx != nil {
+		t.Fatalf("expected no index, got %v, %v", idx, err)
+	}
+	if f.loadCount("a") != 0 {
+		t.Error("a missing index file should not be loaded unless created")
+	}
+
+	if mustGet(t, c, "a") == nil || f.loadCount("a") != 1 {
+		t.Error("expected a new index to be created")
+	}
+}
+
+func TestIndexCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	f := newFakeIndexes(t)
+	size := testIndex(filepath.Join(f.dir, "size.index")).MemoryEstimate()
+	c := f.cache(2*size + size/2) // room for two
+	for _, key := range []string{"a", "b", "c"} {
+		f.write(t, key, 10)
+	}
+
+	a := mustGet(t, c, "a")
+	b := mustGet(t, c, "b")
+	mustGet(t, c, "a") // a is now more recently used than b
+	cIdx := mustGet(t, c, "c")
+
+	if !slices.Equal(f.dropped, []string{"b"}) {
+		t.Errorf("dropped = %v, want [b]", f.dropped)
+	}
+	if c.current("b", b) {
+		t.Error("b should be evicted")
+	}
+	if !c.current("a", a) || !c.current("c", cIdx) {
+		t.Error("a and c should stay cached")
+	}
+}
+
+func TestIndexCache_PinnedIndex(t *testing.T) {
+	f := newFakeIndexes(t)
+	c := f.cache(1) // over the cap with any index
+	f.write(t, "a", 10)
+	f.write(t, "b", 10)
+
+	a, err := c.pin("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written by another process meanwhile: the pinned index is trusted.
+	f.write(t, "a", 20)
+	if got := mustGet(t, c, "a"); got != a || f.loadCount("a") != 1 {
+		t.Errorf("pinned index reloaded: %d loads", f.loadCount("a"))
+	}
+
+	// b is the most recently used; a is over the cap but pinned.
+	mustGet(t, c, "b")
+	if !c.current("a", a) {
+		t.Error("pinned index evicted")
+	}
+	if len(f.dropped) != 0 {
+		t.Errorf("dropped = %v, want none", f.dropped)
+	}
+}
+
+func TestIndexCache_UnpinRestamps(t *testing.T) {
+	f := newFakeIndexes(t)
+	c := f.cache(0)
+	f.write(t, "a", 10)
+
+	a, err := c.pin("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, "a", 20) // saved by this process while pinned
+	c.unpin("a")
+
+	if got := mustGet(t, c, "a"); got != a || f.loadCount("a") != 1 {
+		t.Errorf("own save triggered a reload: %d loads", f.loadCount("a"))
+	}
+
+	f.write(t, "a", 30) // saved by another process after unpin
+	if got := mustGet(t, c, "a"); got == a {
+		t.Error("expected a reload after the file changed unpinned")
+	}
+}
+
+func TestIndexCache_ConcurrentGetsShareOneLoad(t *testing.T) {
+	errLoad := errors.New("corrupt index")
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"success", nil},
+		{"error", errLoad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIndexes(t)
+			f.err = tt.err
+			f.release = make(chan struct{})
+			c := f.cache(0)
+			f.write(t, "a", 10)
+
+			const n = 8
+			idxs := make([]*index.Index, n)
+			errs := make([]error, n)
+			var wg sync.WaitGroup
+			for i := range n {
+				wg.Go(func() { idxs[i], errs[i] = c.get("a", true) })
+			}
+
+			// Let every get reach the load in flight before it finishes.
+			deadline := time.Now().Add(5 * time.Second)
+			for f.loadCount("a") == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(f.release)
+			wg.Wait()
+
+			if f.loadCount("a") != 1 {
+				t.Errorf("loads = %d, want 1", f.loadCount("a"))
+			}
+			for i := range n {
+				if !errors.Is(errs[i], tt.err) {
+					t.Errorf("get %d: error %v, want %v", i, errs[i], tt.err)
+				}
+				if idxs[i] != idxs[0] {
+					t.Errorf("get %d returned another index", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/service/description"
+)
+
+// Rungs of the describe retry ladder, recorded in index.Entry.Rung. Each rung
+// keeps the degradations of the ones before it. The numbers are stable, so a
+// rung left out by the configuration doesn't renumber the others.
+const (
+	RungConfigured   = iota // the configured settings
+	RungSmallerImage        // downscaled to DescribeFallback.MaxSide
+	RungSimplePrompt        // the fallback prompt
+	RungStrongDRY           // stronger anti-repetition penalties
+)
+
+var rungNames = [...]string{"configured", "smaller image", "simple prompt", "strong dry"}
+
+// RungName returns the name of a describe ladder rung.
+func RungName(rung int) string {
+	if rung < 0 || rung >= len(rungNames) {
+		return fmt.Sprintf("rung %d", rung)
+	}
+	return rungNames[rung]
+}
+
+// ErrUnusableDescription is returned when every rung of the ladder produced
+// a description not worth indexing, such as a loop or a refusal.
+var ErrUnusableDescription = errors.New("unusable description")
+
+// describeRung is one step of the describe retry ladder.
+type describeRung struct {
+	rung     int
+	settings description.Settings
+}
+
+// describeLadder returns the rungs an image is described with, in order,
+// starting from the configured settings. Rungs whose fallback setting is
+// unset or wouldn't degrade anything are left out.
+func describeLadder(base description.Settings, fb config.DescribeFallback) []describeRung {
+	ladder := []describeRung{{rung: RungConfigured, settings: base}}
+	cur := base
+
+	if fb.MaxSide > 0 && fb.MaxSide < cur.MaxSide {
+		cur.MaxSide = fb.MaxSide
+		ladder = append(ladder, describeRung{rung: RungSmallerImage, settings: cur})
+	}
+
+	if fb.UserPrompt != "" {
+		cur.Prompt.UserPrompt = fb.UserPrompt
+		if fb.SystemPrompt != "" {
+			cur.Prompt.SystemPrompt = fb.SystemPrompt
+		}
+		ladder = append(ladder, describeRung{rung: RungSimplePrompt, settings: cur})
+	}
+
+	if fb.DryMultiplier > cur.Prompt.DryMultiplier || fb.RepeatPenalty > cur.Prompt.RepeatPenalty {
+		if fb.DryMultiplier > cur.Prompt.DryMultiplier {
+			cur.Prompt.DryMultiplier = fb.DryMultiplier
+			// DRY needs a base and length to act on; keep configured ones.
+			cur.Prompt.DryBase = cmp.Or(cur.Prompt.DryBase, 1.75)
+			cur.Prompt.DryAllowedLength = cmp.Or(cur.Prompt.DryAllowedLength, 2)
+		}
+		cur.Prompt.RepeatPenalty = max(cur.Prompt.RepeatPenalty, fb.RepeatPenalty)
+		ladder = append(ladder, describeRung{rung: RungStrongDRY, settings: cur})
+	}
+
+	return ladder
+}
+
+// describeImage describes an image for indexing, stepping down the ladder
+// only while the output is unusable: empty, refused or looping, scoring
+// below the retry threshold, or too large for the model's context. Other
+// errors, such as timeouts, end the ladder, since settings meant to get a
+// better description out of the model won't fix them.
+//
+// It returns the best-scoring description found, with the rung it was
+// obtained at and its quality; an earlier rung wins ties. If no rung gives a
+// description scoring above 0, the last error is returned. Every rung
+// renders its prompts with vars.
+func (s *Service) describeImage(ctx context.Context, imgPath string, vars config.PromptVars) (description.DescribeResult, int, description.Quality, error) {
+	var (
+		best     description.DescribeResult
+		bestRung = -1
+		bestQ    description.Quality
+		lastErr  error
+	)
+
+ladder:
+	for i, r := range s.ladder {
+		if i > 0 {
+			s.log(ctx, "describe retry", "path", imgPath, "rung", RungName(r.rung), "reason", lastErr)
+		}
+
+		settings := r.settings
+		settings.Vars = vars
+
+		imgCtx, imgCancel := context.WithTimeout(ctx, describeImageTimeout)
+		result, err := s.describer.DescribeWith(imgCtx, imgPath, settings)
+		imgCancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return description.DescribeResult{}, 0, description.Quality{}, ctx.Err()
+
+		// No settings read an unreadable file or run an unloaded model.
+		case errors.Is(err, description.ErrUnreadableImage), errors.Is(err, description.ErrModelNotLoaded):
+			return description.DescribeResult{}, 0, description.Quality{}, err
+
+		case errors.Is(err, description.ErrEmptyDescription), contextFull(err):
+			lastErr = err
+			continue
+
+		case err != nil:
+			lastErr = err
+			break ladder
+		}
+
+		q := s.analyzer.Analyze(result)
+		if !q.Unusable() && (bestRung < 0 || q.Score > bestQ.Score) {
+			best, bestRung, bestQ = result, r.rung, q
+		}
+		if !q.Unusable() && q.Score >= s.retryBelow {
+			break
+		}
+		lastErr = fmt.Errorf("%w: quality %.2f: %v", ErrUnusableDescription, q.Score, q.Issues)
+	}
+
+	if bestRung < 0 {
+		return description.DescribeResult{}, 0, description.Quality{}, lastErr
+	}
+	if bestRung != RungConfigured || len(bestQ.Issues) > 0 {
+		s.log(ctx, "describe degraded", "path", imgPath, "rung", RungName(bestRung), "quality", bestQ.Score, "issues", bestQ.Issues)
+	}
+	return best, bestRung, bestQ, nil
+}
+
+// issueNames converts quality issues for storing on an index entry.
+func issueNames(issues []description.Issue) []string {
+	if len(issues) == 0 {
+		return nil
+	}
+	names := make([]string, len(issues))
+	for i, issue := range issues {
+		names[i] = string(issue)
+	}
+	return names
+}
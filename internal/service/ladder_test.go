@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/platform/logger"
+	"github.com/ramon-reichert/locallens/internal/service/description"
+)
+
+func TestDescribeLadder(t *testing.T) {
+	base := description.Settings{
+		MaxSide: 1024,
+		Prompt: config.VisionPrompt{
+			SystemPrompt:  "system",
+			UserPrompt:    "user",
+			DryMultiplier: 0.5,
+			RepeatPenalty: 1.1,
+		},
+	}
+
+	// degrade returns base changed by fn.
+	degrade := func(fn func(*description.Settings)) description.Settings {
+		s := base
+		fn(&s)
+		return s
+	}
+
+	tests := []struct {
+		name string
+		fb   config.DescribeFallback
+		want []describeRung
+	}{
+		{
+			name: "no fallback",
+			want: []describeRung{{RungConfigured, base}},
+		},
+		{
+			name: "smaller image",
+			fb:   config.DescribeFallback{MaxSide: 512},
+			want: []describeRung{
+				{RungConfigured, base},
+				{RungSmallerImage, degrade(func(s *description.Settings) { s.MaxSide = 512 })},
+			},
+		},
+		{
+			name: "image not smaller",
+			fb:   config.DescribeFallback{MaxSide: 2048},
+			want: []describeRung{{RungConfigured, base}},
+		},
+		{
+			name: "simple user prompt keeps the system prompt",
+			fb:   config.DescribeFallback{UserPrompt: "simple"},
+			want: []describeRung{
+				{RungConfigured, base},
+				{RungSimplePrompt, degrade(func(s *description.Settings) { s.Prompt.UserPrompt = "simple" })},
+			},
+		},
+		{
+			name: "system prompt alone is no rung",
+			fb:   config.DescribeFallback{SystemPrompt: "simple system"},
+			want: []describeRung{{RungConfigured, base}},
+		},
+		{
+			name: "weaker penalties",
+			fb:   config.DescribeFallback{DryMultiplier: 0.2, RepeatPenalty: 1.0},
+			want: []describeRung{{RungConfigured, base}},
+		},
+		{
+			name: "stronger repeat penalty only",
+			fb:   config.DescribeFallback{RepeatPenalty: 1.3},
+			want: []describeRung{
+				{RungConfigured, base},
+				{RungStrongDRY, degrade(func(s *description.Settings) { s.Prompt.RepeatPenalty = 1.3 })},
+			},
+		},
+		{
+			name: "stronger dry gets a base and length",
+			fb:   config.DescribeFallback{DryMultiplier: 1.5},
+			want: []describeRung{
+				{RungConfigured, base},
+				{RungStrongDRY, degrade(func(s *description.Settings) {
+					s.Prompt.DryMultiplier, s.Prompt.DryBase, s.Prompt.DryAllowedLength = 1.5, 1.75, 2
+				})},
+			},
+		},
+		{
+			name: "every rung keeps the ones before",
+			fb:   config.DescribeFallback{MaxSide: 512, SystemPrompt: "simple system", UserPrompt: "simple", DryMultiplier: 1.5, RepeatPenalty: 1.3},
+			want: []describeRung{
+				{RungConfigured, base},
+				{RungSmallerImage, degrade(func(s *description.Settings) { s.MaxSide = 512 })},
+				{RungSimplePrompt, degrade(func(s *description.Settings) {
+					s.MaxSide = 512
+					s.Prompt.SystemPrompt, s.Prompt.UserPrompt = "simple system", "simple"
+				})},
+				{RungStrongDRY, degrade(func(s *description.Settings) {
+					s.MaxSide = 512
+					s.Prompt.SystemPrompt, s.Prompt.UserPrompt = "simple system", "simple"
+					s.Prompt.DryMultiplier, s.Prompt.DryBase, s.Prompt.DryAllowedLength = 1.5, 1.75, 2
+					s.Prompt.RepeatPenalty = 1.3
+				})},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeLadder(base, tt.fb)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d rungs, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if got[i].rung != tt.want[i].rung {
+					t.Errorf("rung %d is %s, want %s", i, RungName(got[i].rung), RungName(tt.want[i].rung))
+				}
+				if g, w := got[i].settings, tt.want[i].settings; g.MaxSide != w.MaxSide || g.Prompt != w.Prompt {
+					t.Errorf("%s settings = %+v, want %+v", RungName(got[i].rung), got[i].settings, tt.want[i].settings)
+				}
+			}
+		})
+	}
+}
+
+// fakeDescriber answers DescribeWith with the outcome set for the MaxSide of
+// the settings, which tells the rungs of a test ladder apart. Its other
+// methods aren't implemented.
+type fakeDescriber struct {
+	imageDescriber
+	outcomes map[int]fakeOutcome
+	calls    []int // MaxSide of each call
+}
+
+type fakeOutcome struct {
+	result description.DescribeResult
+	err    error
+}
+
+func (f *fakeDescriber) DescribeWith(_ context.Context, _ string, settings description.Settings) (description.DescribeResult, error) {
+	f.calls = append(f.calls, settings.MaxSide)
+	o := f.outcomes[settings.MaxSide]
+	return o.result, o.err
+}
+
+func TestDescribeImage(t *testing.T) {
+	// Rung i of the test ladder describes at MaxSide rungSides[i].
+	rungSides := []int{1000, 900, 800, 700}
+	var ladder []describeRung
+	for i, side := range rungSides {
+		ladder = append(ladder, describeRung{rung: i, settings: description.Settings{MaxSide: side}})
+	}
+
+	// Usable descriptions score above retryBelow; truncated, short and blank
+	// ones score below it, in that order.
+	var (
+		good      = fakeOutcome{result: description.DescribeResult{Description: "a cat asleep on a red sofa", OutputTokens: 50}}
+		truncated = fakeOutcome{result: description.DescribeResult{Description: "a cat asleep on a red", OutputTokens: 50, Truncated: true}}
+		short     = fakeOutcome{result: description.DescribeResult{Description: "a cat", OutputTokens: 2}}
+		blank     = fakeOutcome{result: description.DescribeResult{Description: ""}}
+		empty     = fakeOutcome{err: description.ErrEmptyDescription}
+		full      = fakeOutcome{err: errors.New("chat: context window is full")}
+		crashed   = fakeOutcome{err: errors.New("model crashed")}
+		badFile   = fakeOutcome{err: description.ErrUnreadableImage}
+	)
+
+	tests := []struct {
+		name     string
+		outcomes []fakeOutcome // by rung
+		wantRung int
+		wantErr  error
+		wantCall int
+	}{
+		{"usable at first", []fakeOutcome{good}, RungConfigured, nil, 1},
+		{"empty continues", []fakeOutcome{empty, good}, 1, nil, 2},
+		{"context full continues", []fakeOutcome{full, full, good}, 2, nil, 3},
+		{"blank continues", []fakeOutcome{blank, good}, 1, nil, 2},
+		{"other error stops", []fakeOutcome{crashed, good}, 0, crashed.err, 1},
+		{"unreadable file stops", []fakeOutcome{badFile, good}, 0, description.ErrUnreadableImage, 1},
+		{"error keeps the best so far", []fakeOutcome{short, crashed, good}, RungConfigured, nil, 2},
+		{"best rung wins", []fakeOutcome{short, truncated, short, short}, 1, nil, 4},
+		{"earlier rung wins ties", []fakeOutcome{blank, short, short, short}, 1, nil, 4},
+		{"nothing usable", []fakeOutcome{blank, empty, blank, full}, 0, full.err, 4},
+		{"all blank", []fakeOutcome{blank, blank, blank, blank}, 0, ErrUnusableDescription, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeDescriber{outcomes: make(map[int]fakeOutcome)}
+			for i, o := range tt.outcomes {
+				fake.outcomes[rungSides[i]] = o
+			}
+			s := &Service{
+				log:        logger.Discard(),
+				describer:  fake,
+				ladder:     ladder,
+				analyzer:   description.NewAnalyzer(description.AnalyzerConfig{MinTokens: 5}),
+				retryBelow: 0.8,
+			}
+
+			result, rung, quality, err := s.describeImage(context.Background(), "a.jpg", config.PromptVars{})
+
+			if len(fake.calls) != tt.wantCall {
+				t.Errorf("described at %v, want %d calls", fake.calls, tt.wantCall)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rung != tt.wantRung {
+				t.Errorf("rung = %d, want %d", rung, tt.wantRung)
+			}
+			if want := tt.outcomes[tt.wantRung].result; result.Description != want.Description {
+				t.Errorf("description = %q, want %q", result.Description, want.Description)
+			}
+			if quality.Score != s.analyzer.Analyze(result).Score {
+				t.Errorf("quality = %v, want that of the description", quality)
+			}
+			if !slices.Equal(fake.calls, rungSides[:len(fake.calls)]) {
+				t.Errorf("rungs described out of order: %v", fake.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/service/description"
+	"github.com/ramon-reichert/locallens/internal/service/embedding"
+	"github.com/ramon-reichert/locallens/internal/service/index"
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+// describeTiles describes the tiles of an image that needs tiling, with the
+// settings of the ladder rung its overview was described at. Tiles whose
+// description is unusable are dropped. Failures only cost the tiles: the
+// image is still indexed with its overview.
+func (s *Service) describeTiles(ctx context.Context, imgPath string, rung int, vars config.PromptVars) []description.Tile {
+	settings := s.rungSettings(rung)
+	settings.Vars = vars
+
+	// One describe timeout per tile.
+	tilesCtx, tilesCancel := context.WithTimeout(ctx, describeImageTimeout*time.Duration(max(s.maxTiles, 1)))
+	defer tilesCancel()
+
+	tiles, err := s.describer.DescribeTiles(tilesCtx, imgPath, settings)
+	if err != nil {
+		s.log(ctx, "describe tiles error", "path", imgPath, "error", err)
+		return nil
+	}
+
+	usable := tiles[:0]
+	for _, t := range tiles {
+		if q := s.analyzer.Analyze(t.DescribeResult); q.Unusable() {
+			s.log(ctx, "tile description unusable", "path", imgPath, "region", t.Region, "issues", q.Issues)
+			continue
+		}
+		usable = append(usable, t)
+	}
+	return usable
+}
+
+// describeFrames describes the sampled frames of an animated image after
+// the first, like describeTiles does tiles.
+func (s *Service) describeFrames(ctx context.Context, imgPath string, rung int, vars config.PromptVars) []description.Frame {
+	settings := s.rungSettings(rung)
+	settings.Vars = vars
+
+	// One describe timeout per frame.
+	framesCtx, framesCancel := context.WithTimeout(ctx, describeImageTimeout*time.Duration(max(s.maxFrames, 1)))
+	defer framesCancel()
+
+	frames, err := s.describer.DescribeFrames(framesCtx, imgPath, settings)
+	if err != nil {
+		s.log(ctx, "describe frames error", "path", imgPath, "error", err)
+		return nil
+	}
+
+	usable := frames[:0]
+	for _, f := range frames {
+		if q := s.analyzer.Analyze(f.DescribeResult); q.Unusable() {
+			s.log(ctx, "frame description unusable", "path", imgPath, "frame", f.Index, "issues", q.Issues)
+			continue
+		}
+		usable = append(usable, f)
+	}
+	return usable
+}
+
+// rungSettings returns the settings of a describe ladder rung.
+func (s *Service) rungSettings(rung int) description.Settings {
+	for _, r := range s.ladder {
+		if r.rung == rung {
+			return r.settings
+		}
+	}
+	return s.describer.Settings()
+}
+
+// imagePart is the description of a part of an image, a tile or a frame,
+// whose expressions are indexed along with those of the whole image.
+type imagePart struct {
+	name        string // for logs
+	description string
+	region      *search.Region
+	limit       int // of new expressions kept, 0 for all
+}
+
+func tileParts(tiles []description.Tile, limit int) []imagePart {
+	parts := make([]imagePart, 0, len(tiles))
+	for _, t := range tiles {
+		parts = append(parts, imagePart{name: "tile " + t.Position(), description: t.Description, region: tileRegion(t), limit: limit})
+	}
+	return parts
+}
+
+func frameParts(frames []description.Frame, limit int) []imagePart {
+	parts := make([]imagePart, 0, len(frames))
+	for _, f := range frames {
+		parts = append(parts, imagePart{name: fmt.Sprintf("frame %d", f.Index), description: f.Description, limit: limit})
+	}
+	return parts
+}
+
+// embedParts categorizes the descriptions of parts, keeping the new
+// expressions of each up to its limit, then embeds the expressions of all
+// parts together, each once, tagged with the region of the first part it was
+// found in. Parts with the same description are categorized once, and
+// expressions already in seen, such as those of the overview, are skipped.
+// A part or an expression that fails is left out.
+func (s *Service) embedParts(ctx context.Context, imgPath string, parts []imagePart, vars config.PromptVars, seen []index.ExpressionEmbedding) ([]index.ExpressionEmbedding, int64) {
+	known := make(map[string]bool, len(seen))
+	for _, e := range seen {
+		known[strings.ToLower(e.Expression)] = true
+	}
+
+	categorized := make(map[string]bool, len(parts))
+	var pending []index.ExpressionEmbedding
+	for _, p := range parts {
+		key := strings.ToLower(strings.TrimSpace(p.description))
+		if categorized[key] {
+			continue // described alike: same expressions
+		}
+		categorized[key] = true
+
+		catCtx, catCancel := context.WithTimeout(ctx, categorizeTimeout)
+		catResult, err := s.categorizer.CategorizeWith(catCtx, p.description, vars)
+		catCancel()
+		if err != nil {
+			s.log(ctx, "categorize part error", "path", imgPath, "part", p.name, "error", err)
+			continue
+		}
+
+		added := 0
+		for _, expr := range catResult.Expressions {
+			expr = strings.TrimSpace(expr)
+			if expr == "" || known[strings.ToLower(expr)] {
+				continue
+			}
+			known[strings.ToLower(expr)] = true
+			pending = append(pending, index.ExpressionEmbedding{Expression: expr, Region: p.region})
+			if added++; added == p.limit {
+				break
+			}
+		}
+	}
+
+	embeddings := pending[:0]
+	var totalMS int64
+	for _, e := range pending {
+		embedCtx, embedCancel := context.WithTimeout(ctx, embedTimeout)
+		embedResult, err := s.embedder.Embed(embedCtx, embedding.Document, e.Expression)
+		embedCancel()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			s.log(ctx, "embed part error", "path", imgPath, "expression", e.Expression, "error", err)
+			continue
+		}
+		e.Vector = embedResult.Embedding
+		embeddings = append(embeddings, e)
+		totalMS += embedResult.Elapsed.Milliseconds()
+	}
+	return embeddings, totalMS
+}
+
+// tileRegion returns the region of a tile in fractions of the image size.
+func tileRegion(t description.Tile) *search.Region {
+	w, h := float32(t.ImageSize.X), float32(t.ImageSize.Y)
+	return &search.Region{
+		X: float32(t.Region.Min.X) / w,
+		Y: float32(t.Region.Min.Y) / h,
+		W: float32(t.Region.Dx()) / w,
+		H: float32(t.Region.Dy()) / h,
+	}
+}
+
+// withRegions sets the region of results whose expression closest to the
+// query was found in a tile, and returns results.
+func withRegions(idx *index.Index, results []search.Result, queryVec []float32) []search.Result {
+	for i := range results {
+		e, ok := idx.Get(results[i].Path)
+		if !ok || !hasRegions(e) {
+			continue
+		}
+
+		best := float32(-2)
+		for _, emb := range e.Embeddings {
+			if len(emb.Vector) != len(queryVec) {
+				continue
+			}
+			if score := search.CosineSimilarity(queryVec, emb.Vector); score > best {
+				best, results[i].Region = score, emb.Region
+			}
+		}
+	}
+	return results
+}
+
+func hasRegions(e index.Entry) bool {
+	for _, emb := range e.Embeddings {
+		if emb.Region != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/ramon-reichert/locallens/internal/platform/config"
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// earthRadiusKm is the mean Earth radius used for place distances.
+const earthRadiusKm = 6371.0
+
+// promptVars gathers the values the prompt templates of an image can use.
+// Metadata the image lacks is left zero.
+func (s *Service) promptVars(imgPath, folderContext string) config.PromptVars {
+	vars := config.PromptVars{
+		FileName: filepath.Base(imgPath),
+		Folders:  parentFolders(imgPath),
+		Context:  folderContext,
+	}
+	if len(vars.Folders) > 0 {
+		vars.Folder = vars.Folders[0]
+	}
+
+	x, err := image.ReadExif(imgPath)
+	if err != nil {
+		return vars
+	}
+	vars.Date = x.DateTimeOriginal
+	vars.Camera = x.Camera()
+	if x.HasGPS {
+		vars.GPS = fmt.Sprintf("%.5f, %.5f", x.Latitude, x.Longitude)
+		vars.Place = nearestPlace(s.places, x.Latitude, x.Longitude)
+	}
+	return vars
+}
+
+// parentFolders returns the names of the folders holding path, nearest
+// first, up to the root.
+func parentFolders(path string) []string {
+	var names []string
+	dir := filepath.Dir(path)
+	for {
+		name := filepath.Base(dir)
+		parent := filepath.Dir(dir)
+		if parent == dir || name == "." || name == string(filepath.Separator) {
+			return names
+		}
+		names = append(names, name)
+		dir = parent
+	}
+}
+
+// nearestPlace returns the name of the place nearest to a position among
+// the ones whose radius covers it, or "" if none does.
+func nearestPlace(places []config.Place, lat, lon float64) string {
+	name, best := "", math.Inf(1)
+	for _, p := range places {
+		d := distanceKm(lat, lon, p.Latitude, p.Longitude)
+		if d <= p.RadiusKm && d < best {
+			name, best = p.Name, d
+		}
+	}
+	return name
+}
+
+// distanceKm returns the great-circle distance between two positions, by the
+// haversine formula.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+// queryCacheSize is how many query embeddings are kept. Each one is a few KB,
+// and interactive search repeats queries a lot as the user types and pages.
+const queryCacheSize = 512
+
+// queryKey identifies an embedding: the same text embedded by another model,
+// or with another task prefix, is a different vector.
+type queryKey struct {
+	model  string
+	prefix string
+	text   string
+}
+
+type queryCacheItem struct {
+	key queryKey
+	vec []float32
+}
+
+// queryCache is an LRU cache of query embeddings. Cached vectors are shared
+// and must not be modified.
+type queryCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is most recently used
+	items map[queryKey]*list.Element
+}
+
+func newQueryCache(size int) *queryCache {
+	return &queryCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[queryKey]*list.Element),
+	}
+}
+
+func (c *queryCache) get(key queryKey) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*queryCacheItem).vec, true
+}
+
+func (c *queryCache) put(key queryKey, vec []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*queryCacheItem).vec = vec
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&queryCacheItem{key: key, vec: vec})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*queryCacheItem).key)
+	}
+}
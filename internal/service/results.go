@@ -0,0 +1,217 @@
+package service
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+// rankedResultsTTL is how long a fully ranked result set is kept for paging
+// through it. Short, because indexing may add entries at any time.
+const rankedResultsTTL = 2 * time.Minute
+
+// rankedLimit caps how many results an exact search ranks and keeps for
+// paging, so Total never exceeds it. Scoring still covers every image.
+const rankedLimit = 1000
+
+// rankedCacheSize caps how many ranked result sets are kept, each up to
+// rankedLimit results. Past it, the least recently used set is dropped.
+const rankedCacheSize = 32
+
+// SortOrder selects how search results are ordered.
+type SortOrder string
+
+const (
+	SortScore       SortOrder = ""            // best match first (default)
+	SortFileDate    SortOrder = "fileDate"    // newest modification time first
+	SortCaptureDate SortOrder = "captureDate" // newest EXIF capture time first, falling back to file date
+	SortName        SortOrder = "name"        // file name, A→Z
+	SortSize        SortOrder = "size"        // largest file first
+)
+
+// ParseSortOrder validates a sort order name. "score" is accepted as an alias
+// for the default order.
+func ParseSortOrder(s string) (SortOrder, bool) {
+	switch o := SortOrder(s); o {
+	case SortScore, SortFileDate, SortCaptureDate, SortName, SortSize:
+		return o, true
+	case "score":
+		return SortScore, true
+	}
+	return "", false
+}
+
+// rankedKey identifies a cached ranked result set.
+type rankedKey struct {
+	folder string
+	query  string
+	exact  bool
+}
+
+// rankedSet is the best entries of a folder ranked and calibrated for one
+// query.
+// File metadata used by the secondary sort orders is filled lazily.
+type rankedSet struct {
+	key     rankedKey
+	results []search.Result
+	expires time.Time
+
+	mu   sync.Mutex
+	meta map[string]fileMeta
+}
+
+// fileMeta holds the file attributes the secondary sort orders need.
+type fileMeta struct {
+	modTime     time.Time
+	captureTime time.Time
+	size        int64
+}
+
+// rankedCache keeps recently computed ranked result sets so paging through a
+// query doesn't re-embed it or re-score the whole folder. Sets expire after
+// rankedResultsTTL, and the least recently used ones are dropped past size.
+type rankedCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is most recently used
+	sets  map[rankedKey]*list.Element
+}
+
+func newRankedCache(size int) *rankedCache {
+	return &rankedCache{
+		size:  size,
+		order: list.New(),
+		sets:  make(map[rankedKey]*list.Element),
+	}
+}
+
+// get returns the live set for key, if any.
+func (c *rankedCache) get(key rankedKey) (*rankedSet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.sets[key]
+	if !ok {
+		return nil, false
+	}
+	set := el.Value.(*rankedSet)
+	if time.Now().After(set.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return set, true
+}
+
+// put stores results under key, and drops expired sets and the least
+// recently used ones past the size of the cache.
+func (c *rankedCache) put(key rankedKey, results []search.Result) *rankedSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, el := range c.sets {
+		if now.After(el.Value.(*rankedSet).expires) {
+			c.remove(el)
+		}
+	}
+	if el, ok := c.sets[key]; ok {
+		c.remove(el)
+	}
+
+	set := &rankedSet{
+		key:     key,
+		results: results,
+		expires: now.Add(rankedResultsTTL),
+		meta:    make(map[string]fileMeta),
+	}
+	c.sets[key] = c.order.PushFront(set)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return set
+}
+
+// invalidate drops every set computed for folder.
+func (c *rankedCache) invalidate(folder string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, el := range c.sets {
+		if k.folder == folder {
+			c.remove(el)
+		}
+	}
+}
+
+// remove drops the set at el. Must hold c.mu.
+func (c *rankedCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.sets, el.Value.(*rankedSet).key)
+}
+
+// fileMeta returns the sort attributes of path, reading them from disk on
+// first use. Missing files sort as zero values.
+func (set *rankedSet) fileMeta(path string, withCapture bool) fileMeta {
+	set.mu.Lock()
+	m, ok := set.meta[path]
+	set.mu.Unlock()
+	if ok && (!withCapture || !m.captureTime.IsZero()) {
+		return m
+	}
+
+	if fi, err := os.Stat(path); err == nil {
+		m.modTime = fi.ModTime()
+		m.size = fi.Size()
+	}
+	if withCapture {
+		if x, err := image.ReadExif(path); err == nil {
+			m.captureTime = x.DateTimeOriginal
+		}
+		if m.captureTime.IsZero() {
+			m.captureTime = m.modTime
+		}
+	}
+
+	set.mu.Lock()
+	set.meta[path] = m
+	set.mu.Unlock()
+	return m
+}
+
+// sortResults orders results in place by order. Ties keep their score order.
+func (set *rankedSet) sortResults(results []search.Result, order SortOrder) {
+	var less func(a, b search.Result) bool
+
+	switch order {
+	case SortFileDate:
+		less = func(a, b search.Result) bool {
+			return set.fileMeta(a.Path, false).modTime.After(set.fileMeta(b.Path, false).modTime)
+		}
+	case SortCaptureDate:
+		less = func(a, b search.Result) bool {
+			return set.fileMeta(a.Path, true).captureTime.After(set.fileMeta(b.Path, true).captureTime)
+		}
+	case SortName:
+		less = func(a, b search.Result) bool {
+			return strings.ToLower(filepath.Base(a.Path)) < strings.ToLower(filepath.Base(b.Path))
+		}
+	case SortSize:
+		less = func(a, b search.Result) bool {
+			return set.fileMeta(a.Path, false).size > set.fileMeta(b.Path, false).size
+		}
+	default:
+		return
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return less(results[i], results[j])
+	})
+}
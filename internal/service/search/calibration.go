@@ -0,0 +1,122 @@
+package search
+
+import "math"
+
+// Relevance labels how far a result's score stands out from the background
+// similarity of the query to unrelated expressions.
+type Relevance string
+
+const (
+	RelevanceStrong Relevance = "strong"
+	RelevanceWeak   Relevance = "weak"
+	RelevanceNone   Relevance = "none"
+)
+
+// rank orders relevance labels so they can be compared.
+func (r Relevance) rank() int {
+	switch r {
+	case RelevanceStrong:
+		return 2
+	case RelevanceWeak:
+		return 1
+	}
+	return 0
+}
+
+// AtLeast reports whether r is as relevant as min. An empty min accepts
+// every label.
+func (r Relevance) AtLeast(min Relevance) bool {
+	return r.rank() >= min.rank()
+}
+
+// minBackgroundStdDev floors the background spread so that tiny indexes, or
+// queries that score every expression alike, don't produce huge z-scores.
+const minBackgroundStdDev = 0.02
+
+// Calibration holds the z-score thresholds used to label results.
+type Calibration struct {
+	Strong float32 // z-score at or above which a result is a strong match
+	Weak   float32 // z-score at or above which a result is a weak match
+	Sample int     // max number of expressions sampled for the background
+}
+
+// Background summarizes the distribution of a query's cosine similarity to
+// expressions sampled from the index. Almost all expressions in a folder are
+// unrelated to any given query, so this approximates the score of "nothing
+// relevant" for the current embedding model and query.
+type Background struct {
+	Mean   float32
+	StdDev float32
+	N      int // number of sampled expressions
+}
+
+// NewBackground samples up to sample expression vectors evenly across entries
+// and returns the distribution of their similarity to query. Even striding
+// keeps the result deterministic for a given index.
+func NewBackground(query []float32, entries []Entry, sample int) Background {
+	total := 0
+	for _, e := range entries {
+		total += len(e.Expressions)
+	}
+	if total == 0 || sample <= 0 {
+		return Background{StdDev: minBackgroundStdDev}
+	}
+
+	step := max(1, total/sample)
+
+	var sum, sumSq float64
+	n, i := 0, 0
+	for _, e := range entries {
+		for _, fv := range e.Expressions {
+			if i%step == 0 && n < sample && len(fv.Vector) == len(query) {
+				s := float64(CosineSimilarity(query, fv.Vector))
+				sum += s
+				sumSq += s * s
+				n++
+			}
+			i++
+		}
+	}
+
+	return newBackground(sum, sumSq, n)
+}
+
+// newBackground turns the running sums of n similarities into a Background.
+func newBackground(sum, sumSq float64, n int) Background {
+	if n == 0 {
+		return Background{StdDev: minBackgroundStdDev}
+	}
+	mean := sum / float64(n)
+	std := math.Sqrt(max(0, sumSq/float64(n)-mean*mean))
+
+	return Background{
+		Mean:   float32(mean),
+		StdDev: float32(max(std, minBackgroundStdDev)),
+		N:      n,
+	}
+}
+
+// ZScore returns how many standard deviations score lies above the mean.
+func (b Background) ZScore(score float32) float32 {
+	return (score - b.Mean) / max(b.StdDev, minBackgroundStdDev)
+}
+
+// Label returns the relevance label for a z-score.
+func (c Calibration) Label(z float32) Relevance {
+	switch {
+	case z >= c.Strong:
+		return RelevanceStrong
+	case z >= c.Weak:
+		return RelevanceWeak
+	}
+	return RelevanceNone
+}
+
+// Calibrate sets Confidence and Relevance on every result in place.
+func Calibrate(results []Result, bg Background, c Calibration) {
+	for i := range results {
+		z := bg.ZScore(results[i].Score)
+		results[i].Confidence = z
+		results[i].Relevance = c.Label(z)
+	}
+}
@@ -0,0 +1,67 @@
+package search_test
+
+import (
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+func TestNewBackground(t *testing.T) {
+	entries := []search.Entry{
+		{Path: "a.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "x", Vector: []float32{1, 0}},
+			{Expression: "y", Vector: []float32{0, 1}},
+		}},
+		{Path: "b.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "x", Vector: []float32{1, 0}},
+			{Expression: "y", Vector: []float32{0, 1}},
+		}},
+	}
+
+	bg := search.NewBackground([]float32{1, 0}, entries, 100)
+
+	if bg.N != 4 {
+		t.Errorf("sampled = %d, want 4", bg.N)
+	}
+	if bg.Mean < 0.49 || bg.Mean > 0.51 {
+		t.Errorf("mean = %.4f, want ~0.5", bg.Mean)
+	}
+	if bg.StdDev < 0.49 || bg.StdDev > 0.51 {
+		t.Errorf("std dev = %.4f, want ~0.5", bg.StdDev)
+	}
+
+	if got := search.NewBackground([]float32{1, 0}, entries, 2); got.N != 2 {
+		t.Errorf("sampled = %d with sample 2, want 2", got.N)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	bg := search.Background{Mean: 0.3, StdDev: 0.05}
+	c := search.Calibration{Strong: 4, Weak: 2.5}
+
+	results := []search.Result{
+		{Path: "strong.jpg", Score: 0.55},
+		{Path: "weak.jpg", Score: 0.45},
+		{Path: "none.jpg", Score: 0.35},
+	}
+	search.Calibrate(results, bg, c)
+
+	want := []search.Relevance{search.RelevanceStrong, search.RelevanceWeak, search.RelevanceNone}
+	for i, w := range want {
+		if results[i].Relevance != w {
+			t.Errorf("%s: relevance = %q (z=%.2f), want %q", results[i].Path, results[i].Relevance, results[i].Confidence, w)
+		}
+	}
+}
+
+func TestRelevanceAtLeast(t *testing.T) {
+	if !search.RelevanceWeak.AtLeast("") {
+		t.Error("every label should pass an empty minimum")
+	}
+	if search.RelevanceWeak.AtLeast(search.RelevanceStrong) {
+		t.Error("weak should not pass a strong minimum")
+	}
+	if !search.RelevanceStrong.AtLeast(search.RelevanceWeak) {
+		t.Error("strong should pass a weak minimum")
+	}
+}
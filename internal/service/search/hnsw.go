@@ -0,0 +1,509 @@
+package search
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HNSWConfig holds the graph parameters of an HNSW index.
+type HNSWConfig struct {
+	M              int // max neighbors per node on upper levels (2*M on level 0)
+	EfConstruction int // candidate list size while inserting
+	EfSearch       int // minimum candidate list size while searching
+}
+
+// DefaultHNSWConfig returns parameters that keep recall above ~0.95 for
+// 768-dim sentence embeddings at a modest memory cost.
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 100, EfSearch: 64}
+}
+
+// hnswNode is one expression vector in the graph. The node holds no vector:
+// it names a row of the graph's Matrix, which is scored at the Matrix's own
+// precision, so the graph never holds a second copy of the embeddings.
+// Checksum tells whether the row is still the one the node was linked with.
+type hnswNode struct {
+	Path       string
+	Expression int       // position of the row among the Matrix rows of Path
+	Checksum   uint64    // vectorChecksum of the row
+	Friends    [][]int32 // neighbor node ids, one list per level
+	Deleted    bool
+}
+
+// HNSW is an approximate nearest neighbor index over the expression vectors
+// of a folder, based on hierarchical navigable small world graphs (Malkov &
+// Yashunin, 2016). The graph links the rows of a Matrix, added and removed
+// per entry so the graph can follow the Matrix incrementally. Removal only
+// tombstones nodes: they still route searches but are never returned.
+//
+// HNSW is safe for concurrent use.
+type HNSW struct {
+	mu       sync.RWMutex
+	cfg      HNSWConfig
+	levelMul float64
+	rng      *rand.Rand
+	m        *Matrix
+
+	nodes    []hnswNode
+	byPath   map[string][]int32
+	entry    int32
+	maxLevel int
+	deleted  int
+}
+
+// NewHNSW creates an empty graph over the rows of m.
+func NewHNSW(cfg HNSWConfig, m *Matrix) *HNSW {
+	return &HNSW{
+		cfg:      cfg,
+		levelMul: 1 / math.Log(float64(cfg.M)),
+		rng:      rand.New(rand.NewPCG(1, 2)),
+		m:        m,
+		byPath:   make(map[string][]int32),
+		entry:    -1,
+	}
+}
+
+// Len returns the number of entries in the graph.
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.byPath)
+}
+
+// Has reports whether the graph holds vectors for path.
+func (h *HNSW) Has(path string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.byPath[path]
+	return ok
+}
+
+// Paths returns the paths of every entry in the graph.
+func (h *HNSW) Paths() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	paths := make([]string, 0, len(h.byPath))
+	for p := range h.byPath {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Add inserts the Matrix rows of path, replacing any previously added for
+// the same path. A path the Matrix doesn't hold is only removed.
+func (h *HNSW) Add(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m.mu.RLock()
+	defer h.m.mu.RUnlock()
+
+	h.remove(path)
+
+	start, count, ok := h.m.entryRows(path)
+	if !ok {
+		return
+	}
+	ids := make([]int32, 0, count)
+	for i := range count {
+		ids = append(ids, h.insert(hnswNode{Path: path, Expression: i, Checksum: vectorChecksum(h.m.row(start + i))}))
+	}
+	h.byPath[path] = ids
+}
+
+// Remove tombstones every vector of path.
+func (h *HNSW) Remove(path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(path)
+}
+
+func (h *HNSW) remove(path string) {
+	ids, ok := h.byPath[path]
+	if !ok {
+		return
+	}
+	for _, id := range ids {
+		if !h.nodes[id].Deleted {
+			h.nodes[id].Deleted = true
+			h.deleted++
+		}
+	}
+	delete(h.byPath, path)
+}
+
+// Tombstones returns the fraction of graph nodes that are deleted. See
+// Compact.
+func (h *HNSW) Tombstones() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.nodes) == 0 {
+		return 0
+	}
+	return float64(h.deleted) / float64(len(h.nodes))
+}
+
+// Compact rebuilds the graph from its live nodes, dropping the tombstones
+// that removals and re-adds leave behind: they cost memory and search time
+// without ever being returned. Searches wait for the rebuild.
+func (h *HNSW) Compact() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.m.mu.RLock()
+	defer h.m.mu.RUnlock()
+
+	live := make([]hnswNode, 0, len(h.nodes)-h.deleted)
+	for _, node := range h.nodes {
+		if !node.Deleted {
+			live = append(live, hnswNode{Path: node.Path, Expression: node.Expression, Checksum: node.Checksum})
+		}
+	}
+
+	h.nodes, h.entry, h.maxLevel, h.deleted = nil, -1, 0, 0
+	h.byPath = make(map[string][]int32, len(h.byPath))
+	for _, node := range live {
+		h.byPath[node.Path] = append(h.byPath[node.Path], h.insert(node))
+	}
+}
+
+// Nearest returns up to n distinct entry paths, ordered by the similarity of
+// their closest expression vector to query, best first.
+func (h *HNSW) Nearest(query []float32, n int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.m.mu.RLock()
+	defer h.m.mu.RUnlock()
+
+	if h.entry < 0 || n <= 0 || len(query) != h.m.dim || vectorNorm(query) == 0 {
+		return nil
+	}
+	q := normalized(query)
+
+	ep := h.entry
+	epSim := h.sim(q, ep)
+	for level := h.maxLevel; level > 0; level-- {
+		ep, epSim = h.greedy(q, ep, epSim, level)
+	}
+
+	found := h.searchLayer(q, ep, epSim, max(h.cfg.EfSearch, n), 0)
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, c := range found {
+		node := &h.nodes[c.id]
+		if node.Deleted || seen[node.Path] {
+			continue
+		}
+		seen[node.Path] = true
+		paths = append(paths, node.Path)
+		if len(seen) == n {
+			break
+		}
+	}
+	return paths
+}
+
+// insert adds node to the graph and returns its id. Must hold h.mu and
+// h.m.mu.
+func (h *HNSW) insert(node hnswNode) int32 {
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMul)
+	node.Friends = make([][]int32, level+1)
+
+	id := int32(len(h.nodes))
+	h.nodes = append(h.nodes, node)
+
+	if h.entry < 0 {
+		h.entry = id
+		h.maxLevel = level
+		return id
+	}
+
+	q := h.vector(id)
+	ep := h.entry
+	epSim := h.sim(q, ep)
+	for l := h.maxLevel; l > level; l-- {
+		ep, epSim = h.greedy(q, ep, epSim, l)
+	}
+
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		found := h.searchLayer(q, ep, epSim, h.cfg.EfConstruction, l)
+
+		maxFriends := h.maxFriends(l)
+		friends := make([]int32, 0, maxFriends)
+		for _, c := range found {
+			if len(friends) == maxFriends {
+				break
+			}
+			friends = append(friends, c.id)
+		}
+		h.nodes[id].Friends[l] = friends
+
+		for _, f := range friends {
+			h.link(f, id, l)
+		}
+
+		ep, epSim = found[0].id, found[0].sim
+	}
+
+	if level > h.maxLevel {
+		h.entry = id
+		h.maxLevel = level
+	}
+
+	return id
+}
+
+// link adds to as a neighbor of from on level, dropping from's least similar
+// neighbor when the list is full.
+func (h *HNSW) link(from, to int32, level int) {
+	friends := append(h.nodes[from].Friends[level], to)
+	maxFriends := h.maxFriends(level)
+	if len(friends) > maxFriends {
+		src := h.vector(from)
+		worst, worstSim := 0, float32(math.Inf(1))
+		for i, f := range friends {
+			if s := h.sim(src, f); s < worstSim {
+				worst, worstSim = i, s
+			}
+		}
+		friends[worst] = friends[len(friends)-1]
+		friends = friends[:len(friends)-1]
+	}
+	h.nodes[from].Friends[level] = friends
+}
+
+func (h *HNSW) maxFriends(level int) int {
+	if level == 0 {
+		return 2 * h.cfg.M
+	}
+	return h.cfg.M
+}
+
+// greedy walks level towards q from ep and returns the closest node found.
+func (h *HNSW) greedy(q []float32, ep int32, epSim float32, level int) (int32, float32) {
+	for changed := true; changed; {
+		changed = false
+		for _, f := range h.nodes[ep].Friends[level] {
+			if s := h.sim(q, f); s > epSim {
+				ep, epSim, changed = f, s, true
+			}
+		}
+	}
+	return ep, epSim
+}
+
+// searchLayer runs a best-first search on level and returns up to ef nodes,
+// most similar first.
+func (h *HNSW) searchLayer(q []float32, ep int32, epSim float32, ef int, level int) []hnswCandidate {
+	visited := map[int32]bool{ep: true}
+	candidates := &hnswHeap{max: true}
+	found := &hnswHeap{}
+	heap.Push(candidates, hnswCandidate{ep, epSim})
+	heap.Push(found, hnswCandidate{ep, epSim})
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if found.Len() >= ef && c.sim < found.items[0].sim {
+			break
+		}
+		for _, f := range h.nodes[c.id].Friends[level] {
+			if visited[f] {
+				continue
+			}
+			visited[f] = true
+			s := h.sim(q, f)
+			if found.Len() < ef || s > found.items[0].sim {
+				heap.Push(candidates, hnswCandidate{f, s})
+				heap.Push(found, hnswCandidate{f, s})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	out := make([]hnswCandidate, found.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(found).(hnswCandidate)
+	}
+	return out
+}
+
+// row returns the Matrix row of node id. Nodes whose row is gone, such as
+// tombstones of removed entries, have none.
+func (h *HNSW) row(id int32) (int, bool) {
+	node := &h.nodes[id]
+	start, count, ok := h.m.entryRows(node.Path)
+	if !ok || node.Expression >= count {
+		return 0, false
+	}
+	return start + node.Expression, true
+}
+
+// vector returns the normalized vector of node id, or nil if it has no row.
+func (h *HNSW) vector(id int32) []float32 {
+	row, ok := h.row(id)
+	if !ok {
+		return nil
+	}
+	return normalized(h.m.row(row))
+}
+
+// sim returns the cosine similarity of the normalized q and node id, 0 when
+// either has no vector.
+func (h *HNSW) sim(q []float32, id int32) float32 {
+	row, ok := h.row(id)
+	if !ok || q == nil {
+		return 0
+	}
+	return h.m.dot(q, row)
+}
+
+// vectorChecksum returns the FNV-1a hash of the bits of v.
+func vectorChecksum(v []float32) uint64 {
+	h := fnv.New64a()
+	var b [4]byte
+	for _, x := range v {
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(x))
+		h.Write(b[:])
+	}
+	return h.Sum64()
+}
+
+func vectorNorm(v []float32) float32 {
+	var sum float32
+	for _, x := range v {
+		sum += x * x
+	}
+	return float32(math.Sqrt(float64(sum)))
+}
+
+// =========================================================================
+// Persistence
+
+// hnswFile is the persisted form of an HNSW graph.
+type hnswFile struct {
+	Config   HNSWConfig
+	Nodes    []hnswNode
+	Entry    int32
+	MaxLevel int
+}
+
+// Save writes the graph structure to path using gob encoding. Vectors are
+// not written: they stay in the Matrix; see LoadHNSW. The graph is written to a temporary file that
+// replaces path once complete, so a crash never leaves a truncated graph.
+func (h *HNSW) Save(path string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	file := hnswFile{Config: h.cfg, Nodes: h.nodes, Entry: h.entry, MaxLevel: h.maxLevel}
+	if err := gob.NewEncoder(f).Encode(file); err != nil {
+		f.Close()
+		return fmt.Errorf("encode: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
+
+// LoadHNSW reads a graph saved by Save and links its nodes back to the rows
+// of m. Nodes whose entry is gone from m, or whose rows changed since the
+// graph was saved (by checksum), are tombstoned with the rest of their
+// entry, so the caller only has to Add entries the graph is missing.
+func LoadHNSW(path string, m *Matrix) (*HNSW, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	var file hnswFile
+	if err := gob.NewDecoder(f).Decode(&file); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	h := NewHNSW(file.Config, m)
+	h.nodes = file.Nodes
+	h.entry = file.Entry
+	h.maxLevel = file.MaxLevel
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// A node is reused only when every row of its entry still matches the
+	// graph; otherwise the whole entry is tombstoned and must be re-added.
+	stale := make(map[string]bool)
+	for i := range h.nodes {
+		node := &h.nodes[i]
+		if node.Deleted {
+			h.deleted++
+			continue
+		}
+		row, ok := h.row(int32(i))
+		if !ok || vectorChecksum(m.row(row)) != node.Checksum {
+			stale[node.Path] = true
+		}
+		h.byPath[node.Path] = append(h.byPath[node.Path], int32(i))
+	}
+	for p, ids := range h.byPath {
+		if _, count, _ := m.entryRows(p); len(ids) != count {
+			stale[p] = true
+		}
+	}
+
+	// Tombstoned nodes still route searches, scoring 0 once their row is
+	// gone, but are never returned.
+	for p := range stale {
+		h.remove(p)
+	}
+
+	return h, nil
+}
+
+// =========================================================================
+// Candidate heap
+
+type hnswCandidate struct {
+	id  int32
+	sim float32
+}
+
+// hnswHeap is a min-heap by similarity, or a max-heap when max is set.
+type hnswHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (h *hnswHeap) Len() int { return len(h.items) }
+func (h *hnswHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].sim > h.items[j].sim
+	}
+	return h.items[i].sim < h.items[j].sim
+}
+func (h *hnswHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *hnswHeap) Push(x any)    { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *hnswHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
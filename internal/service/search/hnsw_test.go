@@ -0,0 +1,209 @@
+package search_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+// randomEntries returns n entries with perExpr random vectors of dim each.
+func randomEntries(n, perExpr, dim int, seed uint64) []search.Entry {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	entries := make([]search.Entry, n)
+	for i := range entries {
+		entries[i].Path = fmt.Sprintf("img%05d.jpg", i)
+		for j := range perExpr {
+			v := make([]float32, dim)
+			for d := range v {
+				v[d] = float32(rng.NormFloat64())
+			}
+			entries[i].Expressions = append(entries[i].Expressions, search.ExpressionVector{
+				Expression: fmt.Sprintf("expr %d", j),
+				Vector:     v,
+			})
+		}
+	}
+	return entries
+}
+
+// exactNearest returns the n paths whose best expression is most similar to q.
+func exactNearest(q []float32, entries []search.Entry, n int) []string {
+	var out []string
+	for _, r := range search.FindSimilar([][]float32{q}, bestOnly(entries), n) {
+		out = append(out, r.Path)
+	}
+	return out
+}
+
+// bestOnly splits entries into one pseudo-entry per expression, so that
+// FindSimilar with a single query reduces to plain cosine ranking. Paths
+// repeat and are deduplicated by the caller.
+func bestOnly(entries []search.Entry) []search.Entry {
+	var out []search.Entry
+	for _, e := range entries {
+		for _, ev := range e.Expressions {
+			out = append(out, search.Entry{Path: e.Path, Expressions: []search.ExpressionVector{ev}})
+		}
+	}
+	return out
+}
+
+func dedup(paths []string, n int) map[string]bool {
+	set := make(map[string]bool)
+	for _, p := range paths {
+		if len(set) == n {
+			break
+		}
+		set[p] = true
+	}
+	return set
+}
+
+// newGraph adds entries to a Matrix at precision q and links them in a new
+// graph over it.
+func newGraph(entries []search.Entry, q search.Quantization) (*search.HNSW, *search.Matrix) {
+	m := search.NewMatrix(q)
+	h := search.NewHNSW(search.DefaultHNSWConfig(), m)
+	for _, e := range entries {
+		m.Add(e)
+		h.Add(e.Path)
+	}
+	return h, m
+}
+
+func TestHNSW_Recall(t *testing.T) {
+	entries := randomEntries(1000, 3, 32, 1)
+	queries := randomEntries(20, 1, 32, 2)
+
+	// The graph scores through the Matrix, so recall must hold at reduced
+	// precision too.
+	for _, quant := range []search.Quantization{search.QuantizeNone, search.QuantizeInt8} {
+		h, _ := newGraph(entries, quant)
+		if h.Len() != len(entries) {
+			t.Fatalf("%q: Len = %d, want %d", quant, h.Len(), len(entries))
+		}
+
+		const n = 10
+		hits, total := 0, 0
+		for _, q := range queries {
+			qv := q.Expressions[0].Vector
+			want := dedup(exactNearest(qv, entries, 3*n), n)
+			for _, p := range h.Nearest(qv, n) {
+				if want[p] {
+					hits++
+				}
+			}
+			total += n
+		}
+
+		recall := float64(hits) / float64(total)
+		t.Logf("%q: recall@%d = %.3f", quant, n, recall)
+		if recall < 0.9 {
+			t.Errorf("%q: recall@%d = %.3f, want >= 0.9", quant, n, recall)
+		}
+	}
+}
+
+func TestHNSW_Remove(t *testing.T) {
+	entries := randomEntries(200, 2, 16, 3)
+	h, _ := newGraph(entries, search.QuantizeNone)
+
+	target := entries[42]
+	q := target.Expressions[0].Vector
+
+	if got := h.Nearest(q, 1); len(got) != 1 || got[0] != target.Path {
+		t.Fatalf("expected %s as nearest before remove, got %v", target.Path, got)
+	}
+
+	h.Remove(target.Path)
+
+	for _, p := range h.Nearest(q, 20) {
+		if p == target.Path {
+			t.Errorf("removed entry %s returned by Nearest", p)
+		}
+	}
+	if h.Has(target.Path) {
+		t.Error("Has should be false after remove")
+	}
+}
+
+func TestHNSW_SaveAndLoad(t *testing.T) {
+	entries := randomEntries(300, 2, 16, 4)
+	h, m := newGraph(entries, search.QuantizeNone)
+
+	path := filepath.Join(t.TempDir(), "test.hnsw")
+	if err := h.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	m.Remove(entries[299].Path) // the last entry is gone from the index
+
+	loaded, err := search.LoadHNSW(path, m)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if loaded.Len() != 299 {
+		t.Errorf("Len after load = %d, want 299", loaded.Len())
+	}
+	if loaded.Has(entries[299].Path) {
+		t.Error("entry missing from the index should not survive load")
+	}
+
+	q := entries[7].Expressions[1].Vector
+	if got := loaded.Nearest(q, 1); len(got) != 1 || got[0] != entries[7].Path {
+		t.Errorf("expected %s as nearest after load, got %v", entries[7].Path, got)
+	}
+}
+
+func TestHNSW_LoadChangedVectors(t *testing.T) {
+	entries := randomEntries(100, 2, 16, 5)
+	h, m := newGraph(entries, search.QuantizeNone)
+
+	path := filepath.Join(t.TempDir(), "test.hnsw")
+	if err := h.Save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// The image was described again: same number of expressions, new vectors.
+	changed := entries[10].Path
+	m.Add(search.Entry{Path: changed, Expressions: randomEntries(1, 2, 16, 6)[0].Expressions})
+
+	loaded, err := search.LoadHNSW(path, m)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Has(changed) {
+		t.Error("entry with changed vectors should be tombstoned on load")
+	}
+	if !loaded.Has(entries[11].Path) {
+		t.Error("unchanged entry should survive load")
+	}
+}
+
+func TestHNSW_Compact(t *testing.T) {
+	entries := randomEntries(200, 2, 16, 7)
+	h, _ := newGraph(entries, search.QuantizeNone)
+	for _, e := range entries[:100] {
+		h.Remove(e.Path)
+	}
+	if got := h.Tombstones(); got != 0.5 {
+		t.Fatalf("Tombstones = %.2f, want 0.50", got)
+	}
+
+	h.Compact()
+
+	if got := h.Tombstones(); got != 0 {
+		t.Errorf("Tombstones after compact = %.2f, want 0", got)
+	}
+	if h.Len() != 100 {
+		t.Errorf("Len after compact = %d, want 100", h.Len())
+	}
+	target := entries[150]
+	if got := h.Nearest(target.Expressions[0].Vector, 1); len(got) != 1 || got[0] != target.Path {
+		t.Errorf("expected %s as nearest after compact, got %v", target.Path, got)
+	}
+}
@@ -0,0 +1,518 @@
+package search
+
+import (
+	"container/heap"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// minRowsPerWorker keeps small folders on a single goroutine, where spawning
+// workers costs more than the scoring itself.
+const minRowsPerWorker = 4096
+
+// matrixEntry is one image in a Matrix: its rows are start..start+count.
+type matrixEntry struct {
+	path        string
+	description string
+	expressions []string
+	start       int
+	count       int
+	deleted     bool
+}
+
+// Matrix stores the expression vectors of a folder in one contiguous slab,
+// with an offset table mapping each image to its rows, and the inverse norm
+// of each row. Cosine similarity is then a plain dot product with the
+// normalized query, scaled once per row, and scoring walks memory linearly
+// instead of chasing one slice per expression.
+//
+// Rows are kept at the Matrix's Quantization and scored directly in that
+// form; only the query stays at full precision.
+//
+// Removed images leave dead rows behind until the slab is compacted, which
+// happens automatically once a quarter of it is dead. Vectors whose
+// dimension differs from the first vector added are skipped.
+//
+// Matrix is safe for concurrent use.
+type Matrix struct {
+	mu      sync.RWMutex
+	q       Quantization
+	dim     int
+	rows    int
+	f32     []float32
+	f16     []uint16
+	i8      []int8
+	scales  []float32 // per row, int8 only
+	inv     []float32 // per row, 1 / the norm of the vector
+	entries []matrixEntry
+	slots   map[string]int // path → position in entries
+	dead    int            // rows owned by deleted entries
+}
+
+// NewMatrix creates an empty Matrix storing rows at precision q.
+func NewMatrix(q Quantization) *Matrix {
+	return &Matrix{q: q, slots: make(map[string]int)}
+}
+
+// Dim returns the vector dimension, or 0 while the Matrix is empty.
+func (m *Matrix) Dim() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.dim
+}
+
+// Len returns the number of images in the Matrix.
+func (m *Matrix) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.slots)
+}
+
+// Bytes returns the memory held by the rows, including dead ones awaiting
+// compaction.
+func (m *Matrix) Bytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.f32)*4 + len(m.f16)*2 + len(m.i8) + len(m.scales)*4 + len(m.inv)*4)
+}
+
+// Add appends the vectors of entry, replacing the rows of any
+// previous entry with the same path. Duplicate expressions are stored once,
+// matching how FindTopK scores them.
+func (m *Matrix) Add(entry Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(entry.Path)
+
+	e := matrixEntry{path: entry.Path, description: entry.Description, start: m.rows}
+	seen := make(map[string]bool, len(entry.Expressions))
+	for _, ev := range entry.Expressions {
+		if seen[ev.Expression] || len(ev.Vector) == 0 {
+			continue
+		}
+		if m.dim == 0 {
+			m.dim = len(ev.Vector)
+		}
+		norm := vectorNorm(ev.Vector)
+		if len(ev.Vector) != m.dim || norm == 0 {
+			continue
+		}
+		seen[ev.Expression] = true
+		m.appendRow(ev.Vector, norm)
+		e.expressions = append(e.expressions, ev.Expression)
+		e.count++
+	}
+
+	m.slots[entry.Path] = len(m.entries)
+	m.entries = append(m.entries, e)
+}
+
+// Remove drops the rows of path.
+func (m *Matrix) Remove(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(path)
+}
+
+func (m *Matrix) remove(path string) {
+	slot, ok := m.slots[path]
+	if !ok {
+		return
+	}
+	m.entries[slot].deleted = true
+	m.dead += m.entries[slot].count
+	delete(m.slots, path)
+
+	if m.dead > 0 && m.dead*4 >= m.rows {
+		m.compact()
+	}
+}
+
+// appendRow adds one row at the Matrix's precision, along with the inverse
+// of its norm.
+func (m *Matrix) appendRow(v []float32, norm float32) {
+	switch m.q {
+	case QuantizeFloat16:
+		for _, x := range v {
+			m.f16 = append(m.f16, float16Bits(x))
+		}
+	case QuantizeInt8:
+		i8, scale := quantizeInt8(v)
+		m.i8 = append(m.i8, i8...)
+		m.scales = append(m.scales, scale)
+	default:
+		m.f32 = append(m.f32, v...)
+	}
+	m.inv = append(m.inv, 1/norm)
+	m.rows++
+}
+
+// compact rewrites the slab and offset table without deleted entries.
+func (m *Matrix) compact() {
+	live := m.rows - m.dead
+	c := &Matrix{q: m.q, dim: m.dim, slots: m.slots}
+	switch m.q {
+	case QuantizeFloat16:
+		c.f16 = make([]uint16, 0, live*m.dim)
+	case QuantizeInt8:
+		c.i8 = make([]int8, 0, live*m.dim)
+		c.scales = make([]float32, 0, live)
+	default:
+		c.f32 = make([]float32, 0, live*m.dim)
+	}
+	c.inv = make([]float32, 0, live)
+	c.entries = make([]matrixEntry, 0, len(m.slots))
+
+	for _, e := range m.entries {
+		if e.deleted {
+			continue
+		}
+		lo, hi := e.start*m.dim, (e.start+e.count)*m.dim
+		switch m.q {
+		case QuantizeFloat16:
+			c.f16 = append(c.f16, m.f16[lo:hi]...)
+		case QuantizeInt8:
+			c.i8 = append(c.i8, m.i8[lo:hi]...)
+			c.scales = append(c.scales, m.scales[e.start:e.start+e.count]...)
+		default:
+			c.f32 = append(c.f32, m.f32[lo:hi]...)
+		}
+		c.inv = append(c.inv, m.inv[e.start:e.start+e.count]...)
+		e.start = c.rows
+		c.rows += e.count
+		c.slots[e.path] = len(c.entries)
+		c.entries = append(c.entries, e)
+	}
+	m.f32, m.f16, m.i8, m.scales, m.inv = c.f32, c.f16, c.i8, c.scales, c.inv
+	m.rows, m.entries, m.dead = c.rows, c.entries, 0
+}
+
+// TopK returns the k images most similar to query, scored like FindTopK.
+// Scoring is sharded across GOMAXPROCS goroutines by image; only the final
+// k images get their ExpressionScores filled in.
+func (m *Matrix) TopK(query []float32, k int) []Result {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.slots) == 0 || k <= 0 || len(query) != m.dim {
+		return nil
+	}
+	q := normalized(query)
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), m.rows/minRowsPerWorker))
+	shard := (len(m.entries) + workers - 1) / workers
+
+	tops := make([]topHeap, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		lo, hi := w*shard, min((w+1)*shard, len(m.entries))
+		wg.Go(func() {
+			tops[w] = m.scoreRange(q, lo, hi, k)
+		})
+	}
+	wg.Wait()
+
+	var merged []scoredEntry
+	for _, t := range tops {
+		merged = append(merged, t...)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].score > merged[j].score
+	})
+	merged = merged[:min(k, len(merged))]
+
+	results := make([]Result, 0, len(merged))
+	for _, se := range merged {
+		e := &m.entries[se.slot]
+		scores := make(map[string]float32, e.count)
+		for i := range e.count {
+			scores[e.expressions[i]] = m.dot(q, e.start+i)
+		}
+		_, topExpr := sortExpressions(scores)
+		results = append(results, Result{
+			Path:             e.path,
+			Description:      e.description,
+			Score:            se.score,
+			ExpressionScores: topExpr,
+		})
+	}
+	return results
+}
+
+// scoreRange scores entries lo..hi and keeps the best k.
+func (m *Matrix) scoreRange(q []float32, lo, hi, k int) topHeap {
+	top := make(topHeap, 0, k+1)
+	var best [5]float32
+
+	for slot := lo; slot < hi; slot++ {
+		e := &m.entries[slot]
+		if e.deleted || e.count == 0 {
+			continue
+		}
+
+		// Keep the top 5 similarities in descending order.
+		n := 0
+		for i := range e.count {
+			s := m.dot(q, e.start+i)
+			j := min(n, len(best)-1)
+			if n == len(best) && s <= best[j] {
+				continue
+			}
+			for j > 0 && best[j-1] < s {
+				best[j] = best[j-1]
+				j--
+			}
+			best[j] = s
+			n = min(n+1, len(best))
+		}
+
+		score := aggregate(best[:n])
+		if len(top) < k {
+			heap.Push(&top, scoredEntry{slot, score})
+		} else if score > top[0].score {
+			top[0] = scoredEntry{slot, score}
+			heap.Fix(&top, 0)
+		}
+	}
+	return top
+}
+
+// Background samples up to sample rows evenly across the slab and returns
+// the distribution of their similarity to query. See NewBackground.
+func (m *Matrix) Background(query []float32, sample int) Background {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.rows == 0 || sample <= 0 || len(query) != m.dim {
+		return Background{StdDev: minBackgroundStdDev}
+	}
+	q := normalized(query)
+
+	step := max(1, m.rows/sample)
+	var sum, sumSq float64
+	n := 0
+	for _, e := range m.entries {
+		if e.deleted {
+			continue
+		}
+		for row := e.start; row < e.start+e.count; row++ {
+			if row%step != 0 || n == sample {
+				continue
+			}
+			s := float64(m.dot(q, row))
+			sum += s
+			sumSq += s * s
+			n++
+		}
+	}
+	return newBackground(sum, sumSq, n)
+}
+
+// Vectors returns the expression vectors of path as they were added, up to
+// the Matrix's precision, expanded back to float32. The vectors are copies.
+func (m *Matrix) Vectors(path string) ([]ExpressionVector, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	slot, ok := m.slots[path]
+	if !ok {
+		return nil, false
+	}
+	e := &m.entries[slot]
+	vectors := make([]ExpressionVector, e.count)
+	for i := range e.count {
+		vectors[i] = ExpressionVector{Expression: e.expressions[i], Vector: m.row(e.start + i)}
+	}
+	return vectors, true
+}
+
+// entryRows returns the first row and row count of path. Must hold m.mu.
+func (m *Matrix) entryRows(path string) (start, count int, ok bool) {
+	slot, ok := m.slots[path]
+	if !ok {
+		return 0, 0, false
+	}
+	e := &m.entries[slot]
+	return e.start, e.count, true
+}
+
+// row expands one row to float32.
+func (m *Matrix) row(row int) []float32 {
+	lo, hi := row*m.dim, (row+1)*m.dim
+	v := make([]float32, m.dim)
+	switch m.q {
+	case QuantizeFloat16:
+		for i, h := range m.f16[lo:hi] {
+			v[i] = float16Table[h]
+		}
+	case QuantizeInt8:
+		for i, x := range m.i8[lo:hi] {
+			v[i] = float32(x) * m.scales[row]
+		}
+	default:
+		copy(v, m.f32[lo:hi])
+	}
+	return v
+}
+
+// Similar returns the k images most similar to a multi-vector query, scored
+// like FindSimilar, leaving out the image at exclude.
+func (m *Matrix) Similar(queries [][]float32, k int, exclude string) []Result {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	qs := make([][]float32, 0, len(queries))
+	for _, q := range queries {
+		if len(q) == m.dim && vectorNorm(q) > 0 {
+			qs = append(qs, normalized(q))
+		}
+	}
+	if len(qs) == 0 || k <= 0 {
+		return nil
+	}
+
+	var results []Result
+	bestForQuery := make([]float32, len(qs))
+	for _, e := range m.entries {
+		if e.deleted || e.count == 0 || e.path == exclude {
+			continue
+		}
+
+		for i := range bestForQuery {
+			bestForQuery[i] = -1
+		}
+		scores := make(map[string]float32, e.count)
+		var sumExpr float32
+		for r := range e.count {
+			best := float32(-1)
+			for i, q := range qs {
+				s := m.dot(q, e.start+r)
+				best = max(best, s)
+				bestForQuery[i] = max(bestForQuery[i], s)
+			}
+			scores[e.expressions[r]] = best
+			sumExpr += best
+		}
+		var sumQuery float32
+		for _, s := range bestForQuery {
+			sumQuery += s
+		}
+
+		_, topExpr := sortExpressions(scores)
+		results = append(results, Result{
+			Path:             e.path,
+			Description:      e.description,
+			Score:            (sumQuery/float32(len(qs)) + sumExpr/float32(e.count)) / 2,
+			ExpressionScores: topExpr,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	return results[:min(k, len(results))]
+}
+
+// dot returns the cosine similarity of the normalized query q and a row.
+func (m *Matrix) dot(q []float32, row int) float32 {
+	lo, hi := row*m.dim, (row+1)*m.dim
+	switch m.q {
+	case QuantizeFloat16:
+		return dotFloat16(q, m.f16[lo:hi]) * m.inv[row]
+	case QuantizeInt8:
+		return dotInt8(q, m.i8[lo:hi]) * m.scales[row] * m.inv[row]
+	}
+	return dotFloat32(q, m.f32[lo:hi]) * m.inv[row]
+}
+
+// The dot kernels keep several running sums, so consecutive additions don't
+// wait on each other; a single sum leaves scoring bound by addition latency.
+
+func dotFloat32(q, v []float32) float32 {
+	v = v[:len(q)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(v); i += 4 {
+		s0 += q[i] * v[i]
+		s1 += q[i+1] * v[i+1]
+		s2 += q[i+2] * v[i+2]
+		s3 += q[i+3] * v[i+3]
+	}
+	for ; i < len(v); i++ {
+		s0 += q[i] * v[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// dotFloat16 converts through float16Table, which beats converting bits in
+// place. Eight sums hide the latency of the table loads.
+func dotFloat16(q []float32, v []uint16) float32 {
+	v = v[:len(q)]
+	var s0, s1, s2, s3, s4, s5, s6, s7 float32
+	i := 0
+	for ; i+8 <= len(v); i += 8 {
+		q, v := q[i:i+8:i+8], v[i:i+8:i+8]
+		s0 += q[0] * float16Table[v[0]]
+		s1 += q[1] * float16Table[v[1]]
+		s2 += q[2] * float16Table[v[2]]
+		s3 += q[3] * float16Table[v[3]]
+		s4 += q[4] * float16Table[v[4]]
+		s5 += q[5] * float16Table[v[5]]
+		s6 += q[6] * float16Table[v[6]]
+		s7 += q[7] * float16Table[v[7]]
+	}
+	for ; i < len(v); i++ {
+		s0 += q[i] * float16Table[v[i]]
+	}
+	return (s0 + s1) + (s2 + s3) + (s4 + s5) + (s6 + s7)
+}
+
+func dotInt8(q []float32, v []int8) float32 {
+	v = v[:len(q)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(v); i += 4 {
+		s0 += q[i] * float32(v[i])
+		s1 += q[i+1] * float32(v[i+1])
+		s2 += q[i+2] * float32(v[i+2])
+		s3 += q[i+3] * float32(v[i+3])
+	}
+	for ; i < len(v); i++ {
+		s0 += q[i] * float32(v[i])
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func normalized(v []float32) []float32 {
+	norm := vectorNorm(v)
+	out := make([]float32, len(v))
+	if norm == 0 {
+		return out
+	}
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// scoredEntry is an entry slot with its aggregate score.
+type scoredEntry struct {
+	slot  int
+	score float32
+}
+
+// topHeap is a min-heap of scored entries, used to keep the best k.
+type topHeap []scoredEntry
+
+func (h topHeap) Len() int           { return len(h) }
+func (h topHeap) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h topHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x any)        { *h = append(*h, x.(scoredEntry)) }
+func (h *topHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
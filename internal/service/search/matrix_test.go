@@ -0,0 +1,203 @@
+package search_test
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+func TestMatrix_TopKMatchesFindTopK(t *testing.T) {
+	entries := randomEntries(3000, 8, 32, 7)
+	m := search.NewMatrix(search.QuantizeNone)
+	for _, e := range entries {
+		m.Add(e)
+	}
+
+	query := randomEntries(1, 1, 32, 99)[0].Expressions[0].Vector
+	want := search.FindTopK(query, entries, 20)
+	got := m.TopK(query, 20)
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path {
+			t.Errorf("rank %d: expected %s, got %s", i, want[i].Path, got[i].Path)
+		}
+		if math.Abs(float64(got[i].Score-want[i].Score)) > 1e-4 {
+			t.Errorf("rank %d: expected score %.5f, got %.5f", i, want[i].Score, got[i].Score)
+		}
+	}
+}
+
+func TestMatrix_QuantizedRanking(t *testing.T) {
+	entries := randomEntries(2000, 8, 64, 5)
+	query := randomEntries(1, 1, 64, 17)[0].Expressions[0].Vector
+	want := search.FindTopK(query, entries, 10)
+
+	for _, q := range []search.Quantization{search.QuantizeFloat16, search.QuantizeInt8} {
+		m := search.NewMatrix(q)
+		for _, e := range entries {
+			m.Add(e)
+		}
+		got := m.TopK(query, 10)
+
+		// Quantization error may swap near-ties, but the best match and the
+		// scores must hold.
+		if got[0].Path != want[0].Path {
+			t.Errorf("%s: expected top result %s, got %s", q, want[0].Path, got[0].Path)
+		}
+		for i := range got {
+			if d := math.Abs(float64(got[i].Score - want[i].Score)); d > 0.01 {
+				t.Errorf("%s: rank %d score off by %.4f", q, i, d)
+			}
+		}
+	}
+}
+
+func TestMatrix_SimilarMatchesFindSimilar(t *testing.T) {
+	entries := randomEntries(500, 4, 32, 11)
+	m := search.NewMatrix(search.QuantizeNone)
+	for _, e := range entries {
+		m.Add(e)
+	}
+
+	var queries [][]float32
+	for _, ev := range entries[3].Expressions {
+		queries = append(queries, ev.Vector)
+	}
+	want := search.FindSimilar(queries, append(entries[:3:3], entries[4:]...), 10)
+	got := m.Similar(queries, 10, entries[3].Path)
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Path != want[i].Path {
+			t.Errorf("rank %d: expected %s, got %s", i, want[i].Path, got[i].Path)
+		}
+		if math.Abs(float64(got[i].Score-want[i].Score)) > 1e-4 {
+			t.Errorf("rank %d: expected score %.5f, got %.5f", i, want[i].Score, got[i].Score)
+		}
+	}
+}
+
+func TestMatrix_Vectors(t *testing.T) {
+	entry := randomEntries(1, 3, 32, 13)[0]
+
+	for _, q := range []search.Quantization{search.QuantizeNone, search.QuantizeFloat16, search.QuantizeInt8} {
+		m := search.NewMatrix(q)
+		m.Add(entry)
+
+		got, ok := m.Vectors(entry.Path)
+		if !ok || len(got) != len(entry.Expressions) {
+			t.Fatalf("%s: expected %d vectors, got %d (ok=%v)", q, len(entry.Expressions), len(got), ok)
+		}
+		for i, ev := range entry.Expressions {
+			if got[i].Expression != ev.Expression {
+				t.Errorf("%s: vector %d: expected expression %q, got %q", q, i, ev.Expression, got[i].Expression)
+			}
+			for d := range ev.Vector {
+				if diff := math.Abs(float64(got[i].Vector[d] - ev.Vector[d])); diff > 0.05 {
+					t.Fatalf("%s: vector %d dim %d off by %.4f", q, i, d, diff)
+				}
+			}
+		}
+	}
+
+	if _, ok := search.NewMatrix(search.QuantizeNone).Vectors("missing.jpg"); ok {
+		t.Error("expected no vectors for a missing path")
+	}
+}
+
+func TestMatrix_AddReplacesAndRemoveDrops(t *testing.T) {
+	m := search.NewMatrix(search.QuantizeNone)
+	m.Add(search.Entry{Path: "a.jpg", Expressions: []search.ExpressionVector{{Expression: "x", Vector: []float32{1, 0}}}})
+	m.Add(search.Entry{Path: "b.jpg", Expressions: []search.ExpressionVector{{Expression: "x", Vector: []float32{0, 1}}}})
+
+	// Re-adding a.jpg with a different vector replaces its rows.
+	m.Add(search.Entry{Path: "a.jpg", Expressions: []search.ExpressionVector{{Expression: "x", Vector: []float32{0, 2}}}})
+	if m.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", m.Len())
+	}
+	if r := m.TopK([]float32{1, 0}, 2); r[0].Score > 0.01 {
+		t.Errorf("expected replaced vector to score ~0, got %.3f for %s", r[0].Score, r[0].Path)
+	}
+
+	m.Remove("b.jpg")
+	r := m.TopK([]float32{0, 1}, 5)
+	if len(r) != 1 || r[0].Path != "a.jpg" {
+		t.Fatalf("expected only a.jpg after remove, got %v", r)
+	}
+}
+
+func TestMatrix_DimensionMismatch(t *testing.T) {
+	m := search.NewMatrix(search.QuantizeNone)
+	m.Add(search.Entry{Path: "a.jpg", Expressions: []search.ExpressionVector{{Expression: "x", Vector: []float32{1, 0, 0}}}})
+
+	if r := m.TopK([]float32{1, 0}, 5); r != nil {
+		t.Errorf("expected no results for a query of the wrong dimension, got %v", r)
+	}
+}
+
+func TestMatrix_BackgroundMatchesNewBackground(t *testing.T) {
+	entries := randomEntries(500, 4, 16, 3)
+	m := search.NewMatrix(search.QuantizeNone)
+	for _, e := range entries {
+		m.Add(e)
+	}
+
+	query := randomEntries(1, 1, 16, 11)[0].Expressions[0].Vector
+	want := search.NewBackground(query, entries, 200)
+	got := m.Background(query, 200)
+
+	if got.N != want.N {
+		t.Fatalf("expected %d samples, got %d", want.N, got.N)
+	}
+	if math.Abs(float64(got.Mean-want.Mean)) > 1e-4 || math.Abs(float64(got.StdDev-want.StdDev)) > 1e-4 {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+// benchSizes are synthetic folder sizes for the scoring benchmarks. Vectors
+// are kept at 256 dimensions with 5 expressions per image so the 100k case
+// fits in memory alongside its entries.
+var benchSizes = []int{10_000, 100_000}
+
+const (
+	benchDim     = 256
+	benchPerExpr = 5
+)
+
+func BenchmarkFindTopK(b *testing.B) {
+	for _, n := range benchSizes {
+		entries := randomEntries(n, benchPerExpr, benchDim, 1)
+		query := entries[0].Expressions[0].Vector
+		b.Run(fmt.Sprintf("images=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				search.FindTopK(query, entries, 1000)
+			}
+		})
+	}
+}
+
+func BenchmarkMatrixTopK(b *testing.B) {
+	for _, n := range benchSizes {
+		entries := randomEntries(n, benchPerExpr, benchDim, 1)
+		query := entries[0].Expressions[0].Vector
+		for _, q := range []search.Quantization{search.QuantizeNone, search.QuantizeFloat16, search.QuantizeInt8} {
+			m := search.NewMatrix(q)
+			for _, e := range entries {
+				m.Add(e)
+			}
+			b.Run(fmt.Sprintf("images=%d/quantization=%s", n, cmp.Or(string(q), "float32")), func(b *testing.B) {
+				for b.Loop() {
+					m.TopK(query, 1000)
+				}
+			})
+		}
+	}
+}
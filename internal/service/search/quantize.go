@@ -0,0 +1,175 @@
+package search
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quantization is the precision vectors are stored and scored at.
+type Quantization string
+
+const (
+	QuantizeNone    Quantization = ""        // float32, 4 bytes per dimension
+	QuantizeFloat16 Quantization = "float16" // IEEE half precision, 2 bytes per dimension
+	QuantizeInt8    Quantization = "int8"    // symmetric int8 with one float32 scale per vector, 1 byte per dimension
+)
+
+// ParseQuantization validates a quantization name. "float32" and "none" are
+// accepted for the default full precision.
+func ParseQuantization(s string) (Quantization, error) {
+	switch q := Quantization(s); q {
+	case QuantizeNone, QuantizeFloat16, QuantizeInt8:
+		return q, nil
+	case "float32", "none":
+		return QuantizeNone, nil
+	}
+	return "", fmt.Errorf("unknown quantization %q (want float32, float16 or int8)", s)
+}
+
+// QuantizedVector is a vector stored at reduced precision. Exactly one of
+// F32, F16 and I8 is set, matching Q.
+type QuantizedVector struct {
+	Q     Quantization
+	F32   []float32
+	F16   []uint16
+	I8    []int8
+	Scale float32 // I8 only: value = I8[i] * Scale
+}
+
+// Quantize stores v at precision q.
+func Quantize(v []float32, q Quantization) QuantizedVector {
+	qv := QuantizedVector{Q: q}
+	switch q {
+	case QuantizeFloat16:
+		qv.F16 = make([]uint16, len(v))
+		for i, x := range v {
+			qv.F16[i] = float16Bits(x)
+		}
+	case QuantizeInt8:
+		qv.I8, qv.Scale = quantizeInt8(v)
+	default:
+		qv.F32 = v
+	}
+	return qv
+}
+
+// Len returns the number of dimensions of qv.
+func (qv QuantizedVector) Len() int {
+	switch qv.Q {
+	case QuantizeFloat16:
+		return len(qv.F16)
+	case QuantizeInt8:
+		return len(qv.I8)
+	}
+	return len(qv.F32)
+}
+
+// Float32 expands qv back to float32.
+func (qv QuantizedVector) Float32() []float32 {
+	switch qv.Q {
+	case QuantizeFloat16:
+		v := make([]float32, len(qv.F16))
+		for i, h := range qv.F16 {
+			v[i] = float16Table[h]
+		}
+		return v
+	case QuantizeInt8:
+		v := make([]float32, len(qv.I8))
+		for i, x := range qv.I8 {
+			v[i] = float32(x) * qv.Scale
+		}
+		return v
+	}
+	return qv.F32
+}
+
+// quantizeInt8 maps v symmetrically onto -127..127, scaled by its largest
+// absolute component.
+func quantizeInt8(v []float32) ([]int8, float32) {
+	var maxAbs float32
+	for _, x := range v {
+		maxAbs = max(maxAbs, float32(math.Abs(float64(x))))
+	}
+	out := make([]int8, len(v))
+	if maxAbs == 0 {
+		return out, 0
+	}
+	scale := maxAbs / 127
+	for i, x := range v {
+		out[i] = int8(math.Round(float64(x / scale)))
+	}
+	return out, scale
+}
+
+// float16Bits converts f to IEEE 754 half precision, rounding to nearest even.
+// Values beyond the half range become infinities; tiny values become
+// subnormals or zero.
+func float16Bits(f float32) uint16 {
+	b := math.Float32bits(f)
+	sign := uint16(b>>16) & 0x8000
+	exp := int((b>>23)&0xff) - 127 + 15
+	mant := b & 0x7fffff
+
+	switch {
+	case (b>>23)&0xff == 0xff: // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		// Subnormal: shift the mantissa, with its implicit bit, into place.
+		mant |= 0x800000
+		shift := uint32(14 - exp)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || (rem == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	half := uint32(exp)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++ // may carry into the exponent, which is still correct
+	}
+	return sign | uint16(half)
+}
+
+// float16Table maps every half precision value to float32, 256 KiB that
+// keep float16 scoring as fast as float32.
+var float16Table [1 << 16]float32
+
+func init() {
+	for h := range float16Table {
+		float16Table[h] = float16Value(uint16(h))
+	}
+}
+
+// float16Value converts a half precision value to float32.
+func float16Value(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		v := float32(mant) / (1 << 24) // 2^-14 * mant/1024
+		if sign != 0 {
+			return -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
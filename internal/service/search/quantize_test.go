@@ -0,0 +1,52 @@
+package search_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ramon-reichert/locallens/internal/service/search"
+)
+
+func TestQuantize_Float16(t *testing.T) {
+	v := []float32{0, 1, -1, 0.5, 0.1, -0.333, 65504, 1e-5, 1e6}
+	got := search.Quantize(v, search.QuantizeFloat16).Float32()
+
+	want := []float32{0, 1, -1, 0.5, 0.099975586, -0.33300781, 65504, 1.001358e-05, float32(math.Inf(1))}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("float16(%g) = %g, want %g", v[i], got[i], want[i])
+		}
+	}
+}
+
+func TestQuantize_Int8(t *testing.T) {
+	v := []float32{0.9, -0.3, 0.05, 0, -0.9}
+	qv := search.Quantize(v, search.QuantizeInt8)
+	if qv.Len() != len(v) {
+		t.Fatalf("expected %d dimensions, got %d", len(v), qv.Len())
+	}
+
+	// Symmetric int8 is accurate to half a step of the largest component.
+	tolerance := 0.9 / 127 / 2
+	for i, x := range qv.Float32() {
+		if d := math.Abs(float64(x - v[i])); d > tolerance+1e-7 {
+			t.Errorf("int8 round trip of %g = %g (off by %g)", v[i], x, d)
+		}
+	}
+}
+
+func TestParseQuantization(t *testing.T) {
+	for in, want := range map[string]search.Quantization{
+		"":        search.QuantizeNone,
+		"float32": search.QuantizeNone,
+		"float16": search.QuantizeFloat16,
+		"int8":    search.QuantizeInt8,
+	} {
+		if got, err := search.ParseQuantization(in); err != nil || got != want {
+			t.Errorf("ParseQuantization(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := search.ParseQuantization("int4"); err == nil {
+		t.Error("expected an error for int4")
+	}
+}
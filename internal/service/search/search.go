@@ -2,6 +2,7 @@
 package search
 
 import (
+	"fmt"
 	"math"
 	"sort"
 )
@@ -12,11 +13,29 @@ type ExpressionVector struct {
 	Vector     []float32
 }
 
+// Region is a part of an image, in fractions of its width and height from
+// its top left corner.
+type Region struct {
+	X, Y, W, H float32
+}
+
 // Result represents a search result with similarity score.
 type Result struct {
 	Path        string
 	Description string
 	Score       float32
+	// Confidence is Score calibrated against the query's background
+	// similarity to the index, as a z-score. Set by Calibrate.
+	Confidence float32
+	// Relevance labels Confidence as strong, weak or none. Set by Calibrate.
+	Relevance Relevance
+	// Quality is the quality score of the image description, from 0 to 1.
+	// Set by the caller, which knows how the description was produced.
+	Quality float32
+	// Region is where in the image the expression that matches the query
+	// best was found, for images described in tiles. Nil when it describes
+	// the whole image. Set by the caller, which knows the regions.
+	Region *Region
 	// ExpressionScores holds the per-expression cosine similarity to the query, keyed by
 	// expression name. Useful for auditing why an image ranked where it did.
 	ExpressionScores []scoredExpressions
@@ -31,7 +50,8 @@ type Entry struct {
 
 // FindTopK finds the top-k images most similar to the query vector. Each image
 // is scored by aggregating the query's cosine similarity against every one of
-// its expression vectors.
+// its expression vectors. Vectors whose dimension differs from the query's
+// are skipped.
 func FindTopK(query []float32, entries []Entry, k int) []Result {
 	if len(entries) == 0 || k <= 0 {
 		return nil
@@ -42,8 +62,10 @@ func FindTopK(query []float32, entries []Entry, k int) []Result {
 	for _, entry := range entries {
 		expressionScores := make(map[string]float32, len(entry.Expressions))
 		for _, fv := range entry.Expressions {
-			s := CosineSimilarity(query, fv.Vector)
-			expressionScores[fv.Expression] = s
+			if len(fv.Vector) != len(query) {
+				continue
+			}
+			expressionScores[fv.Expression] = CosineSimilarity(query, fv.Vector)
 		}
 
 		topScores, topExpr := sortExpressions(expressionScores)
@@ -67,9 +89,96 @@ func FindTopK(query []float32, entries []Entry, k int) []Result {
 	return results[:k]
 }
 
-// CosineSimilarity computes the cosine similarity between two vectors.
+// NearDuplicateScore is the set-to-set similarity at or above which two images
+// are considered near duplicates (e.g. burst shots or re-encoded copies).
+const NearDuplicateScore = 0.97
+
+// FindSimilar finds the top-k entries most similar to a multi-vector query,
+// such as the expression vectors of another image. Each entry is scored with
+// a symmetric mean of best matches: every query vector is matched to its
+// closest expression in the entry and vice versa, and both means are averaged.
+// Expressions whose dimension differs from the queries' are skipped.
+func FindSimilar(queries [][]float32, entries []Entry, k int) []Result {
+	if len(queries) == 0 || len(entries) == 0 || k <= 0 {
+		return nil
+	}
+
+	results := make([]Result, 0, len(entries))
+
+	for _, entry := range entries {
+		if len(entry.Expressions) == 0 {
+			continue
+		}
+
+		// bestForQuery[i] is the best match of query i among the entry's
+		// expressions; expressionScores holds the best match of each
+		// expression among the queries.
+		bestForQuery := make([]float32, len(queries))
+		for i := range bestForQuery {
+			bestForQuery[i] = -1
+		}
+		expressionScores := make(map[string]float32, len(entry.Expressions))
+		var sumExpr float32
+		compared := 0
+
+		for _, fv := range entry.Expressions {
+			if len(fv.Vector) != len(queries[0]) {
+				continue
+			}
+			compared++
+			best := float32(-1)
+			for i, q := range queries {
+				s := CosineSimilarity(q, fv.Vector)
+				if s > best {
+					best = s
+				}
+				if s > bestForQuery[i] {
+					bestForQuery[i] = s
+				}
+			}
+			expressionScores[fv.Expression] = best
+			sumExpr += best
+		}
+
+		if compared == 0 {
+			continue
+		}
+		var sumQuery float32
+		for _, s := range bestForQuery {
+			sumQuery += s
+		}
+
+		score := (sumQuery/float32(len(queries)) + sumExpr/float32(compared)) / 2
+
+		_, topExpr := sortExpressions(expressionScores)
+
+		results = append(results, Result{
+			Path:             entry.Path,
+			Description:      entry.Description,
+			Score:            score,
+			ExpressionScores: topExpr,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	if k > len(results) {
+		k = len(results)
+	}
+
+	return results[:k]
+}
+
+// CosineSimilarity computes the cosine similarity between two vectors. It
+// panics if their lengths differ: vectors of different embedders or
+// dimensions can't be compared, and callers skip them instead.
 func CosineSimilarity(a, b []float32) float32 {
-	if len(a) != len(b) || len(a) == 0 {
+	if len(a) != len(b) {
+		panic(fmt.Sprintf("search: cosine similarity of vectors of dimension %d and %d", len(a), len(b)))
+	}
+	if len(a) == 0 {
 		return 0
 	}
 
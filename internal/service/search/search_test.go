@@ -97,6 +97,59 @@ func TestFindTopK_Empty(t *testing.T) {
 	}
 }
 
+// TestFindSimilar verifies that the multi-vector query ranks the entry sharing
+// the most expressions first and that an identical set scores ~1.0.
+func TestFindSimilar(t *testing.T) {
+	queries := [][]float32{{1, 0, 0, 0}, {0, 1, 0, 0}}
+
+	entries := []search.Entry{
+		{Path: "partial.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "dog", Vector: []float32{1, 0, 0, 0}},
+			{Expression: "car", Vector: []float32{0, 0, 1, 0}},
+		}},
+		{Path: "same.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "dog", Vector: []float32{1, 0, 0, 0}},
+			{Expression: "grass", Vector: []float32{0, 1, 0, 0}},
+		}},
+		{Path: "unrelated.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "sky", Vector: []float32{0, 0, 0, 1}},
+		}},
+	}
+
+	results := search.FindSimilar(queries, entries, 3)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	want := []string{"same.jpg", "partial.jpg", "unrelated.jpg"}
+	for i, w := range want {
+		if results[i].Path != w {
+			t.Errorf("position %d: expected %s, got %s", i, w, results[i].Path)
+		}
+	}
+
+	if results[0].Score < search.NearDuplicateScore {
+		t.Errorf("identical set score = %.4f, want >= %.2f", results[0].Score, search.NearDuplicateScore)
+	}
+	// partial: queries best = (1 + 0)/2, expressions best = (1 + 0)/2 → 0.5.
+	if results[1].Score > 0.51 || results[1].Score < 0.49 {
+		t.Errorf("partial.jpg score = %.4f, want ~0.5", results[1].Score)
+	}
+}
+
+func TestFindSimilar_Empty(t *testing.T) {
+	entries := []search.Entry{
+		{Path: "a.jpg", Expressions: []search.ExpressionVector{{Expression: "scene", Vector: []float32{1, 0}}}},
+	}
+
+	if results := search.FindSimilar(nil, entries, 5); results != nil {
+		t.Errorf("expected nil for empty query, got %v", results)
+	}
+	if results := search.FindSimilar([][]float32{{1, 0}}, nil, 5); results != nil {
+		t.Errorf("expected nil for empty entries, got %v", results)
+	}
+}
+
 func TestCosineSimilarity(t *testing.T) {
 	tests := []struct {
 		name string
@@ -127,12 +180,6 @@ func TestCosineSimilarity(t *testing.T) {
 			b:    []float32{},
 			want: 0.0,
 		},
-		{
-			name: "different lengths",
-			a:    []float32{1, 0},
-			b:    []float32{1, 0, 0},
-			want: 0.0,
-		},
 	}
 
 	for _, tt := range tests {
@@ -144,3 +191,29 @@ func TestCosineSimilarity(t *testing.T) {
 		})
 	}
 }
+
+func TestCosineSimilarity_DifferentLengths(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for vectors of different lengths")
+		}
+	}()
+	search.CosineSimilarity([]float32{1, 0}, []float32{1, 0, 0})
+}
+
+func TestFindTopK_SkipsOtherDimensions(t *testing.T) {
+	entries := []search.Entry{
+		{Path: "a.jpg", Expressions: []search.ExpressionVector{
+			{Expression: "old", Vector: []float32{1, 0, 0}},
+			{Expression: "scene", Vector: []float32{1, 0}},
+		}},
+	}
+
+	results := search.FindTopK([]float32{1, 0}, entries, 1)
+	if len(results) != 1 || len(results[0].ExpressionScores) != 1 {
+		t.Fatalf("expected one scored expression, got %+v", results)
+	}
+	if similar := search.FindSimilar([][]float32{{1, 0}}, entries, 1); len(similar) != 1 || similar[0].Score < 0.99 {
+		t.Errorf("expected the matching expression alone to count, got %+v", similar)
+	}
+}
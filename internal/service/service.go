@@ -3,9 +3,13 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"image/color"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,29 +19,106 @@ import (
 	"github.com/ramon-reichert/locallens/internal/service/categorization"
 	"github.com/ramon-reichert/locallens/internal/service/description"
 	"github.com/ramon-reichert/locallens/internal/service/embedding"
+	"github.com/ramon-reichert/locallens/internal/service/image"
 	"github.com/ramon-reichert/locallens/internal/service/index"
 	"github.com/ramon-reichert/locallens/internal/service/search"
+	"github.com/ramon-reichert/locallens/internal/service/thumbnail"
 )
 
 const (
 	indexFileName        = ".locallens.index"
+	sqliteIndexFileName  = ".locallens.sqlite"
 	describeImageTimeout = 4 * time.Minute
 	categorizeTimeout    = 2 * time.Minute
 	embedTimeout         = 1 * time.Minute
 )
 
+var (
+	// ErrNotIndexed is returned when an operation needs an image that has no
+	// entry in its folder index.
+	ErrNotIndexed = errors.New("image not indexed")
+)
+
+// imageDescriber is the vision side of the Service, implemented by
+// description.Describer. Tests of the describe ladder fake it.
+type imageDescriber interface {
+	Load(ctx context.Context) error
+	Unload(ctx context.Context) error
+	Settings() description.Settings
+	Describe(ctx context.Context, imagePath string) (description.DescribeResult, error)
+	DescribeWith(ctx context.Context, imagePath string, settings description.Settings) (description.DescribeResult, error)
+	DescribeTiles(ctx context.Context, imagePath string, settings description.Settings) ([]description.Tile, error)
+	DescribeFrames(ctx context.Context, imagePath string, settings description.Settings) ([]description.Frame, error)
+}
+
 // Service orchestrates indexing and search operations.
 type Service struct {
 	log         logger.Logger
-	describer   *description.Describer
+	describer   imageDescriber
 	categorizer *categorization.Categorizer
 	embedder    *embedding.Embedder
+	calibration search.Calibration
+
+	// quantization is the vector precision new folder indexes are created
+	// with. Existing indexes keep their own.
+	quantization search.Quantization
+	// backend is the store folder indexes are kept in.
+	backend index.Backend
+
+	// queries caches query embeddings so repeated searches skip the
+	// embedder. See embedQuery.
+	embedModelID string
+	queries      *queryCache
+
+	// ladder is the sequence of degraded settings an image is described
+	// with until one gives a description scoring at least retryBelow on the
+	// analyzer. See describeImage.
+	ladder     []describeRung
+	analyzer   *description.Analyzer
+	retryBelow float64
+
+	// maxTiles and tileExpressions bound the tiles of images described in
+	// tiles and the expressions kept from each, and maxFrames and
+	// frameExpressions do the same for the frames of animations. See
+	// describeTiles and describeFrames.
+	maxTiles         int
+	tileExpressions  int
+	maxFrames        int
+	frameExpressions int
+
+	// retry decides when images that failed to index are tried again.
+	retry retryPolicy
+
+	// places name EXIF positions for the prompt templates. See promptVars.
+	places []config.Place
+
+	// thumbs caches the thumbnails the UI shows. Indexed images get one of
+	// thumbSize right away; 0 disables that. See Thumbnail.
+	thumbs    *thumbnail.Cache
+	thumbSize int
+
+	// locator resolves where the index files of each folder are kept.
+	locator *index.Locator
 
 	// indexes caches per-folder indexes loaded from disk so repeat searches
 	// avoid reading and deserializing .locallens.index files. Keyed by the
-	// cleaned absolute folder path.
-	mu      sync.RWMutex
-	indexes map[string]*index.Index
+	// cleaned absolute folder path. See loadIndex.
+	indexes *indexCache
+
+	// ranked caches fully ranked result sets for paging. See SearchPage.
+	ranked *rankedCache
+
+	// anns holds the ANN graph of each large folder, keyed like indexes. A
+	// nil value means the graph is still being built. See annFor.
+	annMu        sync.Mutex
+	anns         map[string]*search.HNSW
+	annMinImages int
+
+	// visionUsers counts the callers currently relying on the describer and
+	// categorizer models, so an image query running alongside an indexing
+	// job doesn't unload the models from under it.
+	visionMu    sync.Mutex
+	visionUsers int
 }
 
 // Config holds configuration for creating a Service.
@@ -54,6 +135,56 @@ type Config struct {
 // every Search call needs it to vectorize the query, and per-image embedding
 // during indexing reuses the same loaded model.
 func New(ctx context.Context, cfg Config) (*Service, error) {
+	quantization, err := search.ParseQuantization(cfg.AppCfg.Index.Quantization)
+	if err != nil {
+		return nil, fmt.Errorf("index config: %w", err)
+	}
+	backend, err := index.ParseBackend(cfg.AppCfg.Index.Store)
+	if err != nil {
+		return nil, fmt.Errorf("index config: %w", err)
+	}
+	location, err := index.ParseLocation(cfg.AppCfg.Index.Location)
+	if err != nil {
+		return nil, fmt.Errorf("index config: %w", err)
+	}
+	locator, err := index.NewLocator(index.LocatorConfig{
+		Location:   location,
+		CentralDir: cfg.AppCfg.Index.Dir,
+		Mapping:    cfg.AppCfg.Index.Mapping,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("index config: %w", err)
+	}
+	for ext, args := range cfg.AppCfg.Image.ExternalDecoders {
+		d, err := image.NewCommandDecoder(args)
+		if err != nil {
+			return nil, fmt.Errorf("image config: %s: %w", ext, err)
+		}
+		image.RegisterDecoder(ext, d)
+	}
+	image.SetLimits(image.Limits{
+		MaxPixels:  int64(cfg.AppCfg.Image.MaxMegapixels) * 1_000_000,
+		MaxBytes:   int64(cfg.AppCfg.Image.MaxFileMB) << 20,
+		Timeout:    time.Duration(cfg.AppCfg.Image.DecodeTimeoutSeconds) * time.Second,
+		MaxDecodes: cfg.AppCfg.Image.MaxConcurrentDecodes,
+	})
+	sampling, err := image.ParseSampling(cfg.AppCfg.Animation.Sampling)
+	if err != nil {
+		return nil, fmt.Errorf("animation config: %w", err)
+	}
+	animationMode, err := description.ParseAnimationMode(cfg.AppCfg.Animation.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("animation config: %w", err)
+	}
+	var background color.Color // nil flattens onto white
+	if cfg.AppCfg.Image.Background != "" {
+		c, err := image.ParseColor(cfg.AppCfg.Image.Background)
+		if err != nil {
+			return nil, fmt.Errorf("image config: %w", err)
+		}
+		background = c
+	}
+
 	s := &Service{
 		log: cfg.Log,
 		describer: description.New(description.Config{
@@ -62,6 +193,15 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 			Vision:  cfg.AppCfg.Vision,
 			Prompt:  cfg.AppCfg.DescribePrompt,
 			MaxSide: cfg.AppCfg.Image.MaxSide,
+
+			Background:  background,
+			JPEGQuality: cfg.AppCfg.Image.JPEGQuality,
+			Tiling:      cfg.AppCfg.Tiling,
+			Animation: description.Animation{
+				Frames:   cfg.AppCfg.Animation.Frames,
+				Sampling: sampling,
+				Mode:     animationMode,
+			},
 		}),
 		categorizer: categorization.New(categorization.Config{
 			Log:    cfg.Log,
@@ -74,8 +214,40 @@ func New(ctx context.Context, cfg Config) (*Service, error) {
 			Paths: cfg.EmbedPaths,
 			Embed: cfg.AppCfg.Embed,
 		}),
-		indexes: make(map[string]*index.Index),
+		calibration: search.Calibration{
+			Strong: float32(cfg.AppCfg.Search.StrongRelevance),
+			Weak:   float32(cfg.AppCfg.Search.WeakRelevance),
+			Sample: cfg.AppCfg.Search.BackgroundSample,
+		},
+		quantization: quantization,
+		backend:      backend,
+		locator:      locator,
+		retry: retryPolicy{
+			maxAttempts: cfg.AppCfg.Retry.MaxAttempts,
+			backoff:     time.Duration(cfg.AppCfg.Retry.BackoffMinutes) * time.Minute,
+		},
+		places:       cfg.AppCfg.Places,
+		thumbSize:    cfg.AppCfg.Thumbnails.PregenerateSize,
+		embedModelID: cfg.AppCfg.ModelsURLs.EmbedModelID(),
+		queries:      newQueryCache(queryCacheSize),
+		ranked:       newRankedCache(rankedCacheSize),
+		anns:         make(map[string]*search.HNSW),
+		annMinImages: cfg.AppCfg.Search.ANNMinImages,
 	}
+	s.ladder = describeLadder(s.describer.Settings(), cfg.AppCfg.DescribeFallback)
+	s.analyzer = description.NewAnalyzer(description.AnalyzerConfig{
+		MinTokens: cfg.AppCfg.Quality.MinTokens,
+		Language:  cfg.AppCfg.Quality.Language,
+	})
+	s.retryBelow = cfg.AppCfg.Quality.RetryBelow
+	s.maxTiles, s.tileExpressions = cfg.AppCfg.Tiling.MaxTiles, cfg.AppCfg.Tiling.TileExpressions
+	s.maxFrames, s.frameExpressions = cfg.AppCfg.Animation.Frames, cfg.AppCfg.Animation.FrameExpressions
+	s.thumbs = thumbnail.New(thumbnail.Config{
+		Dir:        cfg.AppCfg.Thumbnails.Dir,
+		Quality:    cfg.AppCfg.Thumbnails.Quality,
+		Background: background,
+	})
+	s.indexes = newIndexCache(int64(cfg.AppCfg.Index.CacheMaxMB)<<20, s.locateIndex, s.readIndex, s.dropDerived)
 
 	if err := s.embedder.Load(ctx); err != nil {
 		return nil, fmt.Errorf("load embedder: %w", err)
@@ -118,6 +290,7 @@ type IndexResult struct {
 	Added        int // newly described, embedded, and saved images in this run
 	Failed       int // new images skipped after describe/embed errors in this run
 	Total        int // new images considered in this run
+	Skipped      int // images not retried yet after failing in earlier runs; see Failures
 }
 
 // IndexFolder indexes the images directly inside folderPath (non-recursive).
@@ -128,8 +301,43 @@ type IndexResult struct {
 // If progress is non-nil, it is invoked after each image is saved with a
 // running ETA. If ctx is cancelled, indexing returns ctx.Err() after the
 // in-flight image; all images saved up to that point are durable.
-func (s *Service) IndexFolder(ctx context.Context, folderPath string, progress IndexProgress) (IndexResult, error) {
-	total, err := s.countNewImages([]string{folderPath})
+//
+// The folder index is locked for the duration of the call. If another
+// process is indexing the folder, IndexFolder fails with an error matching
+// index.ErrLocked that names the process. If the lock is lost midway,
+// indexing stops as if ctx was cancelled and fails with an error matching
+// index.ErrLockLost.
+func (s *Service) IndexFolder(ctx context.Context, folderPath string, progress IndexProgress) (result IndexResult, err error) {
+	lock, err := s.lockIndex(folderPath)
+	if err != nil {
+		return IndexResult{}, err
+	}
+	defer func() {
+		s.indexes.unpin(filepath.Clean(folderPath))
+		if err := lock.Release(); err != nil {
+			s.log(ctx, "release index lock error", "folder", folderPath, "error", err)
+		}
+	}()
+
+	// Another process writes the index once it has taken the lock over, so
+	// stop writing it too.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lock.Lost():
+			s.log(ctx, "index lock lost, stopping", "folder", folderPath, "error", lock.Err())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer func() {
+		if lockErr := lock.Err(); lockErr != nil {
+			err = fmt.Errorf("index %q: %w", folderPath, lockErr)
+		}
+	}()
+
+	total, skipped, err := s.countNewImages([]string{folderPath})
 	if err != nil {
 		return IndexResult{}, err
 	}
@@ -140,27 +348,14 @@ func (s *Service) IndexFolder(ctx context.Context, folderPath string, progress I
 		if err != nil {
 			return IndexResult{}, err
 		}
-		s.log(ctx, "index folder", "folder", folderPath, "indexed images", idx.Len(), "new images", 0)
-		return IndexResult{IndexedTotal: idx.Len()}, nil
+		s.log(ctx, "index folder", "folder", folderPath, "indexed images", idx.Len(), "new images", 0, "skipped failed images", skipped)
+		return IndexResult{IndexedTotal: idx.Len(), Skipped: skipped}, nil
 	}
 
-	if err := s.describer.Load(ctx); err != nil {
-		return IndexResult{}, fmt.Errorf("load describer: %w", err)
+	if err := s.acquireVisionModels(ctx); err != nil {
+		return IndexResult{}, err
 	}
-	defer func() {
-		if err := s.describer.Unload(ctx); err != nil {
-			s.log(ctx, "unload describer error", "error", err)
-		}
-	}()
-
-	if err := s.categorizer.Load(ctx); err != nil {
-		return IndexResult{}, fmt.Errorf("load categorizer: %w", err)
-	}
-	defer func() {
-		if err := s.categorizer.Unload(ctx); err != nil {
-			s.log(ctx, "unload categorizer error", "error", err)
-		}
-	}()
+	defer s.releaseVisionModels(ctx)
 
 	tracker := &indexProgressTracker{callback: progress, total: total, folder: folderPath}
 	return s.indexFolder(ctx, folderPath, tracker)
@@ -193,23 +388,43 @@ func (s *Service) indexFolder(ctx context.Context, folderPath string, tracker *i
 		return IndexResult{}, fmt.Errorf("load index %q: %w", folderPath, err)
 	}
 
+	// The context is read once per run, so every image of the run is
+	// described with the same one.
+	folderContext, err := s.FolderContext(folderPath)
+	if err != nil {
+		return IndexResult{}, err
+	}
+	if folderContext != "" {
+		s.log(ctx, "index folder", "folder", folderPath, "context", folderContext)
+	}
+
+	// The ANN graph follows the index in memory through its listener, but is
+	// only written once per run: a stale graph file is repaired on load.
+	defer s.saveANN(ctx, filepath.Clean(folderPath))
+
 	// Per-image describe → categorize → embed → add → save. Saving after each image makes
 	// progress durable: a crash after image N leaves images 1..N persisted,
 	// and the existing-entry skip below resumes from N+1 on restart.
 	var sumTTFT, sumTPS, sumEmbedMS float64
 	described := 0
 	failed := 0
+	skipped := 0
 
 	for _, imgPath := range images {
 		if ctxErr := ctx.Err(); ctxErr != nil {
 			s.log(ctx, "index folder cancelled", "folder", folderPath, "indexed images", idx.Len(), "described in run", described)
-			return IndexResult{IndexedTotal: idx.Len(), Added: described, Failed: failed, Total: tracker.total}, ctxErr
+			return IndexResult{IndexedTotal: idx.Len(), Added: described, Failed: failed, Total: tracker.total, Skipped: skipped}, ctxErr
 		}
 
 		if _, exists := idx.Get(imgPath); exists {
 			s.log(ctx, "already indexed, skipping", "path", imgPath)
 			continue
 		}
+		if !s.retryDue(idx, imgPath) {
+			s.log(ctx, "failed before, not retrying yet", "path", imgPath)
+			skipped++
+			continue
+		}
 
 		imgStart := time.Now()
 		tracker.describing(imgPath)
@@ -217,35 +432,57 @@ func (s *Service) indexFolder(ctx context.Context, folderPath string, tracker *i
 		s.log(ctx, "\n::::::::::::")
 		s.log(ctx, "describe image", "path", imgPath)
 
-		imgCtx, imgCancel := context.WithTimeout(ctx, describeImageTimeout)
-		descResult, err := s.describer.Describe(imgCtx, imgPath)
-		imgCancel()
+		vars := s.promptVars(imgPath, folderContext)
+		descResult, rung, quality, err := s.describeImage(ctx, imgPath, vars)
 		if err != nil {
 			s.log(ctx, "describe error", "path", imgPath, "error", err)
 			failed++
 			tracker.recordFailed(imgPath, time.Since(imgStart), err)
+			s.recordFailure(ctx, idx, imgPath, FailureDescribe, err)
 			continue
 		}
 
+		// Done now so the decoded image is dropped before tiling decodes
+		// the file again.
+		s.pregenerateThumbnail(ctx, imgPath, descResult.Image)
+		descResult.Image = nil
+
+		tiles := s.describeTiles(ctx, imgPath, rung, vars)
+		frames := s.describeFrames(ctx, imgPath, rung, vars)
+
 		s.log(ctx, "categorize image", "path", imgPath)
 
 		catCtx, catCancel := context.WithTimeout(ctx, categorizeTimeout)
-		catResult, err := s.categorizer.Categorize(catCtx, descResult.Description)
+		catResult, err := s.categorizer.CategorizeWith(catCtx, descResult.Description, vars)
 		catCancel()
 		if err != nil {
 			s.log(ctx, "categorize error", "path", imgPath, "error", err)
 			failed++
 			tracker.recordFailed(imgPath, time.Since(imgStart), err)
+			s.recordFailure(ctx, idx, imgPath, FailureCategorize, err)
 			continue
 		}
 
 		s.log(ctx, "embed image", "path", imgPath)
 
 		embeddings, embedMS, err := s.embedExpressions(ctx, catResult.Expressions)
+		parts := append(tileParts(tiles, s.tileExpressions), frameParts(frames, s.frameExpressions)...)
+		if err == nil && len(parts) > 0 {
+			partEmbeddings, partMS := s.embedParts(ctx, imgPath, parts, vars, embeddings)
+			embeddings, embedMS = append(embeddings, partEmbeddings...), embedMS+partMS
+		}
+		if err == nil {
+			err = fitEmbeddings(embeddings, idx.Dim())
+		}
+		if errors.Is(err, embedding.ErrDimensionMismatch) {
+			// Every remaining image would fail the same way.
+			return IndexResult{IndexedTotal: idx.Len(), Added: described, Failed: failed, Total: tracker.total, Skipped: skipped}, err
+		}
 		if err != nil {
 			s.log(ctx, "embed error", "path", imgPath, "error", err)
 			failed++
 			tracker.recordFailed(imgPath, time.Since(imgStart), err)
+			s.recordFailure(ctx, idx, imgPath, FailureEmbed, err)
 			continue
 		}
 
@@ -253,13 +490,19 @@ func (s *Service) indexFolder(ctx context.Context, folderPath string, tracker *i
 
 		idx.Add(index.Entry{
 			Path:        imgPath,
-			Description: descResult.Description,
+			Description: description.MergeFrames(description.MergeTiles(descResult.Description, tiles), frames),
 			Embeddings:  embeddings,
+			Rung:        rung,
+
+			Quality:       float32(quality.Score),
+			QualityIssues: issueNames(quality.Issues),
+			ContextHash:   contextHash(folderContext),
 		})
 
 		if err := idx.Save(); err != nil {
-			return IndexResult{IndexedTotal: idx.Len(), Added: described, Failed: failed, Total: tracker.total}, fmt.Errorf("save index: %w", err)
+			return IndexResult{IndexedTotal: idx.Len(), Added: described, Failed: failed, Total: tracker.total, Skipped: skipped}, fmt.Errorf("save index: %w", err)
 		}
+		s.ranked.invalidate(filepath.Clean(folderPath))
 
 		sumTTFT += descResult.TimeToFirstTokenMS
 		sumTPS += descResult.TokensPerSecond
@@ -272,7 +515,7 @@ func (s *Service) indexFolder(ctx context.Context, folderPath string, tracker *i
 	}
 
 	total := idx.Len()
-	result := IndexResult{IndexedTotal: total, Added: described, Failed: failed, Total: tracker.total}
+	result := IndexResult{IndexedTotal: total, Added: described, Failed: failed, Total: tracker.total, Skipped: skipped}
 
 	// Log summary with timing breakdown from Kronk metrics.
 	if described > 0 {
@@ -292,30 +535,88 @@ func (s *Service) indexFolder(ctx context.Context, folderPath string, tracker *i
 		s.log(ctx, "\n=============\nindex folder", "folder", folderPath, "\nindexed images", total, "\ndescribed", described, "\nfailed", failed, "\nelapsed time", time.Since(start))
 	}
 
+	// Cumulative since the embedder was created: how long searches waited
+	// behind indexing, and indexing behind searches.
+	queue := s.embedder.Stats()
+	s.log(ctx, "embed queue",
+		"query calls", queue.Query.Calls,
+		"query waited", queue.Query.Waited,
+		"query total wait", queue.Query.TotalWait,
+		"query max wait", queue.Query.MaxWait,
+		"document calls", queue.Document.Calls,
+		"document waited", queue.Document.Waited,
+		"document total wait", queue.Document.TotalWait,
+		"document max wait", queue.Document.MaxWait)
+
 	return result, nil
 }
 
+// acquireVisionModels loads the describer and categorizer models if no other
+// caller holds them yet. Every successful call must be paired with
+// releaseVisionModels.
+func (s *Service) acquireVisionModels(ctx context.Context) error {
+	s.visionMu.Lock()
+	defer s.visionMu.Unlock()
+
+	if s.visionUsers == 0 {
+		if err := s.describer.Load(ctx); err != nil {
+			return fmt.Errorf("load describer: %w", err)
+		}
+		if err := s.categorizer.Load(ctx); err != nil {
+			if err := s.describer.Unload(ctx); err != nil {
+				s.log(ctx, "unload describer error", "error", err)
+			}
+			return fmt.Errorf("load categorizer: %w", err)
+		}
+	}
+	s.visionUsers++
+	return nil
+}
+
+// releaseVisionModels unloads the describer and categorizer models once the
+// last caller holding them is done.
+func (s *Service) releaseVisionModels(ctx context.Context) {
+	s.visionMu.Lock()
+	defer s.visionMu.Unlock()
+
+	s.visionUsers--
+	if s.visionUsers > 0 {
+		return
+	}
+	if err := s.describer.Unload(ctx); err != nil {
+		s.log(ctx, "unload describer error", "error", err)
+	}
+	if err := s.categorizer.Unload(ctx); err != nil {
+		s.log(ctx, "unload categorizer error", "error", err)
+	}
+}
+
 // countNewImages returns the total number of images across the given folders
-// that are not yet present in their per-folder index. Used to compute the
-// Total field of IndexProgressInfo before starting work.
-func (s *Service) countNewImages(folders []string) (int, error) {
-	total := 0
+// that are not yet present in their per-folder index, and how many of those
+// are left out because they failed before and aren't due for a retry. Used to
+// compute the Total field of IndexProgressInfo before starting work.
+func (s *Service) countNewImages(folders []string) (total, skipped int, err error) {
 	for _, dir := range folders {
 		images, err := findImagesIn(dir)
 		if err != nil {
-			return 0, fmt.Errorf("find images in %q: %w", dir, err)
+			return 0, 0, fmt.Errorf("find images in %q: %w", dir, err)
 		}
 		idx, err := s.loadIndex(dir)
 		if err != nil {
-			return 0, fmt.Errorf("load index %q: %w", dir, err)
+			return 0, 0, fmt.Errorf("load index %q: %w", dir, err)
 		}
 		for _, img := range images {
-			if _, exists := idx.Get(img); !exists {
+			if _, exists := idx.Get(img); exists {
+				continue
+			}
+			if s.retryDue(idx, img) {
 				total++
+			} else {
+				skipped++
 			}
 		}
 	}
-	return total, nil
+	return total, skipped, nil
 }
 
 // indexProgressTracker accumulates per-image timing for ETA computation and
@@ -439,72 +740,374 @@ func (s *Service) embedExpressions(ctx context.Context, expressions categorizati
 	return embeddings, totalMS, nil
 }
 
-// Search finds images similar to the query text in the given folder.
-// The folder must have been indexed first.
-func (s *Service) Search(ctx context.Context, folderPath string, query string, k int) ([]search.Result, error) {
+// SearchOptions narrows and orders the results of Search. The zero value
+// returns the top-k results by score regardless of how relevant they are.
+type SearchOptions struct {
+	MinScore     float32          // drop results whose raw score is below this
+	MinRelevance search.Relevance // drop results labeled less relevant than this
+	MinQuality   float32          // drop results whose description quality score is below this
+	Offset       int              // skip this many matching results, for paging
+	Exact        bool             // score every image even when the folder has an ANN graph
+	// Sort orders the matching results. Any order other than SortScore only
+	// makes sense among relevant results, so unless MinScore or MinRelevance
+	// is set it implies MinRelevance = search.RelevanceWeak.
+	Sort SortOrder
+}
+
+// SearchPage is one page of the results matching a search.
+type SearchPage struct {
+	Results []search.Result
+	Offset  int // position of Results[0] among all matching results
+	Total   int // number of matching results across all pages
+}
+
+// Search finds images similar to the query text in the given folder and
+// returns one page of at most k results. See SearchPage.
+func (s *Service) Search(ctx context.Context, folderPath string, query string, k int, opts SearchOptions) ([]search.Result, error) {
+	page, err := s.SearchPage(ctx, folderPath, query, k, opts)
+	return page.Results, err
+}
+
+// SearchPage finds images similar to the query text in the given folder.
+// The folder must have been indexed first. Every entry is scored, and the
+// best ones (up to rankedLimit) are ranked and calibrated against the query's
+// background similarity to the folder, so each result carries a Confidence and
+// Relevance label; opts can then drop weak matches, in which case fewer than k
+// results (possibly none) are returned.
+//
+// The full ranked set is cached per folder and query for a short time, so
+// requesting the next page or another sort order doesn't re-embed the query.
+func (s *Service) SearchPage(ctx context.Context, folderPath string, query string, k int, opts SearchOptions) (SearchPage, error) {
 
 	s.log(ctx, "\n::::::::::::")
-	s.log(ctx, "search images", "folder", folderPath, "top k", k, "query", query)
+	s.log(ctx, "search images", "folder", folderPath, "top k", k, "query", query, "offset", opts.Offset, "sort", opts.Sort, "min score", opts.MinScore, "min relevance", opts.MinRelevance, "min quality", opts.MinQuality)
+
+	key := rankedKey{folder: filepath.Clean(folderPath), query: query, exact: opts.Exact}
+	set, ok := s.ranked.get(key)
+	if !ok {
+		ranked, err := s.rank(ctx, key.folder, query, opts.Exact)
+		if err != nil {
+			return SearchPage{}, err
+		}
+		set = s.ranked.put(key, ranked)
+	} else {
+		s.log(ctx, "search cached", "ranked results", len(set.results))
+	}
+
+	if opts.Sort != SortScore && opts.MinScore == 0 && opts.MinRelevance == "" {
+		opts.MinRelevance = search.RelevanceWeak
+	}
+
+	var matching []search.Result
+	for _, img := range set.results {
+		if img.Score < opts.MinScore || !img.Relevance.AtLeast(opts.MinRelevance) || img.Quality < opts.MinQuality {
+			continue
+		}
+		matching = append(matching, img)
+	}
+	set.sortResults(matching, opts.Sort)
+
+	page := SearchPage{Offset: opts.Offset, Total: len(matching)}
+	if opts.Offset >= 0 && opts.Offset < len(matching) && k > 0 {
+		page.Results = matching[opts.Offset:min(opts.Offset+k, len(matching))]
+	}
+
+	for _, img := range page.Results {
+		s.log(ctx, "--"+filepath.Base(img.Path), "aggregate score", img.Score, "confidence", img.Confidence, "relevance", img.Relevance, "expressions scores", img.ExpressionScores)
+	}
+	s.log(ctx, "::::::::::::")
+
+	return page, nil
+}
+
+// rank embeds query and returns the best rankedLimit entries of the folder
+// index ranked by score and calibrated against the query's background
+// similarity. Small folders, and every folder when exact is set, are scored
+// exhaustively on the index matrix; large folders rank only the candidates
+// proposed by their ANN graph.
+func (s *Service) rank(ctx context.Context, folderPath string, query string, exact bool) ([]search.Result, error) {
+	queryVec, err := s.embedQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %w", err)
+	}
+
+	idx, err := s.loadIndex(folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("load index: %w", err)
+	}
+
+	matrix := idx.Matrix()
+	if matrix.Len() == 0 {
+		return nil, nil
+	}
+	if holder, ok := s.lockedByOther(folderPath); ok {
+		s.log(ctx, "folder is being indexed by another process, searching its last saved state", "pid", holder.PID, "host", holder.Host)
+	}
+
+	queryVec, err = fitDim(queryVec, idx.Dim())
+	if err != nil {
+		return nil, err
+	}
+
+	var results []search.Result
+	if graph := s.annFor(folderPath, idx); graph != nil && !exact {
+		var candidates []search.Entry
+		for _, p := range graph.Nearest(queryVec, annCandidates) {
+			if e, ok := idx.Get(p); ok {
+				candidates = append(candidates, toSearchEntry(e))
+			}
+		}
+		s.log(ctx, "search approximate", "candidates", len(candidates), "indexed images", matrix.Len())
+		results = search.FindTopK(queryVec, candidates, len(candidates))
+	} else {
+		results = matrix.TopK(queryVec, rankedLimit)
+	}
+
+	bg := matrix.Background(queryVec, s.calibration.Sample)
+	search.Calibrate(results, bg, s.calibration)
+	s.log(ctx, "search background", "mean", bg.Mean, "std dev", bg.StdDev, "sampled", bg.N)
+
+	return withRegions(idx, withQuality(idx, results), queryVec), nil
+}
+
+// embedQuery returns the embedding of a search query, from the query cache
+// when the same text was embedded recently.
+func (s *Service) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	key := queryKey{model: s.embedModelID, prefix: embedding.Prefix(embedding.Query), text: query}
+	if vec, ok := s.queries.get(key); ok {
+		s.log(ctx, "query embedding cached")
+		return vec, nil
+	}
 
 	embedCtx, embedCancel := context.WithTimeout(ctx, embedTimeout)
 	embedResult, err := s.embedder.Embed(embedCtx, embedding.Query, query)
 	embedCancel()
 	if err != nil {
-		return nil, fmt.Errorf("embed query: %w", err)
+		return nil, err
 	}
-	queryVec := embedResult.Embedding
+
+	s.queries.put(key, embedResult.Embedding)
+	return embedResult.Embedding, nil
+}
+
+// SimilarTo finds the images in folderPath most similar to the already
+// indexed image at imagePath, using its expression vectors as a multi-vector
+// query. The source image is never part of the results; when excludeNearDuplicates
+// is set, images scoring at or above search.NearDuplicateScore are dropped too.
+// No model is needed, so this works while the embedder is busy.
+func (s *Service) SimilarTo(ctx context.Context, folderPath string, imagePath string, k int, excludeNearDuplicates bool) ([]search.Result, error) {
+	s.log(ctx, "\n::::::::::::")
+	s.log(ctx, "similar images", "folder", folderPath, "top k", k, "image", imagePath)
 
 	idx, err := s.loadIndex(folderPath)
 	if err != nil {
 		return nil, fmt.Errorf("load index: %w", err)
 	}
 
-	entries := idx.All()
-	if len(entries) == 0 {
+	source, ok := idx.Get(imagePath)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotIndexed, imagePath)
+	}
+
+	queries := make([][]float32, 0, len(source.Embeddings))
+	for _, e := range source.Embeddings {
+		queries = append(queries, e.Vector)
+	}
+
+	// Rank everything first so near duplicates can be dropped without
+	// shrinking the result below k.
+	ranked := idx.Matrix().Similar(queries, idx.Len(), source.Path)
+	if len(ranked) == 0 {
 		return nil, nil
 	}
 
-	searchEntries := make([]search.Entry, 0, len(entries))
-	for _, e := range entries {
-		expressions := make([]search.ExpressionVector, 0, len(e.Embeddings))
-		for _, fe := range e.Embeddings {
-			expressions = append(expressions, search.ExpressionVector{
-				Expression: fe.Expression,
-				Vector:     fe.Vector,
-			})
-		}
-		searchEntries = append(searchEntries, search.Entry{
-			Path:        e.Path,
-			Description: e.Description,
-			Expressions: expressions,
-		})
+	results := make([]search.Result, 0, min(k, len(ranked)))
+	for _, r := range ranked {
+		if len(results) == k {
+			break
+		}
+		if excludeNearDuplicates && r.Score >= search.NearDuplicateScore {
+			s.log(ctx, "near duplicate skipped", "path", r.Path, "score", r.Score)
+			continue
+		}
+		results = append(results, r)
+	}
+	withQuality(idx, results)
+
+	for _, img := range results {
+		s.log(ctx, "--"+filepath.Base(img.Path), "similarity score", img.Score, "expressions scores", img.ExpressionScores)
 	}
+	s.log(ctx, "::::::::::::")
 
-	results := search.FindTopK(queryVec, searchEntries, k)
+	return results, nil
+}
+
+// SearchByImage finds images similar to an example image that doesn't need
+// to be indexed, such as an upload or a file outside the indexed folders. The
+// image goes through the same describe → categorize → embed pipeline as
+// IndexFolder, but nothing is stored; the resulting expression vectors are
+// used as a multi-vector query against every folder in folderPaths, and the
+// merged top-k results are returned.
+func (s *Service) SearchByImage(ctx context.Context, folderPaths []string, imagePath string, k int) ([]search.Result, error) {
+	if !image.IsSupported(filepath.Ext(imagePath)) {
+		return nil, fmt.Errorf("unsupported image type %q", filepath.Ext(imagePath))
+	}
+
+	s.log(ctx, "\n::::::::::::")
+	s.log(ctx, "search by image", "folders", folderPaths, "top k", k, "image", imagePath)
+
+	if err := s.acquireVisionModels(ctx); err != nil {
+		return nil, err
+	}
+	defer s.releaseVisionModels(ctx)
+
+	imgCtx, imgCancel := context.WithTimeout(ctx, describeImageTimeout)
+	descResult, err := s.describer.Describe(imgCtx, imagePath)
+	imgCancel()
+	if err != nil {
+		return nil, fmt.Errorf("describe: %w", err)
+	}
+
+	catCtx, catCancel := context.WithTimeout(ctx, categorizeTimeout)
+	catResult, err := s.categorizer.Categorize(catCtx, descResult.Description)
+	catCancel()
+	if err != nil {
+		return nil, fmt.Errorf("categorize: %w", err)
+	}
+
+	// Expressions are embedded as documents, not queries, so they land in the
+	// same space as the stored expression vectors they are compared with.
+	embeddings, _, err := s.embedExpressions(ctx, catResult.Expressions)
+	if err != nil {
+		return nil, fmt.Errorf("embed: %w", err)
+	}
+
+	queries := make([][]float32, 0, len(embeddings))
+	for _, e := range embeddings {
+		queries = append(queries, e.Vector)
+	}
+
+	var results []search.Result
+	for _, folder := range folderPaths {
+		idx, err := s.loadIndex(folder)
+		if err != nil {
+			return nil, fmt.Errorf("load index %q: %w", folder, err)
+		}
+		folderQueries := make([][]float32, 0, len(queries))
+		for _, q := range queries {
+			fq, err := fitDim(q, idx.Dim())
+			if err != nil {
+				return nil, fmt.Errorf("folder %q: %w", folder, err)
+			}
+			folderQueries = append(folderQueries, fq)
+		}
+		results = append(results, withQuality(idx, idx.Matrix().Similar(folderQueries, k, ""))...)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if k < len(results) {
+		results = results[:k]
+	}
 
 	for _, img := range results {
-		s.log(ctx, "--"+filepath.Base(img.Path), "aggregate score", img.Score, "expressions scores", img.ExpressionScores)
+		s.log(ctx, "--"+filepath.Base(img.Path), "similarity score", img.Score, "expressions scores", img.ExpressionScores)
 	}
 	s.log(ctx, "::::::::::::")
 
 	return results, nil
 }
 
-// IndexInfo returns the number of indexed images in a folder.
+// fitDim adapts vec to an index built with dim-dimensional vectors. A longer
+// vec is truncated Matryoshka-style, so an index built with a smaller
+// configured dimension stays searchable; a shorter one yields
+// embedding.ErrDimensionMismatch. A dim of 0 (empty index) accepts any vec.
+func fitDim(vec []float32, dim int) ([]float32, error) {
+	if dim == 0 || len(vec) == dim {
+		return vec, nil
+	}
+	out, err := embedding.Truncate(vec, dim)
+	if err != nil {
+		return nil, fmt.Errorf("index has %d dimensions: %w; re-index the folder or set embedModel.dimensions to %d",
+			dim, err, dim)
+	}
+	return out, nil
+}
+
+// fitEmbeddings applies fitDim to every embedding in place.
+func fitEmbeddings(embeddings []index.ExpressionEmbedding, dim int) error {
+	for i := range embeddings {
+		v, err := fitDim(embeddings[i].Vector, dim)
+		if err != nil {
+			return err
+		}
+		embeddings[i].Vector = v
+	}
+	return nil
+}
+
+// withQuality sets the description quality of results from their index
+// entries, and returns results.
+func withQuality(idx *index.Index, results []search.Result) []search.Result {
+	for i := range results {
+		if e, ok := idx.Get(results[i].Path); ok {
+			results[i].Quality = e.QualityScore()
+		}
+	}
+	return results
+}
+
+// toSearchEntry converts one index entry. Vectors are shared, not copied.
+func toSearchEntry(e index.Entry) search.Entry {
+	expressions := make([]search.ExpressionVector, 0, len(e.Embeddings))
+	for _, fe := range e.Embeddings {
+		expressions = append(expressions, search.ExpressionVector{
+			Expression: fe.Expression,
+			Vector:     fe.Vector,
+		})
+	}
+	return search.Entry{
+		Path:        e.Path,
+		Description: e.Description,
+		Expressions: expressions,
+	}
+}
+
+// IndexInfo returns the number of indexed images in a folder. A folder
+// without an index, or whose index can't be read, has none.
 func (s *Service) IndexInfo(folderPath string) int {
-	idx := index.New(indexPathFor(folderPath)) // TODO: Worth read from the cache?
-	idx.Load()
+	idx, err := s.indexes.get(filepath.Clean(folderPath), false)
+	if err != nil || idx == nil {
+		return 0
+	}
 	return idx.Len()
 }
 
+// DescribeRungs returns how many images of a folder were described at each
+// rung of the describe retry ladder, keyed by rung name.
+func (s *Service) DescribeRungs(folderPath string) map[string]int {
+	rungs := map[string]int{}
+	idx, err := s.indexes.get(filepath.Clean(folderPath), false)
+	if err != nil || idx == nil {
+		return rungs
+	}
+	for _, e := range idx.All() {
+		rungs[RungName(e.Rung)]++
+	}
+	return rungs
+}
+
 // IndexedPaths returns the set of image paths that have been indexed in a folder.
 func (s *Service) IndexedPaths(folderPath string) map[string]bool {
-	idx := index.New(indexPathFor(folderPath)) // TODO: Worth read from the cache?
-	idx.Load()
+	idx, err := s.indexes.get(filepath.Clean(folderPath), false)
+	if err != nil || idx == nil {
+		return map[string]bool{}
+	}
 
 	paths := make(map[string]bool, idx.Len())
-	for _, e := range idx.All() {
-		paths[e.Path] = true
+	for _, path := range idx.Paths() {
+		paths[path] = true
 	}
 	return paths
 }
@@ -521,38 +1124,128 @@ func (s *Service) Close(ctx context.Context) error {
 }
 
 // loadIndex returns the cached index for folderPath, loading it from disk on
-// first access. The returned pointer is shared with the cache, so subsequent
-// Add/Save calls on it are visible to later searches without any extra work.
+// first access or when the file changed since it was loaded. The returned
+// pointer is shared with the cache, so subsequent Add/Save calls on it are
+// visible to later searches without any extra work.
 // Folders that have no .locallens.index file yet still get a cached empty
 // Index — this is the desired behavior for IndexFolder, which then populates
 // and saves it. Search filters un-indexed folders out before calling this.
 func (s *Service) loadIndex(folderPath string) (*index.Index, error) {
+	idx, err := s.indexes.get(filepath.Clean(folderPath), true)
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	return idx, nil
+}
+
+// readIndex reads the index of a folder from disk for the cache. New indexes
+// get the configured quantization.
+func (s *Service) readIndex(key, indexPath string) (*index.Index, error) {
+	idx := index.NewWithStore(index.NewStore(s.backend, indexPath))
+	if err := idx.Load(); err != nil {
+		return nil, err
+	}
+	if idx.Len() == 0 {
+		idx.SetQuantization(s.quantization)
+	}
+	return idx, nil
+}
+
+// dropDerived drops the ANN graph and ranked results computed from a folder
+// index the cache reloaded or evicted. A graph still being built notices on
+// its own; see buildANN.
+func (s *Service) dropDerived(key string) {
+	s.annMu.Lock()
+	if graph, ok := s.anns[key]; ok && graph != nil {
+		delete(s.anns, key)
+	}
+	s.annMu.Unlock()
+
+	s.ranked.invalidate(key)
+}
+
+// lockIndex takes the cross-process lock of a folder index and pins the index
+// in the cache until IndexFolder unpins it. Pinning reloads the index if
+// another process saved it since it was cached; while the lock is held, only
+// this process writes it.
+func (s *Service) lockIndex(folderPath string) (*index.Lock, error) {
 	key := filepath.Clean(folderPath)
 
-	s.mu.RLock()
-	idx, ok := s.indexes[key]
-	s.mu.RUnlock()
-	if ok {
-		return idx, nil
+	indexPath, err := s.indexPath(key)
+	if err != nil {
+		return nil, fmt.Errorf("locate index %q: %w", folderPath, err)
+	}
+	// Indexes kept outside the image folder live in a directory of their own.
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0755); err != nil {
+		return nil, fmt.Errorf("create index dir: %w", err)
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	lock, err := index.AcquireLock(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("lock index %q: %w", folderPath, err)
+	}
 
-	if idx, ok := s.indexes[key]; ok {
-		return idx, nil
+	if _, err := s.indexes.pin(key); err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("load index %q: %w", folderPath, err)
 	}
+	return lock, nil
+}
 
-	idx = index.New(indexPathFor(key))
-	if err := idx.Load(); err != nil {
-		return nil, fmt.Errorf("load: %w", err)
+// lockedByOther returns the process indexing a folder, if it isn't this one.
+// Searches still read such a folder, but write nothing next to its index.
+func (s *Service) lockedByOther(folderPath string) (index.LockInfo, bool) {
+	indexPath, err := s.indexPath(folderPath)
+	if err != nil {
+		return index.LockInfo{}, false
 	}
-	s.indexes[key] = idx
-	return idx, nil
+	holder, held, err := index.ReadLock(indexPath)
+	if err != nil || !held || holder.Ours() {
+		return index.LockInfo{}, false
+	}
+	return holder, true
 }
 
-func indexPathFor(folderPath string) string {
-	return filepath.Join(folderPath, indexFileName)
+// indexPath returns the index file path of a folder, following the
+// configured index location and store.
+func (s *Service) indexPath(folderPath string) (string, error) {
+	dir, err := s.locator.Dir(folderPath)
+	if err != nil {
+		return "", err
+	}
+	if s.backend == index.BackendSQLite {
+		return filepath.Join(dir, sqliteIndexFileName), nil
+	}
+	return filepath.Join(dir, indexFileName), nil
+}
+
+// locateIndex returns the index file path of a folder for the index cache. With
+// the sqlite store, a folder that only has a gob index gets it copied over
+// first; the gob file is left in place.
+func (s *Service) locateIndex(folderPath string) (string, error) {
+	indexPath, err := s.indexPath(folderPath)
+	if err != nil || s.backend != index.BackendSQLite {
+		return indexPath, err
+	}
+	if _, err := os.Stat(indexPath); !errors.Is(err, fs.ErrNotExist) {
+		return indexPath, nil
+	}
+	gobPath := filepath.Join(filepath.Dir(indexPath), indexFileName)
+	if _, err := os.Stat(gobPath); err != nil {
+		return indexPath, nil
+	}
+
+	tmp := indexPath + ".tmp"
+	os.Remove(tmp)
+	if err := index.Copy(index.NewSQLStore(tmp), index.NewGobStore(gobPath)); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("copy gob index to sqlite: %w", err)
+	}
+	if err := os.Rename(tmp, indexPath); err != nil {
+		return "", fmt.Errorf("copy gob index to sqlite: %w", err)
+	}
+	s.log(context.Background(), "gob index copied to sqlite", "folder", folderPath, "index", indexPath)
+	return indexPath, nil
 }
 
 // findImagesIn returns the image files directly inside folderPath (non-recursive).
@@ -567,17 +1260,9 @@ func findImagesIn(folderPath string) ([]string, error) {
 		if e.IsDir() {
 			continue
 		}
-		if isImageExt(filepath.Ext(e.Name())) {
+		if image.IsSupported(filepath.Ext(e.Name())) {
 			images = append(images, filepath.Join(folderPath, e.Name()))
 		}
 	}
 	return images, nil
 }
-
-func isImageExt(ext string) bool {
-	switch strings.ToLower(ext) {
-	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp":
-		return true
-	}
-	return false
-}
@@ -4,6 +4,7 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/ramon-reichert/locallens/internal/service"
+	"github.com/ramon-reichert/locallens/internal/service/index"
 	"github.com/ramon-reichert/locallens/internal/service/tests/testsboot"
 )
 
@@ -188,7 +190,7 @@ func TestIndexPersistence(t *testing.T) {
 func TestSearch(t *testing.T) {
 	ctx := context.Background()
 
-	results, err := svc.Search(ctx, testFolder, "any image", 3)
+	results, err := svc.Search(ctx, testFolder, "any image", 3, service.SearchOptions{})
 	if err != nil {
 		t.Fatalf("search: %v", err)
 	}
@@ -209,7 +211,7 @@ func TestSearch(t *testing.T) {
 
 	// Request more than available returns all
 	totalImages := svc.IndexInfo(testFolder)
-	allResults, err := svc.Search(ctx, testFolder, "anything", totalImages+10)
+	allResults, err := svc.Search(ctx, testFolder, "anything", totalImages+10, service.SearchOptions{})
 	if err != nil {
 		t.Fatalf("search all: %v", err)
 	}
@@ -218,12 +220,45 @@ func TestSearch(t *testing.T) {
 	}
 }
 
+func TestSearchPagination(t *testing.T) {
+	ctx := context.Background()
+
+	all, err := svc.SearchPage(ctx, testFolder, "people outdoors", 100, service.SearchOptions{})
+	if err != nil {
+		t.Fatalf("search all: %v", err)
+	}
+	if all.Total != len(all.Results) {
+		t.Fatalf("Total = %d, want %d", all.Total, len(all.Results))
+	}
+
+	// Paging through the cached ranked set must reproduce the full order.
+	var paged []string
+	for offset := 0; offset < all.Total; offset += 2 {
+		page, err := svc.SearchPage(ctx, testFolder, "people outdoors", 2, service.SearchOptions{Offset: offset})
+		if err != nil {
+			t.Fatalf("search page at %d: %v", offset, err)
+		}
+		for _, r := range page.Results {
+			paged = append(paged, r.Path)
+		}
+	}
+
+	if len(paged) != len(all.Results) {
+		t.Fatalf("paged %d results, want %d", len(paged), len(all.Results))
+	}
+	for i, r := range all.Results {
+		if paged[i] != r.Path {
+			t.Errorf("position %d: paged %s, want %s", i, filepath.Base(paged[i]), filepath.Base(r.Path))
+		}
+	}
+}
+
 func TestSearchExpectedOrder(t *testing.T) {
 	ctx := context.Background()
 
 	for _, tc := range expectedSearchResults {
 		t.Run(tc.query, func(t *testing.T) {
-			results, err := svc.Search(ctx, testFolder, tc.query, mustMatch)
+			results, err := svc.Search(ctx, testFolder, tc.query, mustMatch, service.SearchOptions{})
 			if err != nil {
 				t.Fatalf("search: %v", err)
 			}
@@ -247,12 +282,58 @@ func TestSearchExpectedOrder(t *testing.T) {
 	}
 }
 
+func TestSearchByImage(t *testing.T) {
+	ctx := context.Background()
+
+	// The example image lives outside the indexed folder, as an upload would.
+	results, err := svc.SearchByImage(ctx, []string{testFolder}, filepath.Join("testdata", "parrot.jpg"), mustMatch)
+	if err != nil {
+		t.Fatalf("search by image: %v", err)
+	}
+
+	for i, r := range results {
+		t.Logf("  [%d] %.4f %s", i, r.Score, filepath.Base(r.Path))
+	}
+
+	if len(results) == 0 {
+		t.Fatal("expected results, got 0")
+	}
+	if got := filepath.Base(results[0].Path); got != "parrot.jpg" {
+		t.Errorf("expected parrot.jpg as top result, got %s (score: %.4f)", got, results[0].Score)
+	}
+}
+
+func TestSimilarTo(t *testing.T) {
+	ctx := context.Background()
+
+	source := filepath.Join(testFolder, "parrot.jpg")
+	results, err := svc.SimilarTo(ctx, testFolder, source, 3, true)
+	if err != nil {
+		t.Fatalf("similar to: %v", err)
+	}
+
+	if len(results) == 0 {
+		t.Fatal("expected similar images, got none")
+	}
+	if len(results) > 3 {
+		t.Errorf("expected at most 3 results, got %d", len(results))
+	}
+	for i, r := range results {
+		if r.Path == source {
+			t.Errorf("source image must not be part of the results")
+		}
+		if i > 0 && r.Score > results[i-1].Score {
+			t.Errorf("results not sorted by score: %s (%.3f) after %s (%.3f)", r.Path, r.Score, results[i-1].Path, results[i-1].Score)
+		}
+	}
+}
+
 func TestSearchEmptyIndex(t *testing.T) {
 	ctx := context.Background()
 
 	emptyDir := t.TempDir()
 
-	results, err := svc.Search(ctx, emptyDir, "anything", 5)
+	results, err := svc.Search(ctx, emptyDir, "anything", 5, service.SearchOptions{})
 	if err != nil {
 		t.Fatalf("search: %v", err)
 	}
@@ -262,6 +343,27 @@ func TestSearchEmptyIndex(t *testing.T) {
 	}
 }
 
+func TestIndexFolderLocked(t *testing.T) {
+	lock, err := index.AcquireLock(filepath.Join(testFolder, ".locallens.index"))
+	if err != nil {
+		t.Fatalf("acquire lock: %v", err)
+	}
+	defer lock.Release()
+
+	_, err = svc.IndexFolder(context.Background(), testFolder, nil)
+	if !errors.Is(err, index.ErrLocked) {
+		t.Fatalf("expected ErrLocked while the folder is locked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprint(os.Getpid())) {
+		t.Errorf("expected the error to name the holder PID, got %q", err)
+	}
+
+	// Searches keep working on the locked folder.
+	if _, err := svc.Search(context.Background(), testFolder, "parrot", 1, service.SearchOptions{}); err != nil {
+		t.Errorf("search on a locked folder: %v", err)
+	}
+}
+
 // copyImages copies image files from src to dst directory.
 func copyImages(src, dst string) error {
 	entries, err := os.ReadDir(src)
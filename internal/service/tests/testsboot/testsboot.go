@@ -3,6 +3,7 @@ package testsboot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"sync"
@@ -32,6 +33,9 @@ func Boot() {
 		if err != nil {
 			fmt.Printf("config warning, using defaults: %v\n", err)
 		}
+		if errors.Is(err, config.ErrInvalidPrompt) {
+			Cfg = Cfg.WithDefaultPrompts()
+		}
 
 		if v := os.Getenv("KRONK_BASE_PATH"); v != "" {
 			Cfg.BasePath = v
@@ -0,0 +1,202 @@
+// Package thumbnail generates and caches the small JPEG previews the UI shows
+// instead of full-size originals.
+package thumbnail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// Sizes are the longest sides, in pixels, thumbnails are generated at.
+// Requested sizes are rounded up to one of them, so the cache holds a few
+// versions of an image at most.
+var Sizes = []int{128, 256, 512, 1024}
+
+const (
+	// DefaultSize is the thumbnail size of the result grid cards.
+	DefaultSize = 256
+	// DefaultQuality is the JPEG quality thumbnails are encoded with when
+	// none is configured.
+	DefaultQuality = 80
+)
+
+// fingerprintChunk is how much of the start and of the end of a file its
+// fingerprint covers. Edits to photos rewrite their metadata, at the start,
+// or their compressed data, which runs to the end.
+const fingerprintChunk = 64 << 10
+
+// ErrInvalidSize is returned for thumbnail sizes that are not positive.
+var ErrInvalidSize = errors.New("invalid thumbnail size")
+
+// Cache generates thumbnails on demand and keeps them on disk, keyed by a
+// fingerprint of the image file, so renamed or moved images reuse their
+// thumbnails and edited ones get new ones. Thumbnails are never pruned;
+// removing the directory is safe.
+type Cache struct {
+	dir  string
+	opts image.Options
+
+	// variant is part of every key, so changing the encoding settings
+	// doesn't serve thumbnails made with the old ones.
+	variant string
+}
+
+// Config holds configuration for creating a Cache.
+type Config struct {
+	Dir string
+	// Quality is the JPEG quality, from 1 to 100. 0 means DefaultQuality.
+	Quality int
+	// Background is the color transparent areas are flattened onto. Nil
+	// means white. See image.Options.
+	Background color.Color
+}
+
+// New creates a Cache storing thumbnails under cfg.Dir.
+func New(cfg Config) *Cache {
+	quality := cfg.Quality
+	if quality <= 0 || quality > 100 {
+		quality = DefaultQuality
+	}
+
+	variant := strconv.Itoa(quality)
+	if cfg.Background != nil {
+		r, g, b, _ := cfg.Background.RGBA()
+		variant += fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+	}
+
+	return &Cache{
+		dir:     cfg.Dir,
+		opts:    image.Options{Background: cfg.Background, Quality: quality},
+		variant: variant,
+	}
+}
+
+// Get returns the path of a JPEG thumbnail of the image at imgPath, turned
+// upright, whose longest side is size rounded up to one of Sizes. Images
+// smaller than that are not enlarged. The thumbnail is generated on the first
+// call and read from the cache afterwards.
+func (c *Cache) Get(imgPath string, size int) (string, error) {
+	return c.get(imgPath, size, func(opts image.Options) ([]byte, error) {
+		return image.ResizeWith(imgPath, opts)
+	})
+}
+
+// GetFrom is Get for an image already decoded as src, which is encoded if
+// the thumbnail isn't cached yet instead of decoding imgPath again.
+func (c *Cache) GetFrom(imgPath string, src *image.Source, size int) (string, error) {
+	return c.get(imgPath, size, src.Encode)
+}
+
+func (c *Cache) get(imgPath string, size int, encode func(image.Options) ([]byte, error)) (string, error) {
+	size, err := fitSize(size)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := fingerprint(imgPath, c.variant)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(c.dir, key[:2], key+"-"+strconv.Itoa(size)+".jpg")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	opts := c.opts
+	opts.MaxSide = size
+	data, err := encode(opts)
+	if err != nil {
+		return "", fmt.Errorf("thumbnail %s: %w", imgPath, err)
+	}
+
+	if err := writeFile(path, data); err != nil {
+		return "", fmt.Errorf("thumbnail %s: %w", imgPath, err)
+	}
+	return path, nil
+}
+
+// fitSize rounds size up to the nearest of Sizes, capped at the largest.
+func fitSize(size int) (int, error) {
+	if size <= 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidSize, size)
+	}
+	for _, s := range Sizes {
+		if size <= s {
+			return s, nil
+		}
+	}
+	return Sizes[len(Sizes)-1], nil
+}
+
+// fingerprint hashes the size and modification time of the file at path and
+// its first and last fingerprintChunk bytes, along with variant. Reading the
+// ends only keeps it cheap for large photos; the modification time catches
+// edits in between. Moves and renames keep it.
+func fingerprint(path, variant string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00", variant, fi.Size(), fi.ModTime().UnixNano())
+	if fi.Size() <= 2*fingerprintChunk {
+		_, err = io.Copy(h, f)
+	} else {
+		_, err = io.CopyN(h, f, fingerprintChunk)
+		if err == nil {
+			_, err = f.Seek(-fingerprintChunk, io.SeekEnd)
+		}
+		if err == nil {
+			_, err = io.Copy(h, f)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("read: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// writeFile writes data to a temporary file next to path and renames it into
+// place, so concurrent requests for the same thumbnail never read a partial
+// one.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create dir: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"errors"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+	"github.com/ramon-reichert/locallens/internal/service/thumbnail"
+)
+
+func writePNG(t *testing.T, path string, w, h int, c color.Color) {
+	t.Helper()
+	img := goimage.NewRGBA(goimage.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func bounds(t *testing.T, path string) goimage.Rectangle {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read thumbnail: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	return img.Bounds()
+}
+
+func TestGet(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, 800, 400, color.RGBA{R: 200, A: 255})
+
+	cache := thumbnail.New(thumbnail.Config{Dir: filepath.Join(dir, "thumbs")})
+
+	// 200 is rounded up to 256.
+	thumb, err := cache.Get(src, 200)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if b := bounds(t, thumb); b.Dx() != 256 || b.Dy() != 128 {
+		t.Errorf("expected 256x128, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	again, err := cache.Get(src, 256)
+	if err != nil {
+		t.Fatalf("get again: %v", err)
+	}
+	if again != thumb {
+		t.Errorf("expected the cached thumbnail %s, got %s", thumb, again)
+	}
+
+	large, err := cache.Get(src, 5000)
+	if err != nil {
+		t.Fatalf("get large: %v", err)
+	}
+	if b := bounds(t, large); b.Dx() != 800 {
+		t.Errorf("expected the original width 800, got %d", b.Dx())
+	}
+}
+
+func TestGet_KeyedByContent(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, 300, 300, color.RGBA{G: 200, A: 255})
+
+	cache := thumbnail.New(thumbnail.Config{Dir: filepath.Join(dir, "thumbs")})
+	thumb, err := cache.Get(src, 128)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// A moved image keeps its thumbnail.
+	moved := filepath.Join(dir, "moved.png")
+	if err := os.Rename(src, moved); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	got, err := cache.Get(moved, 128)
+	if err != nil {
+		t.Fatalf("get moved: %v", err)
+	}
+	if got != thumb {
+		t.Errorf("expected the moved image to reuse %s, got %s", thumb, got)
+	}
+
+	// An edited image gets a new one.
+	writePNG(t, moved, 300, 300, color.RGBA{B: 200, A: 255})
+	got, err = cache.Get(moved, 128)
+	if err != nil {
+		t.Fatalf("get edited: %v", err)
+	}
+	if got == thumb {
+		t.Error("expected a new thumbnail for the edited image")
+	}
+}
+
+func TestGet_ModifiedInPlace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, 300, 300, color.RGBA{G: 200, A: 255})
+
+	cache := thumbnail.New(thumbnail.Config{Dir: filepath.Join(dir, "thumbs")})
+	thumb, err := cache.Get(src, 128)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	// An edit between the fingerprinted ends only shows in the mtime.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(src, later, later); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	got, err := cache.Get(src, 128)
+	if err != nil {
+		t.Fatalf("get modified: %v", err)
+	}
+	if got == thumb {
+		t.Error("expected a new thumbnail for the modified image")
+	}
+}
+
+func TestGetFrom(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.png")
+	writePNG(t, path, 800, 400, color.RGBA{R: 200, A: 255})
+
+	src, err := image.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	cache := thumbnail.New(thumbnail.Config{Dir: filepath.Join(dir, "thumbs")})
+	thumb, err := cache.GetFrom(path, src, 256)
+	if err != nil {
+		t.Fatalf("get from: %v", err)
+	}
+	if b := bounds(t, thumb); b.Dx() != 256 || b.Dy() != 128 {
+		t.Errorf("expected 256x128, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	got, err := cache.Get(path, 256)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got != thumb {
+		t.Errorf("expected Get to find %s, got %s", thumb, got)
+	}
+}
+
+func TestGet_InvalidSize(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "photo.png")
+	writePNG(t, src, 10, 10, color.White)
+
+	cache := thumbnail.New(thumbnail.Config{Dir: dir})
+	if _, err := cache.Get(src, 0); !errors.Is(err, thumbnail.ErrInvalidSize) {
+		t.Errorf("expected ErrInvalidSize, got %v", err)
+	}
+}
+
+func TestGet_FileNotFound(t *testing.T) {
+	dir := t.TempDir()
+	cache := thumbnail.New(thumbnail.Config{Dir: dir})
+	if _, err := cache.Get(filepath.Join(dir, "missing.png"), 128); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
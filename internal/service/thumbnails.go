@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ramon-reichert/locallens/internal/service/image"
+)
+
+// Thumbnail returns the path of a cached JPEG thumbnail of the image at
+// imgPath, about size pixels on its longest side. See thumbnail.Cache.Get.
+func (s *Service) Thumbnail(imgPath string, size int) (string, error) {
+	if !image.IsSupported(filepath.Ext(imgPath)) {
+		return "", fmt.Errorf("thumbnail %s: %w", imgPath, image.ErrUnsupportedFormat)
+	}
+	return s.thumbs.Get(imgPath, size)
+}
+
+// pregenerateThumbnail generates the thumbnail of an image being indexed,
+// from src, the image already decoded to describe it, so the UI doesn't have
+// to wait for it. Failures are only logged: the thumbnail is generated again
+// on request.
+func (s *Service) pregenerateThumbnail(ctx context.Context, imgPath string, src *image.Source) {
+	if s.thumbSize <= 0 || src == nil {
+		return
+	}
+	if _, err := s.thumbs.GetFrom(imgPath, src, s.thumbSize); err != nil {
+		s.log(ctx, "thumbnail error", "path", imgPath, "error", err)
+	}
+}